@@ -21,10 +21,13 @@ import (
 	"go.uber.org/automaxprocs/maxprocs"
 
 	"github.com/ewohltman/ephemeral-roles/internal/pkg/callbacks"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/capacity"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/config"
 	internalHTTP "github.com/ewohltman/ephemeral-roles/internal/pkg/http"
 	"github.com/ewohltman/ephemeral-roles/internal/pkg/logging"
 	"github.com/ewohltman/ephemeral-roles/internal/pkg/monitor"
 	"github.com/ewohltman/ephemeral-roles/internal/pkg/operations"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/rolemap"
 	"github.com/ewohltman/ephemeral-roles/internal/pkg/tracer"
 )
 
@@ -35,17 +38,37 @@ const (
 )
 
 type environmentVariables struct {
-	BotToken             string `env:"BOT_TOKEN,required"`
-	LogLevel             string `env:"LOG_LEVEL" envDefault:"info"`
-	LogTimezoneLocation  string `env:"LOG_TIMEZONE_LOCATION" envDefault:"UTC"`
-	DiscordrusWebHookURL string `env:"DISCORDRUS_WEBHOOK_URL"`
-	Port                 string `env:"PORT" envDefault:"8081"`
-	BotName              string `env:"BOT_NAME" envDefault:"Ephemeral Roles"`
-	BotKeyword           string `env:"BOT_KEYWORD" envDefault:"声がない"`
-	RolePrefix           string `env:"ROLE_PREFIX" envDefault:"[声無し用]"`
-	RoleColor            int    `env:"ROLE_COLOR_HEX2DEC" envDefault:"16753920"`
-	InstanceName         string `env:"INSTANCE_NAME" envDefault:"ephemeral-roles-0"`
-	ShardCount           int    `env:"SHARD_COUNT" envDefault:"1"`
+	BotToken             string        `env:"BOT_TOKEN,required"`
+	LogLevel             string        `env:"LOG_LEVEL" envDefault:"info"`
+	LogTimezoneLocation  string        `env:"LOG_TIMEZONE_LOCATION" envDefault:"UTC"`
+	DiscordrusWebHookURL string        `env:"DISCORDRUS_WEBHOOK_URL"`
+	Port                 string        `env:"PORT" envDefault:"8081"`
+	BotName              string        `env:"BOT_NAME" envDefault:"Ephemeral Roles"`
+	BotKeyword           string        `env:"BOT_KEYWORD" envDefault:"声がない"`
+	RolePrefix           string        `env:"ROLE_PREFIX" envDefault:"[声無し用]"`
+	RoleColor            int           `env:"ROLE_COLOR_HEX2DEC" envDefault:"16753920"`
+	InstanceName         string        `env:"INSTANCE_NAME" envDefault:"ephemeral-roles-0"`
+	ShardCount           int           `env:"SHARD_COUNT" envDefault:"1"`
+	ReconcileInterval    time.Duration `env:"RECONCILE_INTERVAL" envDefault:"15m"`
+	GCInterval           time.Duration `env:"GC_INTERVAL" envDefault:"1h"`
+	GCDryRun             bool          `env:"GC_DRY_RUN" envDefault:"false"`
+	GCDryRunGuilds       []string      `env:"GC_DRY_RUN_GUILDS" envSeparator:","`
+	DeleteOnEmpty        bool          `env:"DELETE_ON_EMPTY" envDefault:"false"`
+	DeleteOnEmptyGuilds  []string      `env:"DELETE_ON_EMPTY_GUILDS" envSeparator:","`
+	DeleteOnEmptyDelay   time.Duration `env:"DELETE_ON_EMPTY_DELAY" envDefault:"5m"`
+	RoleRemovalGrace     time.Duration `env:"ROLE_REMOVAL_GRACE" envDefault:"0s"`
+	OperationsWorkers    int           `env:"OPERATIONS_WORKERS" envDefault:"10"`
+	OperationsGuildQueue int           `env:"OPERATIONS_MAX_GUILD_QUEUE" envDefault:"1000"`
+	OperationsAttempts   int           `env:"OPERATIONS_MAX_ATTEMPTS" envDefault:"4"`
+	OperationsBaseDelay  time.Duration `env:"OPERATIONS_RETRY_BASE_DELAY" envDefault:"250ms"`
+	OperationsMaxDelay   time.Duration `env:"OPERATIONS_RETRY_MAX_DELAY" envDefault:"5s"`
+	BreakerThreshold     int           `env:"BREAKER_THRESHOLD" envDefault:"5"`
+	BreakerCoolDown      time.Duration `env:"BREAKER_COOL_DOWN" envDefault:"10m"`
+	BotOwners            []string      `env:"BOT_OWNERS" envSeparator:","`
+	SlashCommands        bool          `env:"SLASH_COMMANDS" envDefault:"true"`
+	SlashCommandGuilds   []string      `env:"SLASH_COMMAND_GUILDS" envSeparator:","`
+	GuildConfigPath      string        `env:"GUILD_CONFIG_PATH"`
+	RoleMapPath          string        `env:"ROLE_MAP_PATH"`
 	shardID              int
 }
 
@@ -71,12 +94,12 @@ func startSession(
 	envVars *environmentVariables,
 	client *http.Client,
 	jaegerTracer opentracing.Tracer,
-) (*discordgo.Session, error) {
+) (*discordgo.Session, []internalHTTP.OptionFunc, error) {
 	discordgo.Logger = log.DiscordGoLogf
 
 	session, err := discordgo.New("Bot " + envVars.BotToken)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	session.Client = client
@@ -93,41 +116,140 @@ func startSession(
 		Interval: monitorInterval,
 	})
 
-	setupCallbackHandler(session,
-		&callbacks.Handler{
-			Log:                     log,
-			BotName:                 envVars.BotName,
-			BotKeyword:              envVars.BotKeyword,
-			RolePrefix:              envVars.RolePrefix,
-			RoleColor:               envVars.RoleColor,
-			JaegerTracer:            jaegerTracer,
-			ContextTimeout:          contextTimeout,
-			ReadyCounter:            callbackMetrics.ReadyCounter,
-			MessageCreateCounter:    callbackMetrics.MessageCreateCounter,
-			VoiceStateUpdateCounter: callbackMetrics.VoiceStateUpdateCounter,
-			OperationsGateway:       operations.NewGateway(session),
+	circuitBreaker := operations.NewCircuitBreaker(
+		envVars.BreakerThreshold,
+		envVars.BreakerCoolDown,
+		callbackMetrics.OpenCircuitBreakersGauge,
+	)
+
+	operationsGateway := operations.NewGateway(
+		session,
+		operations.WithWorkers(envVars.OperationsWorkers),
+		operations.WithMaxGuildQueue(envVars.OperationsGuildQueue),
+		operations.WithQueueMetrics(callbackMetrics.QueueDepthGauge, callbackMetrics.QueueWaitHistogram),
+		operations.WithRetryPolicy(operations.RetryPolicy{
+			MaxAttempts: envVars.OperationsAttempts,
+			BaseDelay:   envVars.OperationsBaseDelay,
+			MaxDelay:    envVars.OperationsMaxDelay,
+		}),
+		operations.WithAttemptMetrics(callbackMetrics.OperationAttemptsCounter),
+		operations.WithCircuitBreaker(circuitBreaker),
+	)
+
+	guildConfigs, err := newGuildConfigStore(envVars.GuildConfigPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	roleMap, err := newRoleMap(log, envVars.RoleMapPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	callbackHandler := &callbacks.Handler{
+		Log:                      log,
+		BotName:                  envVars.BotName,
+		BotKeyword:               envVars.BotKeyword,
+		RolePrefix:               envVars.RolePrefix,
+		RoleColor:                envVars.RoleColor,
+		JaegerTracer:             jaegerTracer,
+		ContextTimeout:           contextTimeout,
+		ReadyCounter:             callbackMetrics.ReadyCounter,
+		MessageCreateCounter:     callbackMetrics.MessageCreateCounter,
+		InteractionCreateCounter: callbackMetrics.InteractionCreateCounter,
+		VoiceStateUpdateCounter:  callbackMetrics.VoiceStateUpdateCounter,
+		ReconcileAddedCounter:    callbackMetrics.ReconcileAddedCounter,
+		ReconcileRemovedCounter:  callbackMetrics.ReconcileRemovedCounter,
+		RoleEvictionCounter:      callbackMetrics.RoleEvictionCounter,
+		OperationsGateway:        operationsGateway,
+		RoleMap:                  roleMap,
+		Capacity:                 capacity.NewManager(),
+		EmptyRoleDeleter: callbacks.NewEmptyRoleDeleter(
+			envVars.DeleteOnEmpty,
+			envVars.DeleteOnEmptyGuilds,
+			envVars.DeleteOnEmptyDelay,
+		),
+		RoleRemovalGrace: callbacks.NewRoleRemovalGrace(envVars.RoleRemovalGrace),
+		RecentErrors:     callbacks.NewRecentErrors(callbacks.DefaultRecentErrors),
+		Authorizer:       callbacks.NewAuthorizer(envVars.BotOwners),
+		Commands:         callbacks.NewCommandRegistry(),
+		Interactions: &callbacks.Interactions{
+			Global:   envVars.SlashCommands,
+			GuildIDs: envVars.SlashCommandGuilds,
 		},
+		GuildConfigs: guildConfigs,
+	}
+
+	callbackHandler.VoiceStateDispatcher = callbacks.NewVoiceStateDispatcher(callbackHandler)
+
+	setupCallbackHandler(session, callbackHandler)
+
+	garbageCollector := callbacks.NewGarbageCollector(
+		callbackHandler,
+		session,
+		envVars.GCInterval,
+		envVars.GCDryRun,
+		envVars.GCDryRunGuilds,
 	)
 
 	err = session.Open()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	callbackMetrics.Monitor(ctx)
 
-	return session, nil
+	go callbackHandler.Reconcile(ctx, session, envVars.ReconcileInterval)
+	go garbageCollector.Collect(ctx)
+
+	reports := []internalHTTP.OptionFunc{
+		internalHTTP.OptionalReport(internalHTTP.GarbageCollectorEndpoint, garbageCollector),
+		internalHTTP.OptionalReport(internalHTTP.CircuitBreakerEndpoint, circuitBreaker),
+	}
+
+	return session, reports, nil
+}
+
+// newGuildConfigStore returns a config.GuildConfigStore persisting to the
+// file at the provided path, or keeping guild configurations in memory if no
+// path is provided.
+func newGuildConfigStore(path string) (config.GuildConfigStore, error) {
+	if path == "" {
+		return config.NewMemoryStore(), nil
+	}
+
+	return config.NewFileStore(path)
+}
+
+// newRoleMap returns a *rolemap.Store persisting to the directory at the
+// provided path, or keeping the mappings in memory if no path is provided.
+func newRoleMap(log logging.Interface, path string) (*rolemap.Store, error) {
+	if path == "" {
+		return rolemap.New(), nil
+	}
+
+	return rolemap.Load(path, func(err error) {
+		log.WithError(err).Error("Unable to persist role map")
+	})
 }
 
 func setupCallbackHandler(session *discordgo.Session, callbackConfig *callbacks.Handler) {
 	session.AddHandler(callbackConfig.ChannelDelete)
+	session.AddHandler(callbackConfig.ChannelUpdate)
+	session.AddHandler(callbackConfig.GuildCreate)
+	session.AddHandler(callbackConfig.InteractionCreate)
 	session.AddHandler(callbackConfig.MessageCreate)
 	session.AddHandler(callbackConfig.Ready)
-	session.AddHandler(callbackConfig.VoiceStateUpdate)
+	session.AddHandler(callbackConfig.VoiceStateDispatcher.VoiceStateUpdate)
 }
 
-func startHTTPServer(log logging.Interface, session *discordgo.Session, port string) (httpServer *http.Server, stop chan os.Signal) {
-	httpServer = internalHTTP.NewServer(log, session, port)
+func startHTTPServer(
+	log logging.Interface,
+	session *discordgo.Session,
+	port string,
+	reports []internalHTTP.OptionFunc,
+) (httpServer *http.Server, stop chan os.Signal) {
+	httpServer = internalHTTP.NewServer(log, session, port, reports...)
 	stop = make(chan os.Signal, 1)
 
 	go func() {
@@ -201,14 +323,14 @@ func main() {
 	monitorCtx, cancelMonitorCtx := context.WithCancel(context.Background())
 	defer cancelMonitorCtx()
 
-	session, err := startSession(monitorCtx, log, envVars, client, jaegerTracer)
+	session, reports, err := startSession(monitorCtx, log, envVars, client, jaegerTracer)
 	if err != nil {
 		log.WithError(err).Fatal("Error starting Discord session")
 	}
 
 	defer closeComponent(log, "Discord session", session)
 
-	httpServer, stop := startHTTPServer(log, session, envVars.Port)
+	httpServer, stop := startHTTPServer(log, session, envVars.Port, reports)
 
 	<-stop // Block until the OS signal
 
@@ -0,0 +1,45 @@
+// Package atomicfile provides writing files on disk without ever leaving a
+// partially written file behind.
+package atomicfile
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// WriteFile writes the provided data to a temporary file in the same
+// directory as the file at the provided path, which then replaces the file,
+// so a crash mid-write never leaves a truncated file behind. The file is
+// given the provided permissions.
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	tempFile, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("unable to create temporary file: %w", err)
+	}
+
+	tempPath := tempFile.Name()
+
+	_, err = tempFile.Write(data)
+	if err == nil {
+		err = tempFile.Chmod(perm)
+	}
+
+	closeErr := tempFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(tempPath, path)
+	}
+
+	if err != nil {
+		_ = os.Remove(tempPath)
+
+		return fmt.Errorf("unable to replace file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package atomicfile_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/atomicfile"
+)
+
+const testPermissions = 0o600
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.json")
+
+	for _, data := range []string{"first", "second"} {
+		err := atomicfile.WriteFile(path, []byte(data), testPermissions)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		written, err := ioutil.ReadFile(filepath.Clean(path))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(written) != data {
+			t.Errorf("Unexpected file contents: %q", written)
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Mode().Perm() != testPermissions {
+		t.Errorf("Unexpected file permissions: %v", info.Mode().Perm())
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 1 {
+		t.Errorf("Unexpected temporary files left behind: %d", len(files))
+	}
+
+	err = atomicfile.WriteFile(filepath.Join(dir, "missing", "test.json"), []byte("test"), testPermissions)
+	if err == nil {
+		t.Error("Expected an error writing to a missing directory")
+	}
+}
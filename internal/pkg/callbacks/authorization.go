@@ -0,0 +1,120 @@
+package callbacks
+
+import (
+	"fmt"
+)
+
+const (
+	botOwnerRequired       = "command restricted to bot owners"
+	missingPermissions     = "missing permissions %d"
+	noGuildPermissions     = "command requires guild permissions"
+	permissionsLookupError = "unable to look up permissions"
+	commandDenied          = "Command denied"
+)
+
+// CommandRequirement is what a user must satisfy to run a command. The zero
+// value allows any user to run the command.
+type CommandRequirement struct {
+	// BotOwner restricts the command to the configured bot owners.
+	BotOwner bool
+
+	// Permissions are the permissions the user must have in the channel the
+	// command was sent in, such as discordgo.PermissionAdministrator.
+	Permissions int64
+}
+
+// CommandNotAuthorized is an error for when a user is not authorized to run a
+// command.
+type CommandNotAuthorized struct {
+	Command string
+	UserID  string
+	GuildID string
+	Reason  string
+	Err     error
+}
+
+// Error satisfies the error interface.
+func (notAuthorized *CommandNotAuthorized) Error() string {
+	if notAuthorized.Err != nil {
+		return fmt.Sprintf("%s not authorized: %s: %s", notAuthorized.Command, notAuthorized.Reason, notAuthorized.Err)
+	}
+
+	return fmt.Sprintf("%s not authorized: %s", notAuthorized.Command, notAuthorized.Reason)
+}
+
+// Unwrap satisfies the errors.Wrapper interface.
+func (notAuthorized *CommandNotAuthorized) Unwrap() error {
+	return notAuthorized.Err
+}
+
+// Authorizer decides whether a user may run a command. Bot owners may run
+// every command. A nil *Authorizer has no bot owners.
+type Authorizer struct {
+	Owners map[string]bool
+}
+
+// NewAuthorizer returns a new *Authorizer with the provided bot owner user
+// IDs.
+func NewAuthorizer(ownerIDs []string) *Authorizer {
+	owners := make(map[string]bool, len(ownerIDs))
+
+	for _, ownerID := range ownerIDs {
+		if ownerID != "" {
+			owners[ownerID] = true
+		}
+	}
+
+	return &Authorizer{Owners: owners}
+}
+
+// IsOwner returns whether the user associated with the provided userID is a
+// bot owner.
+func (authorizer *Authorizer) IsOwner(userID string) bool {
+	return authorizer != nil && authorizer.Owners[userID]
+}
+
+// Authorize returns a *CommandNotAuthorized error if the user running the
+// provided invocation does not satisfy its command's requirement, or nil
+// otherwise.
+func (authorizer *Authorizer) Authorize(invocation *CommandInvocation) error {
+	if authorizer.IsOwner(invocation.UserID) {
+		return nil
+	}
+
+	requirement := invocation.Command.Requirement
+
+	notAuthorized := &CommandNotAuthorized{
+		Command: invocation.Command.Name,
+		UserID:  invocation.UserID,
+		GuildID: invocation.GuildID,
+	}
+
+	if requirement.BotOwner {
+		notAuthorized.Reason = botOwnerRequired
+		return notAuthorized
+	}
+
+	if requirement.Permissions == 0 {
+		return nil
+	}
+
+	if invocation.GuildID == "" {
+		notAuthorized.Reason = noGuildPermissions
+		return notAuthorized
+	}
+
+	permissions, err := invocation.Session.State.UserChannelPermissions(invocation.UserID, invocation.ChannelID)
+	if err != nil {
+		notAuthorized.Reason = permissionsLookupError
+		notAuthorized.Err = err
+
+		return notAuthorized
+	}
+
+	if permissions&requirement.Permissions != requirement.Permissions {
+		notAuthorized.Reason = fmt.Sprintf(missingPermissions, requirement.Permissions&^permissions)
+		return notAuthorized
+	}
+
+	return nil
+}
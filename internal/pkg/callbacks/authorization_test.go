@@ -0,0 +1,166 @@
+package callbacks_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ewohltman/discordgo-mock/mockconstants"
+	"github.com/sirupsen/logrus"
+	logTest "github.com/sirupsen/logrus/hooks/test"
+
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/callbacks"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/mock"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/monitor"
+)
+
+const testOwner = "testOwner"
+
+func TestAuthorizer_Authorize(t *testing.T) {
+	authorizer := callbacks.NewAuthorizer([]string{testOwner})
+
+	registry := callbacks.NewCommandRegistry()
+
+	infoCommand, _ := registry.Lookup(callbacks.InfoCommand)
+	logLevelCommand, _ := registry.Lookup(callbacks.LogLevelCommand)
+	adminCommand := &callbacks.Command{
+		Name:        "admin",
+		Requirement: callbacks.CommandRequirement{Permissions: discordgo.PermissionAdministrator},
+	}
+
+	testCases := []struct {
+		name       string
+		authorizer *callbacks.Authorizer
+		command    *callbacks.Command
+		userID     string
+		guildID    string
+		admin      bool
+		authorized bool
+	}{
+		{
+			name:       "no requirements",
+			authorizer: authorizer,
+			command:    infoCommand,
+			userID:     mockconstants.TestUser,
+			guildID:    mockconstants.TestGuild,
+			authorized: true,
+		},
+		{
+			name:       "bot owner",
+			authorizer: authorizer,
+			command:    logLevelCommand,
+			userID:     testOwner,
+			authorized: true,
+		},
+		{
+			name:       "not bot owner",
+			authorizer: authorizer,
+			command:    logLevelCommand,
+			userID:     mockconstants.TestUser,
+			guildID:    mockconstants.TestGuild,
+			admin:      true,
+		},
+		{
+			name:    "nil authorizer",
+			command: logLevelCommand,
+			userID:  testOwner,
+			guildID: mockconstants.TestGuild,
+		},
+		{
+			name:       "missing permissions",
+			authorizer: authorizer,
+			command:    adminCommand,
+			userID:     mockconstants.TestUser,
+			guildID:    mockconstants.TestGuild,
+		},
+		{
+			name:       "administrator",
+			authorizer: authorizer,
+			command:    adminCommand,
+			userID:     mockconstants.TestUser,
+			guildID:    mockconstants.TestGuild,
+			admin:      true,
+			authorized: true,
+		},
+		{
+			name:       "direct message",
+			authorizer: authorizer,
+			command:    adminCommand,
+			userID:     mockconstants.TestUser,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			session, err := mock.NewSession()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if testCase.admin {
+				role, roleErr := session.State.Role(mockconstants.TestGuild, mockconstants.TestRole)
+				if roleErr != nil {
+					t.Fatal(roleErr)
+				}
+
+				role.Permissions |= discordgo.PermissionAdministrator
+			}
+
+			err = testCase.authorizer.Authorize(&callbacks.CommandInvocation{
+				Session:   session,
+				Command:   testCase.command,
+				UserID:    testCase.userID,
+				GuildID:   testCase.guildID,
+				ChannelID: mockconstants.TestChannel,
+			})
+
+			var notAuthorized *callbacks.CommandNotAuthorized
+
+			if testCase.authorized && err != nil {
+				t.Errorf("Unexpected error: %s", err)
+			}
+
+			if !testCase.authorized && !errors.As(err, &notAuthorized) {
+				t.Errorf("Expected *callbacks.CommandNotAuthorized error, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestHandler_MessageCreate_denied(t *testing.T) {
+	session, err := mock.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log := mock.NewLogger()
+	hook := logTest.NewLocal(log.Logger)
+
+	handler := &callbacks.Handler{
+		Log:                  log,
+		BotName:              "testBot",
+		BotKeyword:           "testKeyword",
+		MessageCreateCounter: monitor.MessageCreateCounter(&monitor.Config{Log: log}),
+		Authorizer:           callbacks.NewAuthorizer([]string{testOwner}),
+	}
+
+	sendMessage(session, handler, fmt.Sprintf(
+		"%s %s %s",
+		handler.BotKeyword, callbacks.LogLevelCommand, callbacks.LogLevelParamDebug,
+	))
+
+	entry := hook.LastEntry()
+	if entry == nil {
+		t.Fatal("Expected denied command to be logged")
+	}
+
+	if entry.Level != logrus.WarnLevel ||
+		entry.Data["user"] != mockconstants.TestUser ||
+		entry.Data["guild"] != mockconstants.TestGuild ||
+		entry.Data["command"] != callbacks.LogLevelCommand {
+		t.Errorf("Unexpected denied command log entry: %s %v", entry.Message, entry.Data)
+	}
+}
@@ -2,39 +2,229 @@
 package callbacks
 
 import (
-	"fmt"
+	"context"
 	"time"
 
+	"github.com/bwmarrin/discordgo"
 	"github.com/opentracing/opentracing-go"
 	"github.com/prometheus/client_golang/prometheus"
 
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/capacity"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/config"
 	"github.com/ewohltman/ephemeral-roles/internal/pkg/logging"
 	"github.com/ewohltman/ephemeral-roles/internal/pkg/operations"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/rolemap"
 )
 
 // OperationsGateway is an interface abstraction for processing operations
 // requests.
 type OperationsGateway interface {
-	Process(operations.ResultChannel, *operations.Request)
+	CreateRole(*operations.CreateRoleRequest) *operations.RoleFuture
+	EditRole(*operations.EditRoleRequest) *operations.RoleFuture
+	DeleteRole(*operations.DeleteRoleRequest) *operations.Future
+	AddRole(*operations.AddRoleRequest) *operations.Future
+	RemoveRole(*operations.RemoveRoleRequest) *operations.Future
+	SetRoles(*operations.SetRolesRequest) *operations.Future
 }
 
 // Handler contains fields for the callback methods attached to it.
 type Handler struct {
-	Log                     logging.Interface
-	BotName                 string
-	BotKeyword              string
-	RolePrefix              string
-	RoleColor               int
-	JaegerTracer            opentracing.Tracer
-	ContextTimeout          time.Duration
-	ReadyCounter            prometheus.Counter
-	MessageCreateCounter    prometheus.Counter
-	VoiceStateUpdateCounter prometheus.Counter
-	OperationsGateway       OperationsGateway
-}
-
-// RoleNameFromChannel returns the name of a role for a channel, with the bot
-// keyword prefixed.
-func (handler *Handler) RoleNameFromChannel(channelName string) string {
-	return fmt.Sprintf("%s %s", handler.RolePrefix, channelName)
+	Log                      logging.Interface
+	BotName                  string
+	BotKeyword               string
+	RolePrefix               string
+	RoleColor                int
+	JaegerTracer             opentracing.Tracer
+	ContextTimeout           time.Duration
+	ReadyCounter             prometheus.Counter
+	MessageCreateCounter     prometheus.Counter
+	InteractionCreateCounter prometheus.Counter
+	VoiceStateUpdateCounter  prometheus.Counter
+	ReconcileAddedCounter    *prometheus.CounterVec
+	ReconcileRemovedCounter  *prometheus.CounterVec
+	RoleEvictionCounter      prometheus.Counter
+	OperationsGateway        OperationsGateway
+	RoleMap                  *rolemap.Store
+	Capacity                 *capacity.Manager
+	EmptyRoleDeleter         *EmptyRoleDeleter
+	RoleRemovalGrace         *RoleRemovalGrace
+	VoiceStateDispatcher     *VoiceStateDispatcher
+	RecentErrors             *RecentErrors
+	Authorizer               *Authorizer
+	Commands                 *CommandRegistry
+	Interactions             *Interactions
+	GuildConfigs             config.GuildConfigStore
+}
+
+const (
+	guildConfigLookupError = "Unable to look up guild config, using defaults"
+
+	defaultRoleHoist       = true
+	defaultRoleMentionable = true
+)
+
+// RoleNameFromChannel returns the name of a role for a channel, with the role
+// prefix of the guild associated with the provided guildID prefixed.
+func (handler *Handler) RoleNameFromChannel(guildID, channelName string) string {
+	return handler.GuildSettings(guildID).RoleName(channelName)
+}
+
+// GuildSettings returns the settings of the guild associated with the
+// provided guildID. Settings the guild has not customized fall back to the
+// handler's defaults.
+func (handler *Handler) GuildSettings(guildID string) *config.Settings {
+	defaults := handler.defaultSettings()
+
+	if handler.GuildConfigs == nil || guildID == "" {
+		return defaults
+	}
+
+	guildConfig, err := handler.GuildConfigs.Get(guildID)
+	if err != nil {
+		handler.Log.WithField("guild", guildID).WithError(err).Warn(guildConfigLookupError)
+		return defaults
+	}
+
+	return guildConfig.Resolve(defaults)
+}
+
+// defaultSettings returns the settings of guilds which have not customized
+// them.
+func (handler *Handler) defaultSettings() *config.Settings {
+	return &config.Settings{
+		BotName:         handler.BotName,
+		BotKeyword:      handler.BotKeyword,
+		RolePrefix:      handler.RolePrefix,
+		RoleColor:       handler.RoleColor,
+		RoleHoist:       defaultRoleHoist,
+		RoleMentionable: defaultRoleMentionable,
+		RoleMode:        config.RoleModeChannel,
+	}
+}
+
+// contextWithTimeout returns a context derived from the provided parent which
+// expires once the handler's ContextTimeout has passed. The parent is only
+// made cancellable if no ContextTimeout is set.
+func (handler *Handler) contextWithTimeout(parent context.Context) (context.Context, context.CancelFunc) {
+	if handler.ContextTimeout <= 0 {
+		return context.WithCancel(parent)
+	}
+
+	return context.WithTimeout(parent, handler.ContextTimeout)
+}
+
+// lookupChannelRole returns the ephemeral role mapped to the provided channel.
+// If no role is mapped to the channel, lookupChannelRole falls back to
+// matching the role name to migrate guilds with pre-existing ephemeral roles.
+func (handler *Handler) lookupChannelRole(
+	session *discordgo.Session,
+	guild *discordgo.Guild,
+	channel *discordgo.Channel,
+) (*discordgo.Role, error) {
+	roleID, found := handler.RoleMap.RoleID(guild.ID, channel.ID)
+	if found {
+		role, err := session.State.Role(guild.ID, roleID)
+		if err == nil {
+			return role, nil
+		}
+
+		handler.RoleMap.DeleteChannel(guild.ID, channel.ID)
+	}
+
+	role := handler.migrateChannelRole(session, guild, channel)
+	if role == nil {
+		return nil, &RoleNotFound{}
+	}
+
+	return role, nil
+}
+
+// migrateChannelRole maps the provided channel to the first role with the
+// matching ephemeral role name which is not already mapped to another
+// channel.
+func (handler *Handler) migrateChannelRole(
+	session *discordgo.Session,
+	guild *discordgo.Guild,
+	channel *discordgo.Channel,
+) *discordgo.Role {
+	roleName := handler.RoleNameFromChannel(guild.ID, channel.Name)
+
+	session.State.RLock()
+	defer session.State.RUnlock()
+
+	for _, role := range guild.Roles {
+		if role.Name != roleName {
+			continue
+		}
+
+		if handler.RoleMap.Claim(guild.ID, channel.ID, role.ID) {
+			return role
+		}
+	}
+
+	return nil
+}
+
+// rebuildRoleMap rebuilds the channel to ephemeral role mappings for the
+// provided guild. Existing mappings, including those loaded from a persisted
+// RoleMap, are kept while both their channel and role still exist. Role names
+// are only used to fill the gaps: unmapped voice channels, and categories if
+// the guild has category roles, are migrated to unclaimed roles by name.
+func (handler *Handler) rebuildRoleMap(session *discordgo.Session, guild *discordgo.Guild) {
+	settings := handler.GuildSettings(guild.ID)
+
+	session.State.RLock()
+	defer session.State.RUnlock()
+
+	guildRoles := make(map[string]*discordgo.Role, len(guild.Roles))
+
+	for _, role := range guild.Roles {
+		guildRoles[role.ID] = role
+	}
+
+	channelRoles := make(map[string]string)
+	claimedRoles := make(map[string]bool)
+	voiceChannels := make([]*discordgo.Channel, 0, len(guild.Channels))
+	categories := make([]*discordgo.Channel, 0)
+
+	for _, channel := range guild.Channels {
+		if !isRoleChannel(channel, settings) {
+			continue
+		}
+
+		if channel.Type == discordgo.ChannelTypeGuildCategory {
+			categories = append(categories, channel)
+		} else {
+			voiceChannels = append(voiceChannels, channel)
+		}
+
+		roleID, found := handler.RoleMap.RoleID(guild.ID, channel.ID)
+		if !found || guildRoles[roleID] == nil || claimedRoles[roleID] {
+			continue
+		}
+
+		channelRoles[channel.ID] = roleID
+		claimedRoles[roleID] = true
+	}
+
+	// Voice channels are migrated first so they keep their roles when a
+	// category has the same name
+	for _, channel := range append(voiceChannels, categories...) {
+		if _, found := channelRoles[channel.ID]; found {
+			continue
+		}
+
+		roleName := settings.RoleName(channel.Name)
+
+		for _, role := range guild.Roles {
+			if role.Name == roleName && !claimedRoles[role.ID] {
+				channelRoles[channel.ID] = role.ID
+				claimedRoles[role.ID] = true
+
+				break
+			}
+		}
+	}
+
+	handler.RoleMap.Replace(guild.ID, channelRoles)
 }
@@ -1,22 +1,159 @@
 package callbacks_test
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/ewohltman/discordgo-mock/mockconstants"
 
 	"github.com/ewohltman/ephemeral-roles/internal/pkg/callbacks"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/capacity"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/config"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/mock"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/monitor"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/operations"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/rolemap"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/tracer"
 )
 
 const rolePrefix = "{eph}"
 
+// failingStore is a config.GuildConfigStore failing every request.
+type failingStore struct{}
+
+func (failingStore) Get(string) (*config.GuildConfig, error) { return nil, errors.New("get failed") }
+func (failingStore) Put(*config.GuildConfig) error           { return errors.New("put failed") }
+func (failingStore) Delete(string) error                     { return errors.New("delete failed") }
+
 func TestHandler_RoleNameFromChannel(t *testing.T) {
 	handler := &callbacks.Handler{RolePrefix: rolePrefix}
 	expected := fmt.Sprintf("%s %s", rolePrefix, mockconstants.TestChannel)
-	actual := handler.RoleNameFromChannel(mockconstants.TestChannel)
+	actual := handler.RoleNameFromChannel(mockconstants.TestGuild, mockconstants.TestChannel)
 
 	if actual != expected {
 		t.Errorf("unexpected role name: %s", actual)
 	}
 }
+
+func TestHandler_GuildSettings(t *testing.T) {
+	store := config.NewMemoryStore()
+
+	err := store.Put(&config.GuildConfig{
+		GuildID:    mockconstants.TestGuild,
+		BotKeyword: config.String("!voice"),
+		RolePrefix: config.String("[voice]"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := &callbacks.Handler{
+		Log:          mock.NewLogger(),
+		BotName:      "testBot",
+		BotKeyword:   "testKeyword",
+		RolePrefix:   rolePrefix,
+		RoleColor:    0xffa500,
+		GuildConfigs: store,
+	}
+
+	settings := handler.GuildSettings(mockconstants.TestGuild)
+	if settings.BotKeyword != "!voice" || settings.RolePrefix != "[voice]" ||
+		settings.BotName != handler.BotName || settings.RoleColor != handler.RoleColor {
+		t.Errorf("Unexpected guild settings: %+v", settings)
+	}
+
+	settings = handler.GuildSettings(mockconstants.TestGuildLarge)
+	if settings.BotKeyword != handler.BotKeyword || settings.RolePrefix != handler.RolePrefix {
+		t.Errorf("Expected default settings for guild without config: %+v", settings)
+	}
+
+	handler.GuildConfigs = failingStore{}
+
+	settings = handler.GuildSettings(mockconstants.TestGuild)
+	if settings.RolePrefix != handler.RolePrefix {
+		t.Errorf("Expected default settings when the store fails: %+v", settings)
+	}
+}
+
+func TestHandler_guildConfig(t *testing.T) {
+	jaegerTracer, jaegerCloser, err := tracer.New("test")
+	if err != nil {
+		t.Fatalf("Error creating Jaeger tracer: %s", err)
+	}
+
+	defer func() {
+		closeErr := jaegerCloser.Close()
+		if closeErr != nil {
+			t.Errorf("Error closing Jaeger tracer: %s", err)
+		}
+	}()
+
+	session, err := mock.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := &messageRecorder{next: session.Client.Transport}
+	session.Client.Transport = recorder
+
+	store := config.NewMemoryStore()
+
+	err = store.Put(&config.GuildConfig{
+		GuildID:    mockconstants.TestGuild,
+		BotKeyword: config.String("!voice"),
+		RolePrefix: config.String("[voice]"),
+		RoleColor:  config.Int(0x123456),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log := mock.NewLogger()
+
+	handler := &callbacks.Handler{
+		Log:                     log,
+		BotName:                 "testBot",
+		BotKeyword:              "testKeyword",
+		RolePrefix:              rolePrefix,
+		JaegerTracer:            jaegerTracer,
+		ContextTimeout:          time.Second,
+		MessageCreateCounter:    monitor.MessageCreateCounter(&monitor.Config{Log: log}),
+		VoiceStateUpdateCounter: monitor.VoiceStateUpdateCounter(&monitor.Config{Log: log}),
+		OperationsGateway:       operations.NewGateway(session),
+		RoleMap:                 rolemap.New(),
+		Capacity:                capacity.NewManager(),
+		GuildConfigs:            store,
+	}
+
+	// Commands use the guild's keyword instead of the default
+	sendMessage(session, handler, fmt.Sprintf("%s %s", handler.BotKeyword, callbacks.HelpCommand))
+	sendMessage(session, handler, "!voice "+callbacks.HelpCommand)
+
+	recorder.mutex.Lock()
+	sent := len(recorder.messages)
+	recorder.mutex.Unlock()
+
+	if sent != 1 || !strings.Contains(recorder.lastEmbed(t).Description, "!voice") {
+		t.Errorf("Expected a single help message using the guild's keyword, got %d messages", sent)
+	}
+
+	// Ephemeral roles use the guild's prefix and color
+	sendUpdate(session, handler, mockconstants.TestGuild, mockconstants.TestUser, mockconstants.TestChannel2)
+
+	roleID, found := handler.RoleMap.RoleID(mockconstants.TestGuild, mockconstants.TestChannel2)
+	if !found {
+		t.Fatal("Expected an ephemeral role to be created")
+	}
+
+	role, err := session.State.Role(mockconstants.TestGuild, roleID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if role.Name != "[voice] "+mockconstants.TestChannel2 || role.Color != 0x123456 {
+		t.Errorf("Unexpected ephemeral role: %s %x", role.Name, role.Color)
+	}
+}
@@ -0,0 +1,90 @@
+package callbacks
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
+
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/operations"
+)
+
+const evictedRole = "Evicted least recently used ephemeral role"
+
+// createRoleWithEviction creates the ephemeral role for the provided channel
+// at the provided priority. If the guild already has the max number of roles,
+// the least recently used empty ephemeral role is evicted and the create is
+// retried once. Background requests never evict, so periodic passes such as
+// reconciliation never delete roles to make room.
+func (handler *Handler) createRoleWithEviction(
+	ctx context.Context,
+	priority operations.Priority,
+	session *discordgo.Session,
+	guild *discordgo.Guild,
+	channel *discordgo.Channel,
+) (*discordgo.Role, error) {
+	role, err := handler.createRole(ctx, priority, guild, channel)
+	if !operations.IsMaxGuildsResponse(err) || priority == operations.PriorityBackground {
+		return role, err
+	}
+
+	evictErr := handler.evictRole(ctx, priority, session, guild)
+	if evictErr != nil {
+		return nil, fmt.Errorf("%w: %s", err, evictErr)
+	}
+
+	return handler.createRole(ctx, priority, guild, channel)
+}
+
+// evictRole deletes the least recently used empty ephemeral role in the
+// provided guild at the provided priority.
+func (handler *Handler) evictRole(
+	ctx context.Context,
+	priority operations.Priority,
+	session *discordgo.Session,
+	guild *discordgo.Guild,
+) error {
+	roleID, found := handler.Capacity.LeastRecentlyUsed(guild.ID, handler.emptyEphemeralRoles(session, guild))
+	if !found {
+		return fmt.Errorf("unable to evict ephemeral role: no empty ephemeral roles")
+	}
+
+	err := handler.deleteRole(ctx, priority, guild, roleID)
+	if err != nil {
+		return fmt.Errorf("unable to evict ephemeral role: %w", err)
+	}
+
+	handler.RoleEvictionCounter.Inc()
+
+	handler.Log.WithFields(logrus.Fields{
+		"guild": guild.Name,
+		"role":  roleID,
+	}).Info(evictedRole)
+
+	return nil
+}
+
+// emptyEphemeralRoles returns the IDs of the ephemeral roles in the provided
+// guild whose channel or category has no members connected. Voice states are
+// used rather than member roles, as the guild's member list may be incomplete.
+func (handler *Handler) emptyEphemeralRoles(session *discordgo.Session, guild *discordgo.Guild) []string {
+	channelRoles := handler.RoleMap.Channels(guild.ID)
+	rolePrefix := handler.GuildSettings(guild.ID).RolePrefix
+
+	session.State.RLock()
+	defer session.State.RUnlock()
+
+	usedRoles := connectedRoles(guild, channelRoles)
+
+	emptyRoles := make([]string, 0)
+
+	for _, role := range guild.Roles {
+		if strings.HasPrefix(role.Name, rolePrefix) && !usedRoles[role.ID] {
+			emptyRoles = append(emptyRoles, role.ID)
+		}
+	}
+
+	return emptyRoles
+}
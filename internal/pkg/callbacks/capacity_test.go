@@ -0,0 +1,163 @@
+package callbacks_test
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ewohltman/discordgo-mock/mockconstants"
+
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/callbacks"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/capacity"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/mock"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/monitor"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/operations"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/rolemap"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/tracer"
+)
+
+const staleRoleID = "staleRole"
+
+func TestHandler_VoiceStateUpdate_eviction(t *testing.T) {
+	jaegerTracer, jaegerCloser, err := tracer.New("test")
+	if err != nil {
+		t.Fatalf("Error creating Jaeger tracer: %s", err)
+	}
+
+	defer func() {
+		closeErr := jaegerCloser.Close()
+		if closeErr != nil {
+			t.Errorf("Error closing Jaeger tracer: %s", err)
+		}
+	}()
+
+	session, err := mock.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session.Client.Transport = mock.NewFailureRoundTripper(session.Client.Transport, &mock.Failure{
+		Method:     http.MethodPost,
+		Path:       regexp.MustCompile(`/guilds/` + mockconstants.TestGuild + `/roles$`),
+		StatusCode: http.StatusBadRequest,
+		Code:       operations.APIErrorCodeMaxRoles,
+		Times:      1,
+	})
+
+	log := mock.NewLogger()
+
+	handler := &callbacks.Handler{
+		Log:                     log,
+		BotName:                 "testBot",
+		BotKeyword:              "testKeyword",
+		RolePrefix:              "{eph}",
+		JaegerTracer:            jaegerTracer,
+		VoiceStateUpdateCounter: monitor.VoiceStateUpdateCounter(&monitor.Config{Log: log}),
+		RoleEvictionCounter:     monitor.RoleEvictionCounter(&monitor.Config{Log: log}),
+		OperationsGateway:       operations.NewGateway(session),
+		RoleMap:                 rolemap.New(),
+		Capacity:                capacity.NewManager(),
+	}
+
+	err = session.State.RoleAdd(mockconstants.TestGuild, &discordgo.Role{
+		ID:   staleRoleID,
+		Name: handler.RoleNameFromChannel(mockconstants.TestGuild, "staleChannel"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Roles still held by members are evicted once their channel is empty,
+	// as the guild's member list may be incomplete
+	botMember, err := session.State.Member(mockconstants.TestGuild, testBotUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	botMember.Roles = append(botMember.Roles, staleRoleID)
+
+	sendUpdate(session, handler, mockconstants.TestGuild, mockconstants.TestUser, mockconstants.TestChannel2)
+
+	_, err = session.State.Role(mockconstants.TestGuild, staleRoleID)
+	if err == nil {
+		t.Error("Least recently used ephemeral role was not evicted")
+	}
+
+	roleID, found := handler.RoleMap.RoleID(mockconstants.TestGuild, mockconstants.TestChannel2)
+	if !found {
+		t.Fatalf("Ephemeral role not created for channel %s after eviction", mockconstants.TestChannel2)
+	}
+
+	member, err := session.State.Member(mockconstants.TestGuild, mockconstants.TestUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !hasRole(member, roleID) {
+		t.Errorf("Ephemeral role for channel %s not added to member", mockconstants.TestChannel2)
+	}
+}
+
+func TestHandler_Reconcile_noEviction(t *testing.T) {
+	session, err := mock.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session.Client.Transport = mock.NewFailureRoundTripper(session.Client.Transport, &mock.Failure{
+		Method:     http.MethodPost,
+		Path:       regexp.MustCompile(`/guilds/` + mockconstants.TestGuild + `/roles$`),
+		StatusCode: http.StatusBadRequest,
+		Code:       operations.APIErrorCodeMaxRoles,
+		Times:      1,
+	})
+
+	log := mock.NewLogger()
+
+	handler := &callbacks.Handler{
+		Log:                     log,
+		BotName:                 "testBot",
+		BotKeyword:              "testKeyword",
+		RolePrefix:              "{eph}",
+		ReconcileAddedCounter:   monitor.ReconcileAddedCounter(&monitor.Config{Log: log}),
+		ReconcileRemovedCounter: monitor.ReconcileRemovedCounter(&monitor.Config{Log: log}),
+		RoleEvictionCounter:     monitor.RoleEvictionCounter(&monitor.Config{Log: log}),
+		OperationsGateway:       operations.NewGateway(session),
+		RoleMap:                 rolemap.New(),
+		Capacity:                capacity.NewManager(),
+	}
+
+	err = session.State.RoleAdd(mockconstants.TestGuild, &discordgo.Role{
+		ID:   staleRoleID,
+		Name: handler.RoleNameFromChannel(mockconstants.TestGuild, "staleChannel"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	guild, err := session.State.Guild(mockconstants.TestGuild)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	guild.VoiceStates = []*discordgo.VoiceState{
+		{
+			GuildID:   mockconstants.TestGuild,
+			UserID:    mockconstants.TestUser,
+			ChannelID: mockconstants.TestChannel2,
+		},
+	}
+
+	// Reconciliation leaves room to be made by VoiceStateUpdate
+	handler.GuildCreate(session, &discordgo.GuildCreate{Guild: guild})
+
+	_, err = session.State.Role(mockconstants.TestGuild, staleRoleID)
+	if err != nil {
+		t.Error("Ephemeral role evicted by reconciliation")
+	}
+
+	if _, found := handler.RoleMap.RoleID(mockconstants.TestGuild, mockconstants.TestChannel2); found {
+		t.Errorf("Unexpected ephemeral role created for channel %s at the max number of roles", mockconstants.TestChannel2)
+	}
+}
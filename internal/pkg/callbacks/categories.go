@@ -0,0 +1,82 @@
+package callbacks
+
+import (
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/config"
+)
+
+// isRoleChannel returns whether ephemeral roles are mapped to the provided
+// channel under the provided settings. Voice channels have ephemeral roles if
+// the guild's role mode has channel roles or if they have no category, and
+// categories only if the guild's role mode has category roles.
+func isRoleChannel(channel *discordgo.Channel, settings *config.Settings) bool {
+	switch channel.Type {
+	case discordgo.ChannelTypeGuildVoice:
+		return settings.ChannelRoles() || channel.ParentID == ""
+	case discordgo.ChannelTypeGuildCategory:
+		return settings.CategoryRoles()
+	default:
+		return false
+	}
+}
+
+// roleChannels returns the channels whose ephemeral roles members connected
+// to the provided voice channel get under the provided settings. Voice
+// channels without a category always get their own ephemeral role.
+func roleChannels(session *discordgo.Session, settings *config.Settings, channel *discordgo.Channel) []*discordgo.Channel {
+	category := channelCategory(session, channel)
+	channels := make([]*discordgo.Channel, 0, 2)
+
+	if settings.ChannelRoles() || category == nil {
+		channels = append(channels, channel)
+	}
+
+	if settings.CategoryRoles() && category != nil {
+		channels = append(channels, category)
+	}
+
+	return channels
+}
+
+// channelCategory returns the category of the provided channel, or nil if it
+// has none.
+func channelCategory(session *discordgo.Session, channel *discordgo.Channel) *discordgo.Channel {
+	if channel.ParentID == "" {
+		return nil
+	}
+
+	category, err := session.State.Channel(channel.ParentID)
+	if err != nil || category.Type != discordgo.ChannelTypeGuildCategory {
+		return nil
+	}
+
+	return category
+}
+
+// connectedRoles returns the IDs of the ephemeral roles mapped to the voice
+// channels members of the provided guild are connected to, and to the
+// categories of those voice channels. The caller must hold the state lock.
+func connectedRoles(guild *discordgo.Guild, channelRoles map[string]string) map[string]bool {
+	channelCategories := make(map[string]string, len(guild.Channels))
+
+	for _, channel := range guild.Channels {
+		channelCategories[channel.ID] = channel.ParentID
+	}
+
+	roleIDs := make(map[string]bool)
+
+	for _, voiceState := range guild.VoiceStates {
+		roleID, found := channelRoles[voiceState.ChannelID]
+		if found {
+			roleIDs[roleID] = true
+		}
+
+		roleID, found = channelRoles[channelCategories[voiceState.ChannelID]]
+		if found {
+			roleIDs[roleID] = true
+		}
+	}
+
+	return roleIDs
+}
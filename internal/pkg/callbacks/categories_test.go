@@ -0,0 +1,368 @@
+package callbacks_test
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ewohltman/discordgo-mock/mockconstants"
+
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/callbacks"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/capacity"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/config"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/mock"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/monitor"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/operations"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/rolemap"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/tracer"
+)
+
+const testCategory = "testCategory"
+
+func TestHandler_VoiceStateUpdate_categoryRoles(t *testing.T) {
+	jaegerTracer, jaegerCloser, err := tracer.New("test")
+	if err != nil {
+		t.Fatalf("Error creating Jaeger tracer: %s", err)
+	}
+
+	defer func() {
+		closeErr := jaegerCloser.Close()
+		if closeErr != nil {
+			t.Errorf("Error closing Jaeger tracer: %s", err)
+		}
+	}()
+
+	testCases := []struct {
+		roleMode     string
+		channelRoles bool
+	}{
+		{roleMode: config.RoleModeCategory, channelRoles: false},
+		{roleMode: config.RoleModeBoth, channelRoles: true},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.roleMode, func(t *testing.T) {
+			session, err := mock.NewSession()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			addTestCategory(t, session)
+
+			store := config.NewMemoryStore()
+
+			err = store.Put(&config.GuildConfig{GuildID: mockconstants.TestGuild, RoleMode: config.String(testCase.roleMode)})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			counter := &methodCounter{next: session.Client.Transport, methods: make(map[string]int)}
+			session.Client.Transport = counter
+
+			log := mock.NewLogger()
+
+			handler := &callbacks.Handler{
+				Log:                     log,
+				BotName:                 "testBot",
+				BotKeyword:              "testKeyword",
+				RolePrefix:              "{eph}",
+				JaegerTracer:            jaegerTracer,
+				VoiceStateUpdateCounter: monitor.VoiceStateUpdateCounter(&monitor.Config{Log: log}),
+				OperationsGateway:       operations.NewGateway(session),
+				RoleMap:                 rolemap.New(),
+				Capacity:                capacity.NewManager(),
+				GuildConfigs:            store,
+			}
+
+			// Joining a channel in the category swaps the ephemeral role of
+			// the previous channel for the category role in a single request
+			sendUpdate(session, handler, mockconstants.TestGuild, mockconstants.TestUser, mockconstants.TestChannel2)
+
+			methods := counter.reset()
+			if len(methods) != 2 || methods[http.MethodGet] != 1 || methods[http.MethodPatch] != 1 {
+				t.Errorf("Unexpected member requests joining the category: %v", methods)
+			}
+
+			categoryRoleID, found := handler.RoleMap.RoleID(mockconstants.TestGuild, testCategory)
+			if !found {
+				t.Fatal("Ephemeral role not created for category")
+			}
+
+			if role := stateRole(t, session, categoryRoleID); role.Name != "{eph} "+testCategory {
+				t.Errorf("Unexpected category role name: %s", role.Name)
+			}
+
+			expectedRoles := []string{mockconstants.TestRole, categoryRoleID}
+
+			channelRoleID, found := handler.RoleMap.RoleID(mockconstants.TestGuild, mockconstants.TestChannel2)
+			if found != testCase.channelRoles {
+				t.Errorf("Unexpected ephemeral role for channel %s: %t", mockconstants.TestChannel2, found)
+			}
+
+			if found {
+				expectedRoles = append(expectedRoles, channelRoleID)
+			}
+
+			assertMemberRoles(t, session, expectedRoles)
+
+			// Moving within the category keeps the category role
+			sendUpdate(session, handler, mockconstants.TestGuild, mockconstants.TestUser, mockconstants.TestChannel)
+
+			methods = counter.reset()
+			if testCase.channelRoles {
+				if len(methods) != 2 || methods[http.MethodGet] != 1 || methods[http.MethodPatch] != 1 {
+					t.Errorf("Unexpected member requests moving within the category: %v", methods)
+				}
+
+				assertMemberRoles(t, session, []string{mockconstants.TestRole, categoryRoleID, testEphemeralRole})
+			} else if len(methods) != 0 {
+				t.Errorf("Unexpected member requests moving within the category: %v", methods)
+			}
+		})
+	}
+}
+
+func TestHandler_ChannelUpdate_category(t *testing.T) {
+	session, err := mock.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	category := addTestCategory(t, session)
+	store := config.NewMemoryStore()
+	log := mock.NewLogger()
+
+	handler := &callbacks.Handler{
+		Log:                     log,
+		BotName:                 "testBot",
+		BotKeyword:              "testKeyword",
+		RolePrefix:              "{eph}",
+		RoleColor:               0xffa500,
+		ContextTimeout:          time.Second,
+		ReconcileAddedCounter:   monitor.ReconcileAddedCounter(&monitor.Config{Log: log}),
+		ReconcileRemovedCounter: monitor.ReconcileRemovedCounter(&monitor.Config{Log: log}),
+		OperationsGateway:       operations.NewGateway(session),
+		RoleMap:                 rolemap.New(),
+		Capacity:                capacity.NewManager(),
+		GuildConfigs:            store,
+	}
+
+	guild, err := session.State.Guild(mockconstants.TestGuild)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = session.State.RoleAdd(mockconstants.TestGuild, &discordgo.Role{ID: "categoryRole", Name: "{eph} " + testCategory})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler.GuildCreate(session, &discordgo.GuildCreate{Guild: guild})
+
+	if _, found := handler.RoleMap.RoleID(mockconstants.TestGuild, testCategory); found {
+		t.Error("Unexpected category role mapping without category roles")
+	}
+
+	renamedCategory := *category
+	renamedCategory.Name = "renamed"
+
+	// Categories are ignored unless the guild has category roles
+	handler.ChannelUpdate(session, &discordgo.ChannelUpdate{Channel: &renamedCategory})
+
+	if role := stateRole(t, session, "categoryRole"); role.Name != "{eph} "+testCategory {
+		t.Errorf("Unexpected category role renamed without category roles: %s", role.Name)
+	}
+
+	err = store.Put(&config.GuildConfig{GuildID: mockconstants.TestGuild, RoleMode: config.String(config.RoleModeCategory)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler.GuildCreate(session, &discordgo.GuildCreate{Guild: guild})
+
+	if roleID, _ := handler.RoleMap.RoleID(mockconstants.TestGuild, testCategory); roleID != "categoryRole" {
+		t.Fatalf("Unexpected category role mapping: %q", roleID)
+	}
+
+	handler.ChannelUpdate(session, &discordgo.ChannelUpdate{Channel: &renamedCategory})
+
+	if role := stateRole(t, session, "categoryRole"); role.Name != "{eph} renamed" || role.Color != handler.RoleColor {
+		t.Errorf("Unexpected category role after rename: %+v", role)
+	}
+
+	handler.ChannelDelete(session, &discordgo.ChannelDelete{Channel: &renamedCategory})
+
+	if _, err = session.State.Role(mockconstants.TestGuild, "categoryRole"); err == nil {
+		t.Error("Category role remains after deleting the category")
+	}
+
+	if _, found := handler.RoleMap.RoleID(mockconstants.TestGuild, testCategory); found {
+		t.Error("Category role mapping remains after deleting the category")
+	}
+}
+
+// addTestCategory adds a category to the test guild and moves the test voice
+// channels into it.
+func addTestCategory(t *testing.T, session *discordgo.Session) *discordgo.Channel {
+	t.Helper()
+
+	category := &discordgo.Channel{
+		ID:      testCategory,
+		GuildID: mockconstants.TestGuild,
+		Name:    testCategory,
+		Type:    discordgo.ChannelTypeGuildCategory,
+	}
+
+	err := session.State.ChannelAdd(category)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, channelID := range []string{mockconstants.TestChannel, mockconstants.TestChannel2} {
+		channel, err := session.State.Channel(channelID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		channel.ParentID = testCategory
+	}
+
+	guild, err := session.State.Guild(mockconstants.TestGuild)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The mock state does not share channels between the guild and the
+	// channel lookup
+	for _, channel := range guild.Channels {
+		if channel.ID == mockconstants.TestChannel || channel.ID == mockconstants.TestChannel2 {
+			channel.ParentID = testCategory
+		}
+	}
+
+	return category
+}
+
+func assertMemberRoles(t *testing.T, session *discordgo.Session, expectedRoles []string) {
+	t.Helper()
+
+	member, err := session.State.Member(mockconstants.TestGuild, mockconstants.TestUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	memberRoles := make([]string, len(member.Roles))
+	copy(memberRoles, member.Roles)
+
+	sort.Strings(memberRoles)
+	sort.Strings(expectedRoles)
+
+	if !reflect.DeepEqual(memberRoles, expectedRoles) {
+		t.Errorf("Unexpected member roles. Got: %v, Expected: %v", memberRoles, expectedRoles)
+	}
+}
+
+func TestHandler_ChannelUpdate_categoryMove(t *testing.T) {
+	jaegerTracer, jaegerCloser, err := tracer.New("test")
+	if err != nil {
+		t.Fatalf("Error creating Jaeger tracer: %s", err)
+	}
+
+	defer func() {
+		closeErr := jaegerCloser.Close()
+		if closeErr != nil {
+			t.Errorf("Error closing Jaeger tracer: %s", err)
+		}
+	}()
+
+	session, err := mock.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addTestCategory(t, session)
+
+	store := config.NewMemoryStore()
+
+	err = store.Put(&config.GuildConfig{GuildID: mockconstants.TestGuild, RoleMode: config.String(config.RoleModeCategory)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log := mock.NewLogger()
+
+	handler := &callbacks.Handler{
+		Log:                     log,
+		BotName:                 "testBot",
+		BotKeyword:              "testKeyword",
+		RolePrefix:              "{eph}",
+		JaegerTracer:            jaegerTracer,
+		ContextTimeout:          time.Second,
+		VoiceStateUpdateCounter: monitor.VoiceStateUpdateCounter(&monitor.Config{Log: log}),
+		OperationsGateway:       operations.NewGateway(session),
+		RoleMap:                 rolemap.New(),
+		Capacity:                capacity.NewManager(),
+		GuildConfigs:            store,
+	}
+
+	guild, err := session.State.Guild(mockconstants.TestGuild)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	guild.VoiceStates = []*discordgo.VoiceState{
+		{GuildID: mockconstants.TestGuild, UserID: mockconstants.TestUser, ChannelID: mockconstants.TestChannel2},
+	}
+
+	sendUpdate(session, handler, mockconstants.TestGuild, mockconstants.TestUser, mockconstants.TestChannel2)
+
+	oldCategoryRoleID, found := handler.RoleMap.RoleID(mockconstants.TestGuild, testCategory)
+	if !found {
+		t.Fatal("Ephemeral role not created for category")
+	}
+
+	assertMemberRoles(t, session, []string{mockconstants.TestRole, oldCategoryRoleID})
+
+	counter := &methodCounter{next: session.Client.Transport, methods: make(map[string]int)}
+	session.Client.Transport = counter
+
+	channel, err := session.State.Channel(mockconstants.TestChannel2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Updates which leave the channel in its category make no requests
+	handler.ChannelUpdate(session, &discordgo.ChannelUpdate{Channel: channel})
+
+	if methods := counter.reset(); len(methods) != 0 {
+		t.Errorf("Unexpected member requests without a category change: %v", methods)
+	}
+
+	err = session.State.ChannelAdd(&discordgo.Channel{
+		ID:      "otherCategory",
+		GuildID: mockconstants.TestGuild,
+		Name:    "otherCategory",
+		Type:    discordgo.ChannelTypeGuildCategory,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The state cache already holds the moved channel when ChannelUpdate is
+	// called
+	channel.ParentID = "otherCategory"
+
+	handler.ChannelUpdate(session, &discordgo.ChannelUpdate{Channel: channel})
+
+	newCategoryRoleID, found := handler.RoleMap.RoleID(mockconstants.TestGuild, "otherCategory")
+	if !found {
+		t.Fatal("Ephemeral role not created for the new category")
+	}
+
+	assertMemberRoles(t, session, []string{mockconstants.TestRole, newCategoryRoleID})
+}
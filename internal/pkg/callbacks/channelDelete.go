@@ -1,7 +1,11 @@
 package callbacks
 
 import (
+	"context"
+
 	"github.com/bwmarrin/discordgo"
+
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/operations"
 )
 
 const (
@@ -11,7 +15,13 @@ const (
 
 // ChannelDelete is the callback function for the ChannelDelete event from Discord.
 func (handler *Handler) ChannelDelete(session *discordgo.Session, channel *discordgo.ChannelDelete) {
-	if channel.Type != discordgo.ChannelTypeGuildVoice {
+	if channel.Type != discordgo.ChannelTypeGuildVoice && channel.Type != discordgo.ChannelTypeGuildCategory {
+		return
+	}
+
+	settings := handler.GuildSettings(channel.GuildID)
+
+	if !isRoleChannel(channel.Channel, settings) {
 		return
 	}
 
@@ -21,23 +31,38 @@ func (handler *Handler) ChannelDelete(session *discordgo.Session, channel *disco
 		return
 	}
 
-	for _, role := range guild.Roles {
-		if role.Name != handler.RoleNameFromChannel(channel.Name) {
-			continue
-		}
-
-		err = session.GuildRoleDelete(channel.GuildID, role.ID)
-		if err != nil {
-			handler.Log.WithError(err).Error(channelDeleteEventError)
-			return
-		}
+	role, err := handler.lookupChannelRole(session, guild, channel.Channel)
+	if err != nil {
+		return
+	}
 
-		err = session.State.RoleRemove(channel.GuildID, role.ID)
-		if err != nil && err != discordgo.ErrStateNotFound {
-			handler.Log.WithError(err).Error(channelDeleteEventError)
-			return
-		}
+	ctx, cancel := handler.contextWithTimeout(context.Background())
+	defer cancel()
 
+	err = handler.deleteRole(ctx, operations.PriorityInteractive, guild, role.ID)
+	if err != nil {
+		handler.Log.WithError(err).Error(channelDeleteEventError)
 		return
 	}
 }
+
+func (handler *Handler) deleteRole(
+	ctx context.Context,
+	priority operations.Priority,
+	guild *discordgo.Guild,
+	roleID string,
+) error {
+	err := handler.OperationsGateway.DeleteRole(&operations.DeleteRoleRequest{
+		Guild:    guild,
+		RoleID:   roleID,
+		Priority: priority,
+	}).Wait(ctx)
+	if err != nil {
+		return err
+	}
+
+	handler.RoleMap.DeleteRole(guild.ID, roleID)
+	handler.Capacity.Forget(guild.ID, roleID)
+
+	return nil
+}
@@ -8,7 +8,10 @@ import (
 	"github.com/ewohltman/discordgo-mock/mockconstants"
 
 	"github.com/ewohltman/ephemeral-roles/internal/pkg/callbacks"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/capacity"
 	"github.com/ewohltman/ephemeral-roles/internal/pkg/mock"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/operations"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/rolemap"
 )
 
 func TestHandler_ChannelDelete(t *testing.T) {
@@ -20,11 +23,14 @@ func TestHandler_ChannelDelete(t *testing.T) {
 	log := mock.NewLogger()
 
 	handler := &callbacks.Handler{
-		Log:            log,
-		BotName:        "testBot",
-		BotKeyword:     "testKeyword",
-		RolePrefix:     "{eph}",
-		ContextTimeout: time.Second,
+		Log:               log,
+		BotName:           "testBot",
+		BotKeyword:        "testKeyword",
+		RolePrefix:        "{eph}",
+		ContextTimeout:    time.Second,
+		OperationsGateway: operations.NewGateway(session),
+		RoleMap:           rolemap.New(),
+		Capacity:          capacity.NewManager(),
 	}
 
 	guild, err := session.State.Guild(mockconstants.TestGuild)
@@ -49,7 +55,7 @@ func TestHandler_ChannelDelete(t *testing.T) {
 }
 
 func foundRole(handler *callbacks.Handler, guild *discordgo.Guild, channel *discordgo.Channel) bool {
-	ephRoleName := handler.RoleNameFromChannel(channel.Name)
+	ephRoleName := handler.RoleNameFromChannel(mockconstants.TestGuild, channel.Name)
 
 	for _, guildRole := range guild.Roles {
 		if guildRole.Name == ephRoleName {
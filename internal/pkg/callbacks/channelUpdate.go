@@ -0,0 +1,181 @@
+package callbacks
+
+import (
+	"context"
+
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/config"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/operations"
+)
+
+const (
+	channelUpdate           = "ChannelUpdate"
+	channelUpdateEventError = "Unable to process event: " + channelUpdate
+)
+
+// ChannelUpdate is the callback function for the ChannelUpdate event from Discord.
+func (handler *Handler) ChannelUpdate(session *discordgo.Session, channel *discordgo.ChannelUpdate) {
+	if channel.Type != discordgo.ChannelTypeGuildVoice && channel.Type != discordgo.ChannelTypeGuildCategory {
+		return
+	}
+
+	settings := handler.GuildSettings(channel.GuildID)
+
+	guild, err := session.State.Guild(channel.GuildID)
+	if err != nil {
+		handler.Log.WithError(err).Error(channelUpdateEventError)
+		return
+	}
+
+	if channel.Type == discordgo.ChannelTypeGuildVoice && settings.CategoryRoles() {
+		defer handler.reassignCategoryRoles(session, guild, channel.Channel)
+	}
+
+	if !isRoleChannel(channel.Channel, settings) {
+		return
+	}
+
+	role, err := handler.lookupChannelRole(session, guild, channel.Channel)
+	if err != nil {
+		return
+	}
+
+	roleName := settings.RoleName(channel.Name)
+
+	if roleMatchesSettings(role, roleName, settings) {
+		return
+	}
+
+	ctx, cancel := handler.contextWithTimeout(context.Background())
+	defer cancel()
+
+	_, err = handler.editRole(ctx, guild, role, roleName, settings)
+	if err != nil {
+		log := handler.Log.WithField("guild", guild.Name).WithError(err)
+
+		if operations.ShouldLogDebug(err) {
+			log.Debug(channelUpdateEventError)
+			return
+		}
+
+		log.Error(channelUpdateEventError)
+	}
+}
+
+// reassignCategoryRoles processes the voice states of the members connected
+// to the provided voice channel whose category roles do not match its
+// category, such as after the channel moved to another category. The state
+// cache already holds the updated channel, so a move is detected from the
+// category roles the members hold rather than the channel's previous parent.
+func (handler *Handler) reassignCategoryRoles(session *discordgo.Session, guild *discordgo.Guild, channel *discordgo.Channel) {
+	for _, userID := range handler.staleCategoryMembers(session, guild, channel) {
+		voiceState := &discordgo.VoiceStateUpdate{
+			VoiceState: &discordgo.VoiceState{
+				GuildID:   guild.ID,
+				UserID:    userID,
+				ChannelID: channel.ID,
+			},
+		}
+
+		if handler.VoiceStateDispatcher != nil {
+			handler.VoiceStateDispatcher.VoiceStateUpdate(session, voiceState)
+		} else {
+			handler.VoiceStateUpdate(session, voiceState)
+		}
+	}
+}
+
+// staleCategoryMembers returns the IDs of the members connected to the
+// provided voice channel who hold the ephemeral role of another category, or
+// who do not hold the ephemeral role of the channel's category. Members
+// missing from the state cache are assumed to be stale.
+func (handler *Handler) staleCategoryMembers(session *discordgo.Session, guild *discordgo.Guild, channel *discordgo.Channel) []string {
+	channelRoles := handler.RoleMap.Channels(guild.ID)
+
+	var categoryRoleID string
+
+	if category := channelCategory(session, channel); category != nil {
+		categoryRoleID = channelRoles[category.ID]
+	}
+
+	session.State.RLock()
+	defer session.State.RUnlock()
+
+	categoryRoles := make(map[string]bool)
+
+	for _, guildChannel := range guild.Channels {
+		roleID, found := channelRoles[guildChannel.ID]
+		if found && guildChannel.Type == discordgo.ChannelTypeGuildCategory {
+			categoryRoles[roleID] = true
+		}
+	}
+
+	memberRoles := make(map[string][]string, len(guild.Members))
+
+	for _, member := range guild.Members {
+		if member.User != nil {
+			memberRoles[member.User.ID] = member.Roles
+		}
+	}
+
+	staleMembers := make([]string, 0)
+
+	for _, voiceState := range guild.VoiceStates {
+		if voiceState.ChannelID != channel.ID {
+			continue
+		}
+
+		roles, found := memberRoles[voiceState.UserID]
+		if !found || !holdsCategoryRoles(roles, categoryRoles, categoryRoleID) {
+			staleMembers = append(staleMembers, voiceState.UserID)
+		}
+	}
+
+	return staleMembers
+}
+
+// holdsCategoryRoles returns whether the provided roles include the category
+// role associated with the provided categoryRoleID, if any, and no other of
+// the provided categoryRoles.
+func holdsCategoryRoles(roles []string, categoryRoles map[string]bool, categoryRoleID string) bool {
+	hasCategoryRole := categoryRoleID == ""
+
+	for _, roleID := range roles {
+		switch {
+		case roleID == categoryRoleID:
+			hasCategoryRole = true
+		case categoryRoles[roleID]:
+			return false
+		}
+	}
+
+	return hasCategoryRole
+}
+
+func (handler *Handler) editRole(
+	ctx context.Context,
+	guild *discordgo.Guild,
+	role *discordgo.Role,
+	roleName string,
+	settings *config.Settings,
+) (*discordgo.Role, error) {
+	return handler.OperationsGateway.EditRole(&operations.EditRoleRequest{
+		Guild:           guild,
+		Role:            role,
+		RoleName:        roleName,
+		RoleColor:       settings.RoleColor,
+		RoleHoist:       settings.RoleHoist,
+		RoleMentionable: settings.RoleMentionable,
+	}).Wait(ctx)
+}
+
+// roleMatchesSettings returns whether the provided role already has the
+// provided roleName and the role color and display options of the provided
+// settings.
+func roleMatchesSettings(role *discordgo.Role, roleName string, settings *config.Settings) bool {
+	return role.Name == roleName &&
+		role.Color == settings.RoleColor &&
+		role.Hoist == settings.RoleHoist &&
+		role.Mentionable == settings.RoleMentionable
+}
@@ -0,0 +1,74 @@
+package callbacks_test
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ewohltman/discordgo-mock/mockconstants"
+
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/callbacks"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/capacity"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/mock"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/monitor"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/operations"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/rolemap"
+)
+
+func TestHandler_ChannelUpdate(t *testing.T) {
+	session, err := mock.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log := mock.NewLogger()
+
+	handler := &callbacks.Handler{
+		Log:                     log,
+		BotName:                 "testBot",
+		BotKeyword:              "testKeyword",
+		RolePrefix:              "{eph}",
+		RoleColor:               0xffa500,
+		ReconcileAddedCounter:   monitor.ReconcileAddedCounter(&monitor.Config{Log: log}),
+		ReconcileRemovedCounter: monitor.ReconcileRemovedCounter(&monitor.Config{Log: log}),
+		OperationsGateway:       operations.NewGateway(session),
+		RoleMap:                 rolemap.New(),
+		Capacity:                capacity.NewManager(),
+	}
+
+	guild, err := session.State.Guild(mockconstants.TestGuild)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler.GuildCreate(session, &discordgo.GuildCreate{Guild: guild})
+
+	channel, err := session.State.Channel(mockconstants.TestChannel)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roleID, found := handler.RoleMap.RoleID(mockconstants.TestGuild, mockconstants.TestChannel)
+	if !found {
+		t.Fatalf("Unable to find ephemeral role for channel %s", channel.Name)
+	}
+
+	renamedChannel := *channel
+	renamedChannel.Name = "renamed"
+
+	handler.ChannelUpdate(session, &discordgo.ChannelUpdate{Channel: &renamedChannel})
+
+	role, err := session.State.Role(mockconstants.TestGuild, roleID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedRoleName := handler.RoleNameFromChannel(mockconstants.TestGuild, renamedChannel.Name)
+
+	if role.Name != expectedRoleName {
+		t.Errorf("Unexpected role name. Got: %s, Expected: %s", role.Name, expectedRoleName)
+	}
+
+	if role.Color != handler.RoleColor {
+		t.Errorf("Unexpected role color. Got: %d, Expected: %d", role.Color, handler.RoleColor)
+	}
+}
@@ -0,0 +1,308 @@
+package callbacks
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Command is a command users run by sending the bot keyword followed by the
+// command's Name or one of its Aliases, and then its Arguments.
+type Command struct {
+	Name        string
+	Aliases     []string
+	Description string
+	Arguments   []*CommandArgument
+	Requirement CommandRequirement
+
+	// Run runs the command, returning the response to send to the user or
+	// nil to not respond.
+	Run func(handler *Handler, invocation *CommandInvocation) (*discordgo.MessageSend, error)
+}
+
+// CommandArgument is a positional argument of a Command.
+type CommandArgument struct {
+	Name        string
+	Description string
+	Required    bool
+
+	// Choices are the values the argument accepts, matched
+	// case-insensitively. Any value is accepted if there are no Choices.
+	Choices []string
+}
+
+// CommandInvocation is a Command being run by a user.
+type CommandInvocation struct {
+	Session   *discordgo.Session
+	Command   *Command
+	UserID    string
+	GuildID   string
+	ChannelID string
+	Arguments map[string]string
+}
+
+// Argument returns the value of the argument associated with the provided
+// name, or an empty string if it was not provided.
+func (invocation *CommandInvocation) Argument(name string) string {
+	return invocation.Arguments[name]
+}
+
+// InvalidArguments is an error for when a Command is run with arguments that
+// do not match its argument schema.
+type InvalidArguments struct {
+	Command *Command
+	Reason  string
+}
+
+// Error satisfies the error interface.
+func (invalidArgs *InvalidArguments) Error() string {
+	return fmt.Sprintf("invalid arguments for %s: %s", invalidArgs.Command.Name, invalidArgs.Reason)
+}
+
+// CommandRegistry holds the commands users are able to run.
+type CommandRegistry struct {
+	commands []*Command
+	lookup   map[string]*Command
+}
+
+// NewCommandRegistry returns a new *CommandRegistry with the built-in
+// commands registered.
+func NewCommandRegistry() *CommandRegistry {
+	registry := &CommandRegistry{
+		lookup: make(map[string]*Command),
+	}
+
+	for _, command := range builtinCommands() {
+		registry.add(command)
+	}
+
+	return registry
+}
+
+// Register registers the provided command. It returns an error if the
+// command's name or one of its aliases is already registered.
+func (registry *CommandRegistry) Register(command *Command) error {
+	for _, name := range command.names() {
+		if _, found := registry.lookup[name]; found {
+			return fmt.Errorf("command %s already registered", name)
+		}
+	}
+
+	if command.Run == nil {
+		return fmt.Errorf("command %s has no Run function", command.Name)
+	}
+
+	registry.add(command)
+
+	return nil
+}
+
+// Lookup returns the command registered with the provided name or alias.
+func (registry *CommandRegistry) Lookup(name string) (*Command, bool) {
+	command, found := registry.lookup[strings.ToLower(name)]
+
+	return command, found
+}
+
+// Commands returns the registered commands sorted by name.
+func (registry *CommandRegistry) Commands() []*Command {
+	commands := make([]*Command, len(registry.commands))
+	copy(commands, registry.commands)
+
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].Name < commands[j].Name
+	})
+
+	return commands
+}
+
+func (registry *CommandRegistry) add(command *Command) {
+	registry.commands = append(registry.commands, command)
+
+	for _, name := range command.names() {
+		registry.lookup[name] = command
+	}
+}
+
+// Usage returns the usage of the command, with required arguments in angle
+// brackets and optional arguments in square brackets.
+func (command *Command) Usage(botKeyword string) string {
+	usage := []string{botKeyword, command.Name}
+
+	for _, argument := range command.Arguments {
+		if argument.Required {
+			usage = append(usage, "<"+argument.Name+">")
+		} else {
+			usage = append(usage, "["+argument.Name+"]")
+		}
+	}
+
+	return strings.Join(usage, " ")
+}
+
+// ParseArguments returns the provided positional argument tokens keyed by
+// the names of the command's arguments, or an *InvalidArguments error if they
+// do not match the command's argument schema.
+func (command *Command) ParseArguments(tokens []string) (map[string]string, error) {
+	if len(tokens) > len(command.Arguments) {
+		return nil, &InvalidArguments{
+			Command: command,
+			Reason:  fmt.Sprintf("expected at most %d arguments, got %d", len(command.Arguments), len(tokens)),
+		}
+	}
+
+	named := make(map[string]string, len(tokens))
+
+	for i, token := range tokens {
+		named[command.Arguments[i].Name] = token
+	}
+
+	return command.ValidateArguments(named)
+}
+
+// ValidateArguments returns the provided named arguments with their values
+// normalized to the command's argument schema, or an *InvalidArguments error
+// if they do not match it.
+func (command *Command) ValidateArguments(named map[string]string) (map[string]string, error) {
+	arguments := make(map[string]string, len(command.Arguments))
+
+	for _, argument := range command.Arguments {
+		token, found := named[argument.Name]
+		if !found {
+			if argument.Required {
+				return nil, &InvalidArguments{
+					Command: command,
+					Reason:  fmt.Sprintf("missing required argument %s", argument.Name),
+				}
+			}
+
+			continue
+		}
+
+		value, err := argument.parse(token)
+		if err != nil {
+			return nil, &InvalidArguments{Command: command, Reason: err.Error()}
+		}
+
+		arguments[argument.Name] = value
+	}
+
+	if len(arguments) != len(named) {
+		return nil, &InvalidArguments{
+			Command: command,
+			Reason:  fmt.Sprintf("unknown arguments, expected %s", strings.Join(command.argumentNames(), ", ")),
+		}
+	}
+
+	return arguments, nil
+}
+
+func (command *Command) argumentNames() []string {
+	names := make([]string, len(command.Arguments))
+
+	for i, argument := range command.Arguments {
+		names[i] = argument.Name
+	}
+
+	return names
+}
+
+func (command *Command) names() []string {
+	names := make([]string, 0, len(command.Aliases)+1)
+	names = append(names, strings.ToLower(command.Name))
+
+	for _, alias := range command.Aliases {
+		names = append(names, strings.ToLower(alias))
+	}
+
+	return names
+}
+
+func (argument *CommandArgument) parse(token string) (string, error) {
+	if len(argument.Choices) == 0 {
+		return token, nil
+	}
+
+	for _, choice := range argument.Choices {
+		if strings.EqualFold(token, choice) {
+			return choice, nil
+		}
+	}
+
+	return "", fmt.Errorf(
+		"%s must be one of %s, got %q",
+		argument.Name, strings.Join(argument.Choices, ", "), token,
+	)
+}
+
+func builtinCommands() []*Command {
+	return []*Command{
+		{
+			Name:        HelpCommand,
+			Aliases:     []string{"commands"},
+			Description: "Lists the available commands.",
+			Run:         (*Handler).runHelp,
+		},
+		{
+			Name:        InfoCommand,
+			Aliases:     []string{"about"},
+			Description: "Shows information about the bot.",
+			Run:         (*Handler).runInfo,
+		},
+		{
+			Name:        DiagnoseCommand,
+			Aliases:     []string{"diagnostics"},
+			Description: "Reports problems with the bot's setup in this server and how to fix them.",
+			Requirement: CommandRequirement{Permissions: discordgo.PermissionManageServer},
+			Run:         (*Handler).runDiagnose,
+		},
+		{
+			Name:        ConfigCommand,
+			Aliases:     []string{"settings"},
+			Description: "Shows or changes the bot's settings in this server.",
+			Requirement: CommandRequirement{Permissions: discordgo.PermissionManageServer},
+			Arguments: []*CommandArgument{
+				{
+					Name:        configActionArgument,
+					Description: "Whether to show, set or reset the settings.",
+					Required:    true,
+					Choices:     []string{ConfigActionShow, ConfigActionSet, ConfigActionReset},
+				},
+				{
+					Name:        configSettingArgument,
+					Description: "The setting to set or reset. Reset without a setting resets every setting.",
+					Choices:     configSettingNames(),
+				},
+				{
+					Name:        configValueArgument,
+					Description: "The new value, e.g. #ffa500, on/off, category, or rules such as channel:<ID>, name:<pattern>.",
+				},
+			},
+			Run: (*Handler).runConfig,
+		},
+		{
+			Name:        LogLevelCommand,
+			Aliases:     []string{"loglevel"},
+			Description: "Changes the bot's logging level.",
+			Requirement: CommandRequirement{BotOwner: true},
+			Arguments: []*CommandArgument{
+				{
+					Name:        logLevelArgument,
+					Description: "The new logging level.",
+					Required:    true,
+					Choices: []string{
+						LogLevelParamDebug,
+						LogLevelParamInfo,
+						LogLevelParamWarning,
+						LogLevelParamError,
+						LogLevelParamFatal,
+						LogLevelParamPanic,
+					},
+				},
+			},
+			Run: (*Handler).runLogLevel,
+		},
+	}
+}
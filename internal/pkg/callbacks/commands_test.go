@@ -0,0 +1,151 @@
+package callbacks_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/callbacks"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/mock"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/monitor"
+)
+
+func TestCommandRegistry(t *testing.T) {
+	registry := callbacks.NewCommandRegistry()
+
+	command, found := registry.Lookup("LOGLEVEL")
+	if !found || command.Name != callbacks.LogLevelCommand {
+		t.Errorf("Expected alias lookup to find %s", callbacks.LogLevelCommand)
+	}
+
+	_, found = registry.Lookup("ixnay")
+	if found {
+		t.Error("Unexpected command found")
+	}
+
+	run := func(*callbacks.Handler, *callbacks.CommandInvocation) (*discordgo.MessageSend, error) {
+		return nil, nil
+	}
+
+	err := registry.Register(&callbacks.Command{Name: "test", Aliases: []string{callbacks.InfoCommand}, Run: run})
+	if err == nil {
+		t.Error("Expected error registering duplicate alias")
+	}
+
+	err = registry.Register(&callbacks.Command{Name: "test"})
+	if err == nil {
+		t.Error("Expected error registering command without Run function")
+	}
+
+	err = registry.Register(&callbacks.Command{Name: "test", Run: run})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commands := registry.Commands()
+
+	for i := 1; i < len(commands); i++ {
+		if commands[i-1].Name > commands[i].Name {
+			t.Errorf("Commands not sorted by name: %s > %s", commands[i-1].Name, commands[i].Name)
+		}
+	}
+}
+
+func TestCommand_ParseArguments(t *testing.T) {
+	command := &callbacks.Command{
+		Name: "test",
+		Arguments: []*callbacks.CommandArgument{
+			{Name: "level", Required: true, Choices: []string{"debug", "info"}},
+			{Name: "optional"},
+		},
+	}
+
+	if usage := command.Usage("!eph"); usage != "!eph test <level> [optional]" {
+		t.Errorf("Unexpected usage: %s", usage)
+	}
+
+	arguments, err := command.ParseArguments([]string{"DEBUG"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if arguments["level"] != "debug" || arguments["optional"] != "" {
+		t.Errorf("Unexpected arguments: %v", arguments)
+	}
+
+	for _, tokens := range [][]string{
+		{},
+		{"verbose"},
+		{"info", "optional", "extra"},
+	} {
+		var invalidArgs *callbacks.InvalidArguments
+
+		_, err = command.ParseArguments(tokens)
+		if !errors.As(err, &invalidArgs) {
+			t.Errorf("Expected *callbacks.InvalidArguments for %q, got: %v", tokens, err)
+		}
+	}
+}
+
+func TestHandler_MessageCreate_router(t *testing.T) {
+	session, err := mock.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := &messageRecorder{next: session.Client.Transport}
+	session.Client.Transport = recorder
+
+	log := mock.NewLogger()
+
+	handler := &callbacks.Handler{
+		Log:                  log,
+		BotName:              "testBot",
+		BotKeyword:           "testKeyword",
+		MessageCreateCounter: monitor.MessageCreateCounter(&monitor.Config{Log: log}),
+		Commands:             callbacks.NewCommandRegistry(),
+	}
+
+	sendMessage(session, handler, fmt.Sprintf("%s\n  %s", handler.BotKeyword, callbacks.HelpCommand))
+
+	help := recorder.lastEmbed(t)
+	if len(help.Fields) != len(handler.Commands.Commands()) {
+		t.Errorf("Unexpected number of help fields: %d", len(help.Fields))
+	}
+
+	for _, field := range help.Fields {
+		if strings.Contains(field.Name, callbacks.LogLevelCommand) && !strings.Contains(field.Value, callbacks.LogLevelParamDebug) {
+			t.Errorf("Expected %s help to list its choices: %s", callbacks.LogLevelCommand, field.Value)
+		}
+	}
+
+	testCases := []struct {
+		message  string
+		expected string
+	}{
+		{
+			message:  fmt.Sprintf("%s ixnay", handler.BotKeyword),
+			expected: "Unknown command `ixnay`",
+		},
+		{
+			message:  fmt.Sprintf("%s %s verbose", handler.BotKeyword, callbacks.LogLevelCommand),
+			expected: "Usage: `testKeyword log_level <level>`",
+		},
+		{
+			message:  fmt.Sprintf(`%s %s "debug`, handler.BotKeyword, callbacks.LogLevelCommand),
+			expected: callbacks.ErrUnterminatedQuote.Error(),
+		},
+	}
+
+	for _, testCase := range testCases {
+		sendMessage(session, handler, testCase.message)
+
+		content := recorder.lastMessage(t).Content
+		if !strings.Contains(content, testCase.expected) {
+			t.Errorf("Expected response to %q to contain %q, got: %q", testCase.message, testCase.expected, content)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package callbacks
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/operations"
+)
+
+// MaxGuildRoles is the maximum number of roles Discord allows in a guild.
+const MaxGuildRoles = 250
+
+// Diagnose embed field names.
+const (
+	DiagnosePermissionsField   = "Permissions"
+	DiagnoseHierarchyField     = "Role hierarchy"
+	DiagnoseRoleCountField     = "Role count"
+	DiagnoseVoiceChannelsField = "Voice channels"
+	DiagnoseRecentErrorsField  = "Recent errors"
+)
+
+const (
+	diagnoseNoGuildDescription  = "The diagnose command can only be used in a server."
+	diagnoseOKDescription       = "No problems found."
+	diagnoseProblemsDescription = "Problems found. Each section below suggests a fix."
+
+	diagnoseOKColor      = 0x2ecc71
+	diagnoseProblemColor = 0xe74c3c
+
+	// Roles near the cap leave little room for new ephemeral roles
+	roleCountWarningThreshold = MaxGuildRoles - 10
+
+	// Discord limits embed field values to 1024 characters
+	maxFieldValueLength = 1024
+	maxFieldListItems   = 10
+)
+
+// diagnosis is the result of the diagnose command for a guild.
+type diagnosis struct {
+	permissions    *operations.PermissionDiagnosis
+	permissionsErr error
+	roleCount      int
+	ephemeralRoles int
+	hiddenChannels []string
+	recentErrors   []*RecentError
+}
+
+// ok returns whether the diagnosis found no problems. Recent errors are only
+// problems if they have an actionable fix, as errors such as members leaving
+// mid-event are routine.
+func (diag *diagnosis) ok() bool {
+	if diag.permissionsErr != nil ||
+		!diag.permissions.OK() ||
+		diag.roleCount >= roleCountWarningThreshold ||
+		len(diag.hiddenChannels) != 0 {
+		return false
+	}
+
+	for _, recentError := range diag.recentErrors {
+		if isActionable(recentError.Err) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (handler *Handler) runDiagnose(invocation *CommandInvocation) (*discordgo.MessageSend, error) {
+	if invocation.GuildID == "" {
+		return &discordgo.MessageSend{
+			Embed: &discordgo.MessageEmbed{
+				Title:       handler.GuildSettings(invocation.GuildID).BotName + " diagnostics",
+				Color:       diagnoseProblemColor,
+				Description: diagnoseNoGuildDescription,
+			},
+		}, nil
+	}
+
+	guild, err := operations.LookupGuild(invocation.Session, invocation.GuildID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to diagnose guild: %w", err)
+	}
+
+	return &discordgo.MessageSend{
+		Embed: handler.diagnoseMessage(guild, handler.diagnose(invocation.Session, guild)),
+	}, nil
+}
+
+func (handler *Handler) diagnose(s *discordgo.Session, guild *discordgo.Guild) *diagnosis {
+	diag := &diagnosis{
+		recentErrors: handler.RecentErrors.Guild(guild.ID),
+	}
+
+	settings := handler.GuildSettings(guild.ID)
+	rolePrefix := settings.RolePrefix
+
+	diag.permissions, diag.permissionsErr = operations.AnalyzePermissions(s, guild, rolePrefix)
+
+	s.State.RLock()
+
+	voiceChannels := make([]*discordgo.Channel, 0, len(guild.Channels))
+
+	// Excluded channels do not need to be visible to the bot
+	for _, channel := range guild.Channels {
+		if channel.Type == discordgo.ChannelTypeGuildVoice && settings.ChannelEnabled(channel, guild.AfkChannelID) {
+			voiceChannels = append(voiceChannels, channel)
+		}
+	}
+
+	diag.roleCount = len(guild.Roles)
+
+	for _, role := range guild.Roles {
+		if strings.HasPrefix(role.Name, rolePrefix) {
+			diag.ephemeralRoles++
+		}
+	}
+
+	s.State.RUnlock()
+
+	for _, channel := range voiceChannels {
+		if operations.BotHasChannelPermission(s, channel) != nil {
+			diag.hiddenChannels = append(diag.hiddenChannels, channel.Name)
+		}
+	}
+
+	return diag
+}
+
+func (handler *Handler) diagnoseMessage(guild *discordgo.Guild, diag *diagnosis) *discordgo.MessageEmbed {
+	embed := &discordgo.MessageEmbed{
+		Title:       fmt.Sprintf("%s diagnostics for %s", handler.GuildSettings(guild.ID).BotName, guild.Name),
+		Color:       diagnoseOKColor,
+		Description: diagnoseOKDescription,
+		Fields: []*discordgo.MessageEmbedField{
+			diagnosePermissions(diag),
+			diagnoseHierarchy(diag),
+			diagnoseRoleCount(diag),
+			diagnoseVoiceChannels(diag),
+			diagnoseRecentErrors(diag),
+		},
+	}
+
+	if !diag.ok() {
+		embed.Color = diagnoseProblemColor
+		embed.Description = diagnoseProblemsDescription
+	}
+
+	return embed
+}
+
+func diagnosePermissions(diag *diagnosis) *discordgo.MessageEmbedField {
+	field := &discordgo.MessageEmbedField{Name: DiagnosePermissionsField}
+
+	switch {
+	case diag.permissionsErr != nil:
+		field.Value = fmt.Sprintf(
+			"Unable to check permissions: %s\n**Fix:** make sure the bot is a member of this server.",
+			diag.permissionsErr,
+		)
+	case !diag.permissions.ManageRoles:
+		field.Value = "The bot is missing the Manage Roles permission.\n" +
+			"**Fix:** grant the bot's role Manage Roles in Server Settings > Roles."
+	default:
+		field.Value = "The bot has the Manage Roles permission."
+	}
+
+	return field
+}
+
+func diagnoseHierarchy(diag *diagnosis) *discordgo.MessageEmbedField {
+	field := &discordgo.MessageEmbedField{Name: DiagnoseHierarchyField}
+
+	if diag.permissionsErr != nil {
+		field.Value = "Unable to check the role hierarchy."
+		return field
+	}
+
+	if len(diag.permissions.UnmanageableRoles) == 0 {
+		field.Value = "Every ephemeral role is below the bot's highest role."
+		return field
+	}
+
+	roleNames := make([]string, len(diag.permissions.UnmanageableRoles))
+
+	for i, role := range diag.permissions.UnmanageableRoles {
+		roleNames[i] = role.Name
+	}
+
+	topRole := "its highest role"
+	if diag.permissions.TopRole != nil {
+		topRole = diag.permissions.TopRole.Name
+	}
+
+	field.Value = fieldValue(
+		"These ephemeral roles are not below the bot's highest role:",
+		roleNames,
+		fmt.Sprintf("**Fix:** drag %s above them in Server Settings > Roles.", topRole),
+	)
+
+	return field
+}
+
+func diagnoseRoleCount(diag *diagnosis) *discordgo.MessageEmbedField {
+	field := &discordgo.MessageEmbedField{
+		Name: DiagnoseRoleCountField,
+		Value: fmt.Sprintf(
+			"%d of %d roles are in use, %d of them ephemeral.",
+			diag.roleCount, MaxGuildRoles, diag.ephemeralRoles,
+		),
+	}
+
+	if diag.roleCount >= roleCountWarningThreshold {
+		field.Value += "\n**Fix:** delete unused roles so new ephemeral roles can be created."
+	}
+
+	return field
+}
+
+func diagnoseVoiceChannels(diag *diagnosis) *discordgo.MessageEmbedField {
+	field := &discordgo.MessageEmbedField{Name: DiagnoseVoiceChannelsField}
+
+	if len(diag.hiddenChannels) == 0 {
+		field.Value = "The bot can view every voice channel."
+		return field
+	}
+
+	field.Value = fieldValue(
+		"The bot cannot view these voice channels:",
+		diag.hiddenChannels,
+		"**Fix:** allow the bot View Channel on them, unless they should not have ephemeral roles.",
+	)
+
+	return field
+}
+
+func diagnoseRecentErrors(diag *diagnosis) *discordgo.MessageEmbedField {
+	field := &discordgo.MessageEmbedField{Name: DiagnoseRecentErrorsField}
+
+	if len(diag.recentErrors) == 0 {
+		field.Value = "No recent errors."
+		return field
+	}
+
+	lines := make([]string, len(diag.recentErrors))
+
+	for i, recentError := range diag.recentErrors {
+		lines[i] = fmt.Sprintf(
+			"%s: %s\n**Fix:** %s",
+			recentError.Time.UTC().Format("2006-01-02 15:04:05 MST"),
+			recentError.Err,
+			suggestedFix(recentError.Err),
+		)
+	}
+
+	field.Value = fieldValue("", lines, "")
+
+	return field
+}
+
+// suggestedFix returns a suggested fix for the provided CallbackError.
+func suggestedFix(callbackError CallbackError) string {
+	var (
+		insufficientPermissionsErr *InsufficientPermissions
+		maxNumberOfRolesErr        *MaxNumberOfRoles
+		deadlineExceededErr        *DeadlineExceeded
+	)
+
+	switch {
+	case errors.As(callbackError, &insufficientPermissionsErr):
+		return "check the permissions and role hierarchy above."
+	case errors.As(callbackError, &maxNumberOfRolesErr):
+		return "delete unused roles so new ephemeral roles can be created."
+	case errors.As(callbackError, &deadlineExceededErr):
+		return "Discord was slow to respond. This usually resolves itself."
+	default:
+		return "this usually resolves itself. Rejoin the voice channel to retry."
+	}
+}
+
+// isActionable returns whether the provided CallbackError has a fix the
+// guild can apply, rather than one which usually resolves itself.
+func isActionable(callbackError CallbackError) bool {
+	var (
+		insufficientPermissionsErr *InsufficientPermissions
+		maxNumberOfRolesErr        *MaxNumberOfRoles
+	)
+
+	return errors.As(callbackError, &insufficientPermissionsErr) || errors.As(callbackError, &maxNumberOfRolesErr)
+}
+
+// fieldValue joins the provided header, list items and footer into an embed
+// field value within Discord's length limit.
+func fieldValue(header string, items []string, footer string) string {
+	lines := make([]string, 0, maxFieldListItems+3)
+
+	if header != "" {
+		lines = append(lines, header)
+	}
+
+	for i, item := range items {
+		if i == maxFieldListItems {
+			lines = append(lines, fmt.Sprintf("...and %d more", len(items)-maxFieldListItems))
+			break
+		}
+
+		lines = append(lines, item)
+	}
+
+	if footer != "" {
+		lines = append(lines, footer)
+	}
+
+	value := []rune(strings.Join(lines, "\n"))
+
+	if len(value) > maxFieldValueLength {
+		return string(value[:maxFieldValueLength-3]) + "..."
+	}
+
+	return string(value)
+}
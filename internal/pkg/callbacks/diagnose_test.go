@@ -0,0 +1,249 @@
+package callbacks_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ewohltman/discordgo-mock/mockconstants"
+
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/callbacks"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/capacity"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/config"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/mock"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/monitor"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/operations"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/rolemap"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/tracer"
+)
+
+// messageRecorder records the messages sent through it.
+type messageRecorder struct {
+	next     http.RoundTripper
+	mutex    sync.Mutex
+	messages []*discordgo.MessageSend
+}
+
+func (recorder *messageRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == http.MethodPost && strings.HasSuffix(req.URL.Path, "/messages") {
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		message := &discordgo.MessageSend{}
+
+		err = json.Unmarshal(body, message)
+		if err != nil {
+			return nil, err
+		}
+
+		recorder.mutex.Lock()
+		recorder.messages = append(recorder.messages, message)
+		recorder.mutex.Unlock()
+	}
+
+	return recorder.next.RoundTrip(req)
+}
+
+func (recorder *messageRecorder) lastMessage(t *testing.T) *discordgo.MessageSend {
+	t.Helper()
+
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	if len(recorder.messages) == 0 {
+		t.Fatal("Expected a message to be sent")
+	}
+
+	return recorder.messages[len(recorder.messages)-1]
+}
+
+func (recorder *messageRecorder) lastEmbed(t *testing.T) *discordgo.MessageEmbed {
+	t.Helper()
+
+	embed := recorder.lastMessage(t).Embed
+	if embed == nil {
+		t.Fatal("Expected an embed to be sent")
+	}
+
+	return embed
+}
+
+func TestHandler_MessageCreate_diagnose(t *testing.T) {
+	jaegerTracer, jaegerCloser, err := tracer.New("test")
+	if err != nil {
+		t.Fatalf("Error creating Jaeger tracer: %s", err)
+	}
+
+	defer func() {
+		closeErr := jaegerCloser.Close()
+		if closeErr != nil {
+			t.Errorf("Error closing Jaeger tracer: %s", err)
+		}
+	}()
+
+	session, err := mock.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := &messageRecorder{next: session.Client.Transport}
+	session.Client.Transport = recorder
+
+	log := mock.NewLogger()
+
+	handler := &callbacks.Handler{
+		Log:                     log,
+		BotName:                 "testBot",
+		BotKeyword:              "testKeyword",
+		RolePrefix:              "{eph}",
+		JaegerTracer:            jaegerTracer,
+		ContextTimeout:          time.Second,
+		MessageCreateCounter:    monitor.MessageCreateCounter(&monitor.Config{Log: log}),
+		VoiceStateUpdateCounter: monitor.VoiceStateUpdateCounter(&monitor.Config{Log: log}),
+		OperationsGateway:       operations.NewGateway(session),
+		RoleMap:                 rolemap.New(),
+		Capacity:                capacity.NewManager(),
+		RecentErrors:            callbacks.NewRecentErrors(callbacks.DefaultRecentErrors),
+	}
+
+	diagnoseCommand := fmt.Sprintf("%s %s", handler.BotKeyword, callbacks.DiagnoseCommand)
+
+	// Members without Manage Server are denied
+	sendMessage(session, handler, diagnoseCommand)
+
+	recorder.mutex.Lock()
+	deniedMessages := len(recorder.messages)
+	recorder.mutex.Unlock()
+
+	if deniedMessages != 0 {
+		t.Fatal("Expected diagnose to be denied")
+	}
+
+	grantManageServer(t, session)
+
+	sendMessage(session, handler, diagnoseCommand)
+
+	fields := embedFields(recorder.lastEmbed(t))
+
+	if !strings.Contains(fields[callbacks.DiagnosePermissionsField], "has the Manage Roles permission") {
+		t.Errorf("Unexpected permissions diagnosis: %s", fields[callbacks.DiagnosePermissionsField])
+	}
+
+	if !strings.Contains(fields[callbacks.DiagnoseVoiceChannelsField], mockconstants.TestPrivateChannel) {
+		t.Errorf("Expected hidden voice channel: %s", fields[callbacks.DiagnoseVoiceChannelsField])
+	}
+
+	if !strings.Contains(fields[callbacks.DiagnoseRoleCountField], fmt.Sprintf("of %d roles", callbacks.MaxGuildRoles)) {
+		t.Errorf("Unexpected role count diagnosis: %s", fields[callbacks.DiagnoseRoleCountField])
+	}
+
+	// Channels excluded from ephemeral roles need not be visible to the bot
+	handler.GuildConfigs = config.NewMemoryStore()
+
+	err = handler.GuildConfigs.Put(&config.GuildConfig{
+		GuildID:         mockconstants.TestGuild,
+		ExcludeChannels: []config.ChannelRule{{ChannelID: mockconstants.TestPrivateChannel}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sendMessage(session, handler, diagnoseCommand)
+
+	fields = embedFields(recorder.lastEmbed(t))
+
+	if strings.Contains(fields[callbacks.DiagnoseVoiceChannelsField], mockconstants.TestPrivateChannel) {
+		t.Errorf("Unexpected excluded hidden voice channel: %s", fields[callbacks.DiagnoseVoiceChannelsField])
+	}
+
+	// Routine errors are reported without counting as problems
+	sendUpdate(session, handler, mockconstants.TestGuild, "unknownUser", mockconstants.TestChannel2)
+	sendMessage(session, handler, diagnoseCommand)
+
+	embed := recorder.lastEmbed(t)
+
+	if len(handler.RecentErrors.Guild(mockconstants.TestGuild)) == 0 {
+		t.Error("Expected a recent error for an unknown member")
+	}
+
+	if !strings.Contains(embed.Description, "No problems found") {
+		t.Errorf("Unexpected diagnosis with only routine errors: %s", embed.Description)
+	}
+
+	// Problems found while handling voice state updates are reported
+	botRole, err := session.State.Role(mockconstants.TestGuild, mock.BotRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	botRole.Permissions = discordgo.PermissionViewChannel
+
+	sendUpdate(session, handler, mockconstants.TestGuild, mockconstants.TestUser, mockconstants.TestChannel2)
+	sendMessage(session, handler, diagnoseCommand)
+
+	fields = embedFields(recorder.lastEmbed(t))
+
+	if !strings.Contains(fields[callbacks.DiagnosePermissionsField], "missing the Manage Roles permission") {
+		t.Errorf("Expected missing Manage Roles: %s", fields[callbacks.DiagnosePermissionsField])
+	}
+
+	if !strings.Contains(fields[callbacks.DiagnoseRecentErrorsField], callbacks.InsufficientPermissionMessage) {
+		t.Errorf("Expected recent insufficient permissions error: %s", fields[callbacks.DiagnoseRecentErrorsField])
+	}
+
+	if embed = recorder.lastEmbed(t); !strings.Contains(embed.Description, "Problems found") {
+		t.Errorf("Unexpected diagnosis with missing permissions: %s", embed.Description)
+	}
+}
+
+func TestRecentErrors(t *testing.T) {
+	var nilRecentErrors *callbacks.RecentErrors
+
+	nilRecentErrors.Record(&callbacks.ChannelNotFound{Guild: &discordgo.Guild{ID: mockconstants.TestGuild}})
+
+	if len(nilRecentErrors.Guild(mockconstants.TestGuild)) != 0 {
+		t.Error("Unexpected errors recorded by nil recent errors")
+	}
+
+	recentErrors := callbacks.NewRecentErrors(2)
+	guild := &discordgo.Guild{ID: mockconstants.TestGuild}
+
+	for i := 0; i < 3; i++ {
+		recentErrors.Record(&callbacks.ChannelNotFound{Guild: guild, Err: fmt.Errorf("error %d", i)})
+	}
+
+	guildErrors := recentErrors.Guild(mockconstants.TestGuild)
+
+	if len(guildErrors) != 2 {
+		t.Fatalf("Unexpected number of recent errors: %d", len(guildErrors))
+	}
+
+	if !strings.Contains(guildErrors[0].Err.Error(), "error 2") || !strings.Contains(guildErrors[1].Err.Error(), "error 1") {
+		t.Errorf("Unexpected recent errors order: %s, %s", guildErrors[0].Err, guildErrors[1].Err)
+	}
+
+	if len(recentErrors.Guild(mockconstants.TestGuildLarge)) != 0 {
+		t.Error("Unexpected recent errors for another guild")
+	}
+}
+
+func embedFields(embed *discordgo.MessageEmbed) map[string]string {
+	fields := make(map[string]string, len(embed.Fields))
+
+	for _, field := range embed.Fields {
+		fields[field.Name] = field.Value
+	}
+
+	return fields
+}
@@ -0,0 +1,127 @@
+package callbacks
+
+import (
+	"context"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
+
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/operations"
+)
+
+const (
+	deleteEmptyRole      = "DeleteEmptyRole"
+	deleteEmptyRoleError = "Unable to process event: " + deleteEmptyRole
+	deletedEmptyRole     = "Deleted empty ephemeral role"
+)
+
+// EmptyRoleDeleter schedules the deletion of ephemeral roles once the last
+// member has left their channel and the configured delay has passed. A nil
+// *EmptyRoleDeleter never schedules deletions.
+type EmptyRoleDeleter struct {
+	Enabled bool
+	Guilds  map[string]bool
+	Delay   time.Duration
+
+	timers *timers
+}
+
+// NewEmptyRoleDeleter returns a new *EmptyRoleDeleter. Deletion on empty is
+// enabled for every guild if enabled is true, or otherwise only for the
+// guilds in the provided guild IDs.
+func NewEmptyRoleDeleter(enabled bool, guildIDs []string, delay time.Duration) *EmptyRoleDeleter {
+	emptyRoleDeleter := &EmptyRoleDeleter{
+		Enabled: enabled,
+		Guilds:  make(map[string]bool, len(guildIDs)),
+		Delay:   delay,
+		timers:  newTimers(),
+	}
+
+	for _, guildID := range guildIDs {
+		emptyRoleDeleter.Guilds[guildID] = true
+	}
+
+	return emptyRoleDeleter
+}
+
+// EnabledFor returns whether deletion on empty is enabled for the guild
+// associated with the provided guildID.
+func (emptyRoleDeleter *EmptyRoleDeleter) EnabledFor(guildID string) bool {
+	if emptyRoleDeleter == nil {
+		return false
+	}
+
+	return emptyRoleDeleter.Enabled || emptyRoleDeleter.Guilds[guildID]
+}
+
+// Schedule schedules deleteRole to be called once the delay has passed,
+// replacing any deletion already scheduled for the same role.
+func (emptyRoleDeleter *EmptyRoleDeleter) Schedule(guildID, roleID string, deleteRole func()) {
+	if emptyRoleDeleter == nil {
+		return
+	}
+
+	emptyRoleDeleter.timers.schedule(guildID+"/"+roleID, emptyRoleDeleter.Delay, deleteRole)
+}
+
+// Cancel cancels the deletion scheduled for the role associated with the
+// provided roleID. Cancel reports whether a scheduled deletion was cancelled.
+func (emptyRoleDeleter *EmptyRoleDeleter) Cancel(guildID, roleID string) bool {
+	if emptyRoleDeleter == nil {
+		return false
+	}
+
+	return emptyRoleDeleter.timers.cancel(guildID + "/" + roleID)
+}
+
+// scheduleEmptyRoleDeletion schedules the deletion of the provided ephemeral
+// role if deletion on empty is enabled for the guild and no members remain
+// in the role's channel.
+func (handler *Handler) scheduleEmptyRoleDeletion(session *discordgo.Session, guild *discordgo.Guild, roleID string) {
+	if !handler.EmptyRoleDeleter.EnabledFor(guild.ID) {
+		return
+	}
+
+	if handler.roleChannelOccupied(session, guild, roleID) {
+		return
+	}
+
+	handler.EmptyRoleDeleter.Schedule(guild.ID, roleID, func() {
+		handler.deleteEmptyRole(session, guild, roleID)
+	})
+}
+
+func (handler *Handler) deleteEmptyRole(session *discordgo.Session, guild *discordgo.Guild, roleID string) {
+	if handler.roleChannelOccupied(session, guild, roleID) {
+		return
+	}
+
+	log := handler.Log.WithFields(logrus.Fields{
+		"guild": guild.Name,
+		"role":  roleID,
+	})
+
+	ctx, cancel := handler.contextWithTimeout(context.Background())
+	defer cancel()
+
+	err := handler.deleteRole(ctx, operations.PriorityBackground, guild, roleID)
+	if err != nil {
+		log.WithError(err).Debug(deleteEmptyRoleError)
+		return
+	}
+
+	log.Debug(deletedEmptyRole)
+}
+
+// roleChannelOccupied returns whether any member is connected to the channel,
+// or to a voice channel in the category, mapped to the provided ephemeral
+// role.
+func (handler *Handler) roleChannelOccupied(session *discordgo.Session, guild *discordgo.Guild, roleID string) bool {
+	channelRoles := handler.RoleMap.Channels(guild.ID)
+
+	session.State.RLock()
+	defer session.State.RUnlock()
+
+	return connectedRoles(guild, channelRoles)[roleID]
+}
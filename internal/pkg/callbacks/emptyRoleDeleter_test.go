@@ -0,0 +1,134 @@
+package callbacks_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ewohltman/discordgo-mock/mockconstants"
+
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/callbacks"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/capacity"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/mock"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/monitor"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/operations"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/rolemap"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/tracer"
+)
+
+const (
+	testDeleteOnEmptyDelay   = 10 * time.Millisecond
+	testDeleteOnEmptyTimeout = time.Second
+)
+
+func TestHandler_VoiceStateUpdate_deleteOnEmpty(t *testing.T) {
+	jaegerTracer, jaegerCloser, err := tracer.New("test")
+	if err != nil {
+		t.Fatalf("Error creating Jaeger tracer: %s", err)
+	}
+
+	defer func() {
+		closeErr := jaegerCloser.Close()
+		if closeErr != nil {
+			t.Errorf("Error closing Jaeger tracer: %s", err)
+		}
+	}()
+
+	session, err := mock.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log := mock.NewLogger()
+
+	handler := &callbacks.Handler{
+		Log:                     log,
+		BotName:                 "testBot",
+		BotKeyword:              "testKeyword",
+		RolePrefix:              "{eph}",
+		JaegerTracer:            jaegerTracer,
+		VoiceStateUpdateCounter: monitor.VoiceStateUpdateCounter(&monitor.Config{Log: log}),
+		OperationsGateway:       operations.NewGateway(session),
+		RoleMap:                 rolemap.New(),
+		Capacity:                capacity.NewManager(),
+		EmptyRoleDeleter: callbacks.NewEmptyRoleDeleter(
+			false,
+			[]string{mockconstants.TestGuild},
+			testDeleteOnEmptyDelay,
+		),
+	}
+
+	// Rejoining during the delay cancels the deletion
+	roleID := joinChannel(t, session, handler, mockconstants.TestChannel2)
+
+	sendUpdate(session, handler, mockconstants.TestGuild, mockconstants.TestUser, "")
+	sendUpdate(session, handler, mockconstants.TestGuild, mockconstants.TestUser, mockconstants.TestChannel2)
+
+	time.Sleep(10 * testDeleteOnEmptyDelay)
+
+	_, err = session.State.Role(mockconstants.TestGuild, roleID)
+	if err != nil {
+		t.Errorf("Ephemeral role deleted after member rejoined: %s", err)
+	}
+
+	// Leaving the channel empty deletes the role after the delay
+	sendUpdate(session, handler, mockconstants.TestGuild, mockconstants.TestUser, "")
+
+	if !roleDeleted(session, handler, mockconstants.TestChannel2, roleID) {
+		t.Error("Ephemeral role not deleted after its channel was left empty")
+	}
+}
+
+func TestEmptyRoleDeleter_EnabledFor(t *testing.T) {
+	var disabled *callbacks.EmptyRoleDeleter
+
+	if disabled.EnabledFor(mockconstants.TestGuild) {
+		t.Error("Unexpected delete on empty enabled for nil deleter")
+	}
+
+	guildDeleter := callbacks.NewEmptyRoleDeleter(false, []string{mockconstants.TestGuild}, time.Minute)
+
+	if !guildDeleter.EnabledFor(mockconstants.TestGuild) {
+		t.Error("Expected delete on empty enabled for configured guild")
+	}
+
+	if guildDeleter.EnabledFor(mockconstants.TestGuildLarge) {
+		t.Error("Unexpected delete on empty enabled for unconfigured guild")
+	}
+
+	if !callbacks.NewEmptyRoleDeleter(true, nil, time.Minute).EnabledFor(mockconstants.TestGuildLarge) {
+		t.Error("Expected delete on empty enabled for every guild")
+	}
+}
+
+func joinChannel(t *testing.T, session *discordgo.Session, handler *callbacks.Handler, channelID string) string {
+	t.Helper()
+
+	sendUpdate(session, handler, mockconstants.TestGuild, mockconstants.TestUser, channelID)
+
+	roleID, found := handler.RoleMap.RoleID(mockconstants.TestGuild, channelID)
+	if !found {
+		t.Fatalf("Ephemeral role not created for channel %s", channelID)
+	}
+
+	return roleID
+}
+
+// roleDeleted waits for the provided role to be removed from the state cache
+// and unmapped from its channel.
+func roleDeleted(session *discordgo.Session, handler *callbacks.Handler, channelID, roleID string) bool {
+	deadline := time.Now().Add(testDeleteOnEmptyTimeout)
+
+	for time.Now().Before(deadline) {
+		_, stateErr := session.State.Role(mockconstants.TestGuild, roleID)
+		_, mapped := handler.RoleMap.RoleID(mockconstants.TestGuild, channelID)
+
+		if stateErr != nil && !mapped {
+			return true
+		}
+
+		time.Sleep(testDeleteOnEmptyDelay)
+	}
+
+	return false
+}
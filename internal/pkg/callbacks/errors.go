@@ -10,6 +10,7 @@ import (
 const (
 	MemberNotFoundMessage         = "member not found"
 	ChannelNotFoundMessage        = "channel not found"
+	ChannelExcludedMessage        = "channel excluded"
 	RoleNotFoundMessage           = "role not found"
 	InsufficientPermissionMessage = "insufficient permissions"
 	MaxNumberOfRolesMessage       = "max number of roles"
@@ -110,6 +111,45 @@ func (cnf *ChannelNotFound) InChannel() *discordgo.Channel {
 	return nil
 }
 
+// ChannelExcluded represents an error for when a voice channel is excluded
+// from ephemeral roles by the channel rules of its guild.
+type ChannelExcluded struct {
+	Guild   *discordgo.Guild
+	Member  *discordgo.Member
+	Channel *discordgo.Channel
+}
+
+// Is allows ChannelExcluded to be compared with errors.Is.
+func (ce *ChannelExcluded) Is(target error) bool {
+	_, ok := target.(*ChannelExcluded)
+	return ok
+}
+
+// Unwrap satisfies the CallbackError interface for ChannelExcluded.
+func (ce *ChannelExcluded) Unwrap() error {
+	return nil
+}
+
+// Error satisfies the errors interface for ChannelExcluded.
+func (ce *ChannelExcluded) Error() string {
+	return ChannelExcludedMessage
+}
+
+// InGuild returns guild metadata for ChannelExcluded.
+func (ce *ChannelExcluded) InGuild() *discordgo.Guild {
+	return ce.Guild
+}
+
+// ForMember returns member metadata for ChannelExcluded.
+func (ce *ChannelExcluded) ForMember() *discordgo.Member {
+	return ce.Member
+}
+
+// InChannel returns channel metadata for ChannelExcluded.
+func (ce *ChannelExcluded) InChannel() *discordgo.Channel {
+	return ce.Channel
+}
+
 // RoleNotFound represents an error for when the bot fails to find a role.
 type RoleNotFound struct{}
 
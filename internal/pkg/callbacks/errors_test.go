@@ -206,6 +206,42 @@ func TestChannelNotFound_InChannel(t *testing.T) {
 	}
 }
 
+func TestChannelExcluded_Is(t *testing.T) {
+	ce := &callbacks.ChannelExcluded{}
+
+	if errors.Is(fmt.Errorf(wrapMsg), &callbacks.ChannelExcluded{}) {
+		t.Error(invalidErrorAssertion)
+	}
+
+	if !errors.Is(ce, &callbacks.ChannelExcluded{}) {
+		t.Errorf(invalidErrorAssertion)
+	}
+}
+
+func TestChannelExcluded_Error(t *testing.T) {
+	ce := &callbacks.ChannelExcluded{}
+
+	if ce.Error() != callbacks.ChannelExcludedMessage {
+		t.Errorf("Unexpected error message. Got %s, Expected: %s", ce.Error(), callbacks.ChannelExcludedMessage)
+	}
+
+	if ce.Unwrap() != nil {
+		t.Error("Unexpected wrapped error")
+	}
+}
+
+func TestChannelExcluded_Metadata(t *testing.T) {
+	guild := &discordgo.Guild{Name: mockconstants.TestGuild}
+	member := &discordgo.Member{User: &discordgo.User{Username: mockconstants.TestUser}}
+	channel := &discordgo.Channel{Name: mockconstants.TestChannel}
+
+	ce := &callbacks.ChannelExcluded{Guild: guild, Member: member, Channel: channel}
+
+	if ce.InGuild() != guild || ce.ForMember() != member || ce.InChannel() != channel {
+		t.Error("Unexpected ChannelExcluded metadata")
+	}
+}
+
 func TestInsufficientPermission_Is(t *testing.T) {
 	inp := &callbacks.InsufficientPermissions{}
 
@@ -0,0 +1,224 @@
+package callbacks
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
+
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/operations"
+)
+
+const (
+	garbageCollect      = "GarbageCollect"
+	garbageCollectError = "Unable to process event: " + garbageCollect
+	collectedGuild      = "Collected orphaned ephemeral roles"
+
+	// garbageMinimumRoleAge protects recently created roles that may not be
+	// mapped to their channel yet.
+	garbageMinimumRoleAge = time.Minute
+)
+
+// GarbageRole is an orphaned ephemeral role found by the GarbageCollector.
+type GarbageRole struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// GarbageReport is the result of the most recent garbage collection of a
+// guild.
+type GarbageReport struct {
+	GuildID       string         `json:"guildID"`
+	GuildName     string         `json:"guildName"`
+	DryRun        bool           `json:"dryRun"`
+	CollectedAt   time.Time      `json:"collectedAt"`
+	OrphanedRoles []*GarbageRole `json:"orphanedRoles"`
+	Deleted       int            `json:"deleted"`
+	Errors        []string       `json:"errors,omitempty"`
+}
+
+// GarbageCollector periodically deletes ephemeral roles which no longer have
+// a matching voice channel.
+type GarbageCollector struct {
+	Handler      *Handler
+	Session      *discordgo.Session
+	Interval     time.Duration
+	DryRun       bool
+	DryRunGuilds map[string]bool
+
+	mutex   *sync.Mutex
+	reports map[string]*GarbageReport
+}
+
+// NewGarbageCollector returns a new *GarbageCollector. Orphaned roles are
+// only reported, not deleted, if dryRun is true or if the guild ID is in
+// dryRunGuilds.
+func NewGarbageCollector(
+	handler *Handler,
+	session *discordgo.Session,
+	interval time.Duration,
+	dryRun bool,
+	dryRunGuilds []string,
+) *GarbageCollector {
+	garbageCollector := &GarbageCollector{
+		Handler:      handler,
+		Session:      session,
+		Interval:     interval,
+		DryRun:       dryRun,
+		DryRunGuilds: make(map[string]bool, len(dryRunGuilds)),
+		mutex:        &sync.Mutex{},
+		reports:      make(map[string]*GarbageReport),
+	}
+
+	for _, guildID := range dryRunGuilds {
+		garbageCollector.DryRunGuilds[guildID] = true
+	}
+
+	return garbageCollector
+}
+
+// Collect sets up an infinite loop collecting orphaned ephemeral roles from
+// every guild in the session's state cache.
+func (garbageCollector *GarbageCollector) Collect(ctx context.Context) {
+	collectTicker := time.NewTicker(garbageCollector.Interval)
+	defer collectTicker.Stop()
+
+	for {
+		select {
+		case <-collectTicker.C:
+			for _, guild := range stateGuilds(garbageCollector.Session) {
+				garbageCollector.CollectGuild(ctx, guild)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// CollectGuild deletes the orphaned ephemeral roles of the provided guild,
+// unless the guild is in dry-run mode, and records the result.
+func (garbageCollector *GarbageCollector) CollectGuild(ctx context.Context, guild *discordgo.Guild) *GarbageReport {
+	report := &GarbageReport{
+		GuildID:       guild.ID,
+		GuildName:     guild.Name,
+		DryRun:        garbageCollector.isDryRun(guild.ID),
+		CollectedAt:   time.Now(),
+		OrphanedRoles: garbageCollector.orphanedRoles(guild, time.Now()),
+	}
+
+	if len(report.OrphanedRoles) == 0 {
+		garbageCollector.mutex.Lock()
+		delete(garbageCollector.reports, guild.ID)
+		garbageCollector.mutex.Unlock()
+
+		return report
+	}
+
+	log := garbageCollector.Handler.Log.WithField("guild", guild.Name)
+
+	if !report.DryRun {
+		ctx, cancel := garbageCollector.Handler.contextWithTimeout(ctx)
+		defer cancel()
+
+		for _, role := range report.OrphanedRoles {
+			err := garbageCollector.Handler.deleteRole(ctx, operations.PriorityBackground, guild, role.ID)
+			if err != nil {
+				log.WithError(err).Debug(garbageCollectError)
+				report.Errors = append(report.Errors, err.Error())
+
+				continue
+			}
+
+			report.Deleted++
+		}
+	}
+
+	log.WithFields(logrus.Fields{
+		"dryRun":   report.DryRun,
+		"orphaned": len(report.OrphanedRoles),
+		"deleted":  report.Deleted,
+	}).Info(collectedGuild)
+
+	garbageCollector.mutex.Lock()
+	garbageCollector.reports[guild.ID] = report
+	garbageCollector.mutex.Unlock()
+
+	return report
+}
+
+// Report returns the most recent garbage collection reports for guilds with
+// orphaned ephemeral roles, sorted by guild name.
+func (garbageCollector *GarbageCollector) Report() interface{} {
+	garbageCollector.mutex.Lock()
+	defer garbageCollector.mutex.Unlock()
+
+	reports := make([]*GarbageReport, 0, len(garbageCollector.reports))
+
+	for _, report := range garbageCollector.reports {
+		reports = append(reports, report)
+	}
+
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].GuildName < reports[j].GuildName
+	})
+
+	return reports
+}
+
+func (garbageCollector *GarbageCollector) isDryRun(guildID string) bool {
+	return garbageCollector.DryRun || garbageCollector.DryRunGuilds[guildID]
+}
+
+// orphanedRoles returns the ephemeral roles of the provided guild which are
+// neither mapped to nor named after an existing channel with ephemeral roles
+// under the guild's role mode, so switching role modes orphans the roles of
+// the previous mode.
+func (garbageCollector *GarbageCollector) orphanedRoles(guild *discordgo.Guild, now time.Time) []*GarbageRole {
+	handler := garbageCollector.Handler
+	session := garbageCollector.Session
+	settings := handler.GuildSettings(guild.ID)
+
+	session.State.RLock()
+	defer session.State.RUnlock()
+
+	activeRoleIDs := make(map[string]bool)
+	activeRoleNames := make(map[string]bool)
+
+	for _, channel := range guild.Channels {
+		if !isRoleChannel(channel, settings) {
+			continue
+		}
+
+		roleID, found := handler.RoleMap.RoleID(guild.ID, channel.ID)
+		if found {
+			activeRoleIDs[roleID] = true
+		}
+
+		activeRoleNames[settings.RoleName(channel.Name)] = true
+	}
+
+	orphanedRoles := make([]*GarbageRole, 0)
+
+	for _, role := range guild.Roles {
+		if !strings.HasPrefix(role.Name, settings.RolePrefix) {
+			continue
+		}
+
+		if activeRoleIDs[role.ID] || activeRoleNames[role.Name] {
+			continue
+		}
+
+		createdAt, err := discordgo.SnowflakeTimestamp(role.ID)
+		if err == nil && now.Sub(createdAt) < garbageMinimumRoleAge {
+			continue
+		}
+
+		orphanedRoles = append(orphanedRoles, &GarbageRole{ID: role.ID, Name: role.Name})
+	}
+
+	return orphanedRoles
+}
@@ -0,0 +1,154 @@
+package callbacks_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ewohltman/discordgo-mock/mockconstants"
+
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/callbacks"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/capacity"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/config"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/mock"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/operations"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/rolemap"
+)
+
+const (
+	testGarbageInterval = 10 * time.Millisecond
+	orphanedRoleID      = "orphanedRole"
+)
+
+func TestGarbageCollector_CollectGuild(t *testing.T) {
+	session, err := mock.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := &callbacks.Handler{
+		Log:               mock.NewLogger(),
+		BotName:           "testBot",
+		BotKeyword:        "testKeyword",
+		RolePrefix:        "{eph}",
+		OperationsGateway: operations.NewGateway(session),
+		RoleMap:           rolemap.New(),
+		Capacity:          capacity.NewManager(),
+	}
+
+	err = session.State.RoleAdd(mockconstants.TestGuild, &discordgo.Role{
+		ID:   orphanedRoleID,
+		Name: handler.RoleNameFromChannel(mockconstants.TestGuild, "deletedChannel"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	guild, err := session.State.Guild(mockconstants.TestGuild)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dryRunCollector := callbacks.NewGarbageCollector(
+		handler, session, testGarbageInterval,
+		false, []string{mockconstants.TestGuild},
+	)
+
+	report := dryRunCollector.CollectGuild(context.Background(), guild)
+	if !report.DryRun || len(report.OrphanedRoles) != 1 || report.Deleted != 0 {
+		t.Fatalf("Unexpected dry-run report: %+v", report)
+	}
+
+	if report.OrphanedRoles[0].ID != orphanedRoleID {
+		t.Errorf("Unexpected orphaned role: %+v", report.OrphanedRoles[0])
+	}
+
+	_, err = session.State.Role(mockconstants.TestGuild, orphanedRoleID)
+	if err != nil {
+		t.Fatalf("Orphaned role deleted in dry-run mode: %s", err)
+	}
+
+	garbageCollector := callbacks.NewGarbageCollector(handler, session, testGarbageInterval, false, nil)
+
+	report = garbageCollector.CollectGuild(context.Background(), guild)
+	if report.DryRun || report.Deleted != 1 {
+		t.Fatalf("Unexpected report: %+v", report)
+	}
+
+	_, err = session.State.Role(mockconstants.TestGuild, orphanedRoleID)
+	if err == nil {
+		t.Error("Orphaned role remains after garbage collection")
+	}
+
+	_, err = session.State.Role(mockconstants.TestGuild, handler.RoleNameFromChannel(mockconstants.TestGuild, mockconstants.TestChannel))
+	if err != nil {
+		t.Errorf("Active ephemeral role deleted: %s", err)
+	}
+
+	reports, ok := garbageCollector.Report().([]*callbacks.GarbageReport)
+	if !ok || len(reports) != 1 {
+		t.Errorf("Unexpected reports: %+v", garbageCollector.Report())
+	}
+
+	ctx, cancelCtx := context.WithTimeout(context.Background(), 2*testGarbageInterval)
+	defer cancelCtx()
+
+	garbageCollector.Collect(ctx)
+}
+
+func TestGarbageCollector_CollectGuild_roleModeSwitch(t *testing.T) {
+	session, err := mock.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addTestCategory(t, session)
+
+	store := config.NewMemoryStore()
+
+	handler := &callbacks.Handler{
+		Log:               mock.NewLogger(),
+		BotName:           "testBot",
+		BotKeyword:        "testKeyword",
+		RolePrefix:        "{eph}",
+		OperationsGateway: operations.NewGateway(session),
+		RoleMap:           rolemap.New(),
+		Capacity:          capacity.NewManager(),
+		GuildConfigs:      store,
+	}
+
+	err = session.State.RoleAdd(mockconstants.TestGuild, &discordgo.Role{
+		ID:   "categoryRole",
+		Name: handler.RoleNameFromChannel(mockconstants.TestGuild, testCategory),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	guild, err := session.State.Guild(mockconstants.TestGuild)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler.RoleMap.Set(mockconstants.TestGuild, mockconstants.TestChannel, testEphemeralRole)
+
+	garbageCollector := callbacks.NewGarbageCollector(handler, session, testGarbageInterval, true, nil)
+
+	report := garbageCollector.CollectGuild(context.Background(), guild)
+	if len(report.OrphanedRoles) != 1 || report.OrphanedRoles[0].ID != "categoryRole" {
+		t.Errorf("Unexpected orphaned roles with channel roles: %+v", report.OrphanedRoles)
+	}
+
+	// Channel roles of voice channels in a category are orphaned once the
+	// guild switches to category roles
+	err = store.Put(&config.GuildConfig{GuildID: mockconstants.TestGuild, RoleMode: config.String(config.RoleModeCategory)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report = garbageCollector.CollectGuild(context.Background(), guild)
+	if len(report.OrphanedRoles) != 1 || report.OrphanedRoles[0].ID != testEphemeralRole {
+		t.Errorf("Unexpected orphaned roles with category roles: %+v", report.OrphanedRoles)
+	}
+}
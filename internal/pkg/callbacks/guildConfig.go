@@ -0,0 +1,634 @@
+package callbacks
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
+
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/config"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/operations"
+)
+
+// Config command actions
+const (
+	ConfigActionShow  = "show"
+	ConfigActionSet   = "set"
+	ConfigActionReset = "reset"
+)
+
+// Config command settings
+const (
+	ConfigSettingPrefix      = "prefix"
+	ConfigSettingColor       = "color"
+	ConfigSettingHoist       = "hoist"
+	ConfigSettingMentionable = "mentionable"
+	ConfigSettingMode        = "mode"
+	ConfigSettingKeyword     = "keyword"
+	ConfigSettingInclude     = "include"
+	ConfigSettingExclude     = "exclude"
+)
+
+const (
+	configActionArgument  = "action"
+	configSettingArgument = "setting"
+	configValueArgument   = "value"
+
+	configMessageColor = infoMessageColor
+
+	guildConfigChanged      = "Guild config changed"
+	guildConfigRolesError   = "Unable to update ephemeral roles to guild config"
+	configNoGuildMessage    = "The config command can only be used in a server."
+	configUnavailable       = "Configuration is not available."
+	configMissingSetting    = "%s requires a setting"
+	configMissingValue      = "set requires a value"
+	configSetMessage        = "Set %s to %s."
+	configResetMessage      = "Reset %s to %s."
+	configResetAllMessage   = "Reset all settings to the defaults."
+	configRolesMessage      = "Ephemeral roles updated: %d."
+	configRolesErrorMessage = "Unable to update every ephemeral role: %s\nSend `%s %s` for details."
+	configKeywordMessage    = "Commands now start with `%s`."
+	configPrefixConflict    = "%s `%s` matches roles which are not ephemeral roles: %s"
+)
+
+func (handler *Handler) runConfig(invocation *CommandInvocation) (*discordgo.MessageSend, error) {
+	if invocation.GuildID == "" {
+		return &discordgo.MessageSend{Content: configNoGuildMessage}, nil
+	}
+
+	if handler.GuildConfigs == nil {
+		return &discordgo.MessageSend{Content: configUnavailable}, nil
+	}
+
+	guildConfig, err := handler.GuildConfigs.Get(invocation.GuildID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to look up guild config: %w", err)
+	}
+
+	switch invocation.Argument(configActionArgument) {
+	case ConfigActionSet:
+		return handler.setGuildConfig(invocation, guildConfig)
+	case ConfigActionReset:
+		return handler.resetGuildConfig(invocation, guildConfig)
+	default:
+		return &discordgo.MessageSend{Embed: handler.configMessage(guildConfig)}, nil
+	}
+}
+
+// setGuildConfig validates and stores the setting of the provided
+// invocation.
+func (handler *Handler) setGuildConfig(
+	invocation *CommandInvocation,
+	guildConfig *config.GuildConfig,
+) (*discordgo.MessageSend, error) {
+	previous := guildConfig.Resolve(handler.defaultSettings())
+	setting := invocation.Argument(configSettingArgument)
+
+	if setting == "" {
+		return invalidConfigArguments(invocation, previous, fmt.Sprintf(configMissingSetting, ConfigActionSet)), nil
+	}
+
+	value, found := invocation.Arguments[configValueArgument]
+	if !found {
+		return invalidConfigArguments(invocation, previous, configMissingValue), nil
+	}
+
+	err := setGuildConfigValue(guildConfig, setting, value)
+	if err != nil {
+		return invalidConfigArguments(invocation, previous, err.Error()), nil
+	}
+
+	settings := guildConfig.Resolve(handler.defaultSettings())
+
+	err = handler.checkRolePrefix(invocation.Session, invocation.GuildID, previous, settings)
+	if err != nil {
+		return invalidConfigArguments(invocation, previous, err.Error()), nil
+	}
+
+	err = handler.GuildConfigs.Put(guildConfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to store guild config: %w", err)
+	}
+
+	content := fmt.Sprintf(configSetMessage, setting, formatConfigSetting(settings, setting))
+
+	return handler.guildConfigChanged(invocation, previous, settings, content), nil
+}
+
+// resetGuildConfig resets the setting of the provided invocation, or every
+// setting if none is provided, to the defaults.
+func (handler *Handler) resetGuildConfig(
+	invocation *CommandInvocation,
+	guildConfig *config.GuildConfig,
+) (*discordgo.MessageSend, error) {
+	previous := guildConfig.Resolve(handler.defaultSettings())
+	setting := invocation.Argument(configSettingArgument)
+
+	if setting == "" {
+		err := handler.checkRolePrefix(invocation.Session, invocation.GuildID, previous, handler.defaultSettings())
+		if err != nil {
+			return invalidConfigArguments(invocation, previous, err.Error()), nil
+		}
+
+		err = handler.GuildConfigs.Delete(invocation.GuildID)
+		if err != nil {
+			return nil, fmt.Errorf("unable to reset guild config: %w", err)
+		}
+
+		return handler.guildConfigChanged(invocation, previous, handler.defaultSettings(), configResetAllMessage), nil
+	}
+
+	clearGuildConfigValue(guildConfig, setting)
+
+	settings := guildConfig.Resolve(handler.defaultSettings())
+
+	err := handler.checkRolePrefix(invocation.Session, invocation.GuildID, previous, settings)
+	if err != nil {
+		return invalidConfigArguments(invocation, previous, err.Error()), nil
+	}
+
+	err = handler.GuildConfigs.Put(guildConfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to reset guild config: %w", err)
+	}
+
+	content := fmt.Sprintf(configResetMessage, setting, formatConfigSetting(settings, setting))
+
+	return handler.guildConfigChanged(invocation, previous, settings, content), nil
+}
+
+// guildConfigChanged logs a change to the guild config of the provided
+// invocation and updates the guild's ephemeral roles to match, returning the
+// response describing the change.
+func (handler *Handler) guildConfigChanged(
+	invocation *CommandInvocation,
+	previous, settings *config.Settings,
+	content string,
+) *discordgo.MessageSend {
+	handler.Log.WithFields(logrus.Fields{
+		"guild":               invocation.GuildID,
+		"user":                invocation.UserID,
+		configActionArgument:  invocation.Argument(configActionArgument),
+		configSettingArgument: invocation.Argument(configSettingArgument),
+	}).Info(guildConfigChanged)
+
+	lines := []string{content}
+
+	updated, err := handler.updateEphemeralRoles(invocation.Session, invocation.GuildID, previous, settings)
+	if err != nil {
+		handler.Log.WithField("guild", invocation.GuildID).WithError(err).Warn(guildConfigRolesError)
+	}
+
+	if updated > 0 {
+		lines = append(lines, fmt.Sprintf(configRolesMessage, updated))
+	}
+
+	if err != nil {
+		lines = append(lines, fmt.Sprintf(configRolesErrorMessage, err, settings.BotKeyword, DiagnoseCommand))
+	}
+
+	if previous.BotKeyword != settings.BotKeyword {
+		lines = append(lines, fmt.Sprintf(configKeywordMessage, settings.BotKeyword))
+	}
+
+	return &discordgo.MessageSend{Content: strings.Join(lines, "\n")}
+}
+
+// updateEphemeralRoles renames, recolors and sets the display options of the
+// ephemeral roles of the guild associated with the provided guildID when the
+// role settings change from previous to settings. It returns the number of
+// roles updated.
+func (handler *Handler) updateEphemeralRoles(
+	session *discordgo.Session,
+	guildID string,
+	previous, settings *config.Settings,
+) (int, error) {
+	if previous.RolePrefix == settings.RolePrefix &&
+		previous.RoleColor == settings.RoleColor &&
+		previous.RoleHoist == settings.RoleHoist &&
+		previous.RoleMentionable == settings.RoleMentionable {
+		return 0, nil
+	}
+
+	guild, err := operations.LookupGuild(session, guildID)
+	if err != nil {
+		return 0, fmt.Errorf("unable to look up guild: %w", err)
+	}
+
+	diagnosis, err := operations.AnalyzePermissions(session, guild, previous.RolePrefix)
+	if err != nil {
+		return 0, fmt.Errorf("unable to analyze permissions: %w", err)
+	}
+
+	if !diagnosis.ManageRoles {
+		return 0, diagnosis.Err()
+	}
+
+	requests := handler.ephemeralRoleEdits(session, guild, previous, settings)
+
+	ctx, cancel := handler.contextWithTimeout(context.Background())
+	defer cancel()
+
+	futures := make([]*operations.RoleFuture, 0, len(requests))
+
+	for _, request := range requests {
+		if !diagnosis.CanManage(request.Role.ID) {
+			continue
+		}
+
+		futures = append(futures, handler.OperationsGateway.EditRole(request))
+	}
+
+	var (
+		updated int
+		editErr error
+	)
+
+	for _, future := range futures {
+		_, err = future.Wait(ctx)
+		if err != nil {
+			if editErr == nil {
+				editErr = err
+			}
+
+			continue
+		}
+
+		updated++
+	}
+
+	switch {
+	case len(futures) < len(requests):
+		return updated, fmt.Errorf("unable to update %d of %d roles: %w", len(requests)-updated, len(requests), diagnosis.Err())
+	case editErr != nil:
+		return updated, fmt.Errorf("unable to update %d of %d roles: %w", len(requests)-updated, len(requests), editErr)
+	}
+
+	return updated, nil
+}
+
+// checkRolePrefix returns an error if the role settings change from previous
+// to settings to a role prefix which roles other than the ephemeral roles of
+// the guild associated with the provided guildID begin with. Ephemeral roles
+// are identified by their prefix, so those roles would be treated as
+// ephemeral roles and removed from their members.
+func (handler *Handler) checkRolePrefix(session *discordgo.Session, guildID string, previous, settings *config.Settings) error {
+	if previous.RolePrefix == settings.RolePrefix {
+		return nil
+	}
+
+	guild, err := operations.LookupGuild(session, guildID)
+	if err != nil {
+		return fmt.Errorf("unable to look up guild: %w", err)
+	}
+
+	ephemeralRoles := make(map[string]bool)
+
+	for _, roleID := range handler.RoleMap.Channels(guildID) {
+		ephemeralRoles[roleID] = true
+	}
+
+	session.State.RLock()
+	defer session.State.RUnlock()
+
+	conflicts := make([]string, 0)
+
+	for _, role := range guild.Roles {
+		if role.ID != guild.ID && !ephemeralRoles[role.ID] && strings.HasPrefix(role.Name, settings.RolePrefix) {
+			conflicts = append(conflicts, fmt.Sprintf("`%s`", role.Name))
+		}
+	}
+
+	if len(conflicts) == 0 {
+		return nil
+	}
+
+	sort.Strings(conflicts)
+
+	return fmt.Errorf(configPrefixConflict, ConfigSettingPrefix, settings.RolePrefix, strings.Join(conflicts, ", "))
+}
+
+// ephemeralRoleEdits returns the requests to edit the ephemeral roles of the
+// provided guild which do not match settings. Only the roles mapped to the
+// guild's channels are edited, and they are named after their channel.
+func (handler *Handler) ephemeralRoleEdits(
+	session *discordgo.Session,
+	guild *discordgo.Guild,
+	previous, settings *config.Settings,
+) []*operations.EditRoleRequest {
+	roleChannels := make(map[string]string)
+
+	for channelID, roleID := range handler.RoleMap.Channels(guild.ID) {
+		roleChannels[roleID] = channelID
+	}
+
+	session.State.RLock()
+	defer session.State.RUnlock()
+
+	channelNames := make(map[string]string, len(guild.Channels))
+
+	for _, channel := range guild.Channels {
+		channelNames[channel.ID] = channel.Name
+	}
+
+	var requests []*operations.EditRoleRequest
+
+	for _, role := range guild.Roles {
+		channelID, found := roleChannels[role.ID]
+		if !found {
+			continue
+		}
+
+		roleName := settings.RolePrefix + strings.TrimPrefix(role.Name, previous.RolePrefix)
+
+		if channelName, found := channelNames[channelID]; found {
+			roleName = settings.RoleName(channelName)
+		}
+
+		if roleMatchesSettings(role, roleName, settings) {
+			continue
+		}
+
+		roleCopy := *role
+
+		requests = append(requests, &operations.EditRoleRequest{
+			Guild:           guild,
+			Role:            &roleCopy,
+			RoleName:        roleName,
+			RoleColor:       settings.RoleColor,
+			RoleHoist:       settings.RoleHoist,
+			RoleMentionable: settings.RoleMentionable,
+		})
+	}
+
+	return requests
+}
+
+// configMessage returns an embed describing the settings of the provided
+// guild config, noting which are the defaults.
+func (handler *Handler) configMessage(guildConfig *config.GuildConfig) *discordgo.MessageEmbed {
+	settings := guildConfig.Resolve(handler.defaultSettings())
+
+	embed := &discordgo.MessageEmbed{
+		Title: settings.BotName + " settings",
+		Color: configMessageColor,
+		Description: fmt.Sprintf(
+			"Change them with `%[1]s %[2]s %[3]s <setting> <value>`, or reset them with `%[1]s %[2]s %[4]s [setting]`.",
+			settings.BotKeyword, ConfigCommand, ConfigActionSet, ConfigActionReset,
+		),
+	}
+
+	for _, setting := range configSettingNames() {
+		value := formatConfigSetting(settings, setting)
+
+		if !configSettingCustomized(guildConfig, setting) {
+			value += " (default)"
+		}
+
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:   setting,
+			Value:  value,
+			Inline: true,
+		})
+	}
+
+	return embed
+}
+
+func invalidConfigArguments(invocation *CommandInvocation, settings *config.Settings, reason string) *discordgo.MessageSend {
+	invalidArgs := &InvalidArguments{Command: invocation.Command, Reason: reason}
+
+	return &discordgo.MessageSend{
+		Content: fmt.Sprintf(invalidArgumentsMessage, invalidArgs, invocation.Command.Usage(settings.BotKeyword)),
+	}
+}
+
+func configSettingNames() []string {
+	return []string{
+		ConfigSettingPrefix,
+		ConfigSettingColor,
+		ConfigSettingHoist,
+		ConfigSettingMentionable,
+		ConfigSettingMode,
+		ConfigSettingKeyword,
+		ConfigSettingInclude,
+		ConfigSettingExclude,
+	}
+}
+
+// setGuildConfigValue validates the provided value and sets the setting of
+// the provided guild config to it.
+func setGuildConfigValue(guildConfig *config.GuildConfig, setting, value string) error {
+	switch setting {
+	case ConfigSettingPrefix:
+		rolePrefix, err := parseRolePrefix(value)
+		if err != nil {
+			return err
+		}
+
+		guildConfig.RolePrefix = config.String(rolePrefix)
+	case ConfigSettingColor:
+		roleColor, err := parseRoleColor(value)
+		if err != nil {
+			return err
+		}
+
+		guildConfig.RoleColor = config.Int(roleColor)
+	case ConfigSettingHoist:
+		roleHoist, err := parseToggle(setting, value)
+		if err != nil {
+			return err
+		}
+
+		guildConfig.RoleHoist = config.Bool(roleHoist)
+	case ConfigSettingMentionable:
+		roleMentionable, err := parseToggle(setting, value)
+		if err != nil {
+			return err
+		}
+
+		guildConfig.RoleMentionable = config.Bool(roleMentionable)
+	case ConfigSettingMode:
+		roleMode, err := parseRoleMode(value)
+		if err != nil {
+			return err
+		}
+
+		guildConfig.RoleMode = config.String(roleMode)
+	case ConfigSettingKeyword:
+		botKeyword, err := parseBotKeyword(value)
+		if err != nil {
+			return err
+		}
+
+		guildConfig.BotKeyword = config.String(botKeyword)
+	case ConfigSettingInclude:
+		rules, err := config.ParseChannelRules(value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", setting, err)
+		}
+
+		guildConfig.IncludeChannels = rules
+	case ConfigSettingExclude:
+		rules, err := config.ParseChannelRules(value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", setting, err)
+		}
+
+		guildConfig.ExcludeChannels = rules
+	default:
+		return fmt.Errorf("unknown setting %q", setting)
+	}
+
+	return nil
+}
+
+func clearGuildConfigValue(guildConfig *config.GuildConfig, setting string) {
+	switch setting {
+	case ConfigSettingPrefix:
+		guildConfig.RolePrefix = nil
+	case ConfigSettingColor:
+		guildConfig.RoleColor = nil
+	case ConfigSettingHoist:
+		guildConfig.RoleHoist = nil
+	case ConfigSettingMentionable:
+		guildConfig.RoleMentionable = nil
+	case ConfigSettingMode:
+		guildConfig.RoleMode = nil
+	case ConfigSettingKeyword:
+		guildConfig.BotKeyword = nil
+	case ConfigSettingInclude:
+		guildConfig.IncludeChannels = nil
+	case ConfigSettingExclude:
+		guildConfig.ExcludeChannels = nil
+	}
+}
+
+func configSettingCustomized(guildConfig *config.GuildConfig, setting string) bool {
+	switch setting {
+	case ConfigSettingPrefix:
+		return guildConfig.RolePrefix != nil
+	case ConfigSettingColor:
+		return guildConfig.RoleColor != nil
+	case ConfigSettingHoist:
+		return guildConfig.RoleHoist != nil
+	case ConfigSettingMentionable:
+		return guildConfig.RoleMentionable != nil
+	case ConfigSettingMode:
+		return guildConfig.RoleMode != nil
+	case ConfigSettingKeyword:
+		return guildConfig.BotKeyword != nil
+	case ConfigSettingInclude:
+		return guildConfig.IncludeChannels != nil
+	case ConfigSettingExclude:
+		return guildConfig.ExcludeChannels != nil
+	default:
+		return false
+	}
+}
+
+func formatConfigSetting(settings *config.Settings, setting string) string {
+	switch setting {
+	case ConfigSettingPrefix:
+		return fmt.Sprintf("`%s`", settings.RolePrefix)
+	case ConfigSettingColor:
+		return fmt.Sprintf("#%06x", settings.RoleColor)
+	case ConfigSettingHoist:
+		return formatToggle(settings.RoleHoist)
+	case ConfigSettingMentionable:
+		return formatToggle(settings.RoleMentionable)
+	case ConfigSettingMode:
+		return settings.RoleMode
+	case ConfigSettingKeyword:
+		return fmt.Sprintf("`%s`", settings.BotKeyword)
+	case ConfigSettingInclude:
+		return formatChannelRules(settings.IncludeChannels)
+	case ConfigSettingExclude:
+		return formatChannelRules(settings.ExcludeChannels)
+	default:
+		return ""
+	}
+}
+
+func formatChannelRules(rules []config.ChannelRule) string {
+	if len(rules) == 0 {
+		return "none"
+	}
+
+	return fmt.Sprintf("`%s`", config.FormatChannelRules(rules))
+}
+
+func formatToggle(enabled bool) string {
+	if enabled {
+		return "on"
+	}
+
+	return "off"
+}
+
+// parseRolePrefix returns the provided role prefix without surrounding
+// whitespace, as role names separate it from the channel name with a space.
+func parseRolePrefix(value string) (string, error) {
+	rolePrefix := strings.TrimSpace(value)
+
+	err := config.ValidateRolePrefix(rolePrefix)
+	if err != nil {
+		return "", fmt.Errorf("%s %w", ConfigSettingPrefix, err)
+	}
+
+	return rolePrefix, nil
+}
+
+// parseRoleColor returns the role color of the provided hex value, such as
+// #ffa500, 0xffa500 or ffa500.
+func parseRoleColor(value string) (int, error) {
+	hex := strings.ToLower(strings.TrimSpace(value))
+	hex = strings.TrimPrefix(hex, "#")
+	hex = strings.TrimPrefix(hex, "0x")
+
+	roleColor, err := strconv.ParseUint(hex, 16, 24)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a hex value such as #ffa500, got %q", ConfigSettingColor, value)
+	}
+
+	return int(roleColor), nil
+}
+
+func parseToggle(setting, value string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "on", "true", "yes", "enable", "enabled":
+		return true, nil
+	case "off", "false", "no", "disable", "disabled":
+		return false, nil
+	default:
+		return false, fmt.Errorf("%s must be on or off, got %q", setting, value)
+	}
+}
+
+// parseRoleMode returns the provided role mode, which must be one of
+// config.RoleModes.
+func parseRoleMode(value string) (string, error) {
+	roleMode := strings.ToLower(strings.TrimSpace(value))
+
+	err := config.ValidateRoleMode(roleMode)
+	if err != nil {
+		return "", fmt.Errorf("%s %w", ConfigSettingMode, err)
+	}
+
+	return roleMode, nil
+}
+
+// parseBotKeyword returns the provided bot keyword, which must be a single
+// word since messages are matched to it by their first word.
+func parseBotKeyword(value string) (string, error) {
+	err := config.ValidateBotKeyword(value)
+	if err != nil {
+		return "", fmt.Errorf("%s %w", ConfigSettingKeyword, err)
+	}
+
+	return value, nil
+}
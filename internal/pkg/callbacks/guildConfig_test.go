@@ -0,0 +1,257 @@
+package callbacks_test
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ewohltman/discordgo-mock/mockconstants"
+
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/callbacks"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/config"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/mock"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/monitor"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/operations"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/rolemap"
+)
+
+const testEphemeralRole = rolePrefix + " " + mockconstants.TestChannel
+
+func TestHandler_MessageCreate_config(t *testing.T) {
+	session, err := mock.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := &messageRecorder{next: session.Client.Transport}
+	session.Client.Transport = recorder
+
+	log := mock.NewLogger()
+	store := config.NewMemoryStore()
+
+	handler := &callbacks.Handler{
+		Log:                  log,
+		BotName:              "testBot",
+		BotKeyword:           "testKeyword",
+		RolePrefix:           rolePrefix,
+		RoleColor:            0xffa500,
+		ContextTimeout:       time.Second,
+		MessageCreateCounter: monitor.MessageCreateCounter(&monitor.Config{Log: log}),
+		OperationsGateway:    operations.NewGateway(session),
+		RoleMap:              rolemap.New(),
+		Commands:             callbacks.NewCommandRegistry(),
+		GuildConfigs:         store,
+	}
+
+	sendConfig := func(arguments string) {
+		botKeyword := handler.GuildSettings(mockconstants.TestGuild).BotKeyword
+		sendMessage(session, handler, fmt.Sprintf("%s %s %s", botKeyword, callbacks.ConfigCommand, arguments))
+	}
+
+	// Members without Manage Server are denied
+	sendConfig(`set prefix "[voice]"`)
+
+	if !reflect.DeepEqual(guildConfig(t, store), &config.GuildConfig{GuildID: mockconstants.TestGuild}) {
+		t.Fatal("Expected config change to be denied")
+	}
+
+	grantManageServer(t, session)
+
+	sendConfig(callbacks.ConfigActionShow)
+
+	settingsEmbed := recorder.lastEmbed(t)
+	if len(settingsEmbed.Fields) != 8 {
+		t.Fatalf("Unexpected number of settings: %d", len(settingsEmbed.Fields))
+	}
+
+	for _, field := range settingsEmbed.Fields {
+		if !strings.HasSuffix(field.Value, "(default)") {
+			t.Errorf("Expected %s to be the default, got: %s", field.Name, field.Value)
+		}
+	}
+
+	invalidTestCases := []struct {
+		arguments string
+		expected  string
+	}{
+		{arguments: "set", expected: "requires a setting"},
+		{arguments: "set color", expected: "requires a value"},
+		{arguments: "set volume 11", expected: "setting must be one of"},
+		{arguments: `set prefix "  "`, expected: "must not be empty"},
+		{arguments: "set prefix " + strings.Repeat("x", 33), expected: "at most 32 characters"},
+		{arguments: "set color orange", expected: "hex value"},
+		{arguments: "set color #1000000", expected: "hex value"},
+		{arguments: "set hoist maybe", expected: "on or off"},
+		{arguments: "set mode server", expected: "mode must be one of channel, category, both"},
+		{arguments: `set keyword "two words"`, expected: "must not contain spaces"},
+		{arguments: `set exclude "channel:1,,channel:2"`, expected: "empty channel rule"},
+		{arguments: "set include name:[voice", expected: "invalid name pattern"},
+	}
+
+	for _, testCase := range invalidTestCases {
+		sendConfig(testCase.arguments)
+
+		content := recorder.lastMessage(t).Content
+		if !strings.Contains(content, testCase.expected) || !strings.Contains(content, "Usage:") {
+			t.Errorf("Expected response to %q to contain %q, got: %q", testCase.arguments, testCase.expected, content)
+		}
+	}
+
+	// Prefixes matching roles which are not ephemeral roles are rejected, as
+	// those roles would be treated as ephemeral roles
+	err = session.State.RoleAdd(mockconstants.TestGuild, &discordgo.Role{ID: "moderatorRole", Name: "Moderators"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sendConfig("set prefix Mod")
+
+	if content := recorder.lastMessage(t).Content; !strings.Contains(content, "matches roles which are not ephemeral roles: `Moderators`") {
+		t.Errorf("Unexpected response to conflicting prefix: %q", content)
+	}
+
+	if !reflect.DeepEqual(guildConfig(t, store), &config.GuildConfig{GuildID: mockconstants.TestGuild}) {
+		t.Fatal("Expected invalid config changes not to be stored")
+	}
+
+	handler.RoleMap.Set(mockconstants.TestGuild, mockconstants.TestChannel, testEphemeralRole)
+
+	// Roles beginning with the prefix are only renamed if they are mapped to
+	// a channel
+	err = session.State.RoleAdd(mockconstants.TestGuild, &discordgo.Role{ID: "unmappedRole", Name: "{eph} unmapped"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Changing the prefix renames the existing ephemeral roles
+	sendConfig(`set prefix " [voice] "`)
+
+	content := recorder.lastMessage(t).Content
+	if !strings.Contains(content, "Set prefix to `[voice]`.") || !strings.Contains(content, "Ephemeral roles updated: 1.") {
+		t.Errorf("Unexpected response: %q", content)
+	}
+
+	role := stateRole(t, session, testEphemeralRole)
+	if role.Name != "[voice] "+mockconstants.TestChannel || role.Color != handler.RoleColor || !role.Hoist || !role.Mentionable {
+		t.Errorf("Unexpected ephemeral role after prefix change: %+v", role)
+	}
+
+	if role = stateRole(t, session, "unmappedRole"); role.Name != "{eph} unmapped" {
+		t.Errorf("Unexpected unmapped role after prefix change: %+v", role)
+	}
+
+	err = session.State.RoleRemove(mockconstants.TestGuild, "unmappedRole")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sendConfig("set color #123456")
+	sendConfig("set hoist off")
+	sendConfig("set mentionable no")
+
+	role = stateRole(t, session, testEphemeralRole)
+	if role.Color != 0x123456 || role.Hoist || role.Mentionable {
+		t.Errorf("Unexpected ephemeral role after display changes: %+v", role)
+	}
+
+	sendConfig("set mode Category")
+
+	if stored := guildConfig(t, store); stored.RoleMode == nil || *stored.RoleMode != config.RoleModeCategory {
+		t.Errorf("Unexpected role mode: %+v", stored.RoleMode)
+	}
+
+	sendConfig("set keyword !voice")
+
+	if content = recorder.lastMessage(t).Content; !strings.Contains(content, "Commands now start with `!voice`.") {
+		t.Errorf("Unexpected response: %q", content)
+	}
+
+	sendConfig(`set exclude "<#afk>, category:staff, name:music*"`)
+
+	expectedRules := []config.ChannelRule{{ChannelID: "afk"}, {CategoryID: "staff"}, {Pattern: "music*"}}
+	if stored := guildConfig(t, store); !reflect.DeepEqual(stored.ExcludeChannels, expectedRules) {
+		t.Errorf("Unexpected exclude rules: %+v", stored.ExcludeChannels)
+	}
+
+	if content = recorder.lastMessage(t).Content; !strings.Contains(content, "`channel:afk, category:staff, name:music*`") {
+		t.Errorf("Unexpected response: %q", content)
+	}
+
+	sendConfig("reset exclude")
+	sendConfig("reset hoist")
+
+	stored := guildConfig(t, store)
+	if stored.RoleHoist != nil || stored.RoleMentionable == nil || !stateRole(t, session, testEphemeralRole).Hoist {
+		t.Errorf("Unexpected guild config after resetting hoist: %+v", stored)
+	}
+
+	sendConfig(callbacks.ConfigActionShow)
+
+	defaultSettings := map[string]bool{
+		callbacks.ConfigSettingHoist:   true,
+		callbacks.ConfigSettingInclude: true,
+		callbacks.ConfigSettingExclude: true,
+	}
+
+	for _, field := range recorder.lastEmbed(t).Fields {
+		isDefault := strings.HasSuffix(field.Value, "(default)")
+		if isDefault != defaultSettings[field.Name] {
+			t.Errorf("Unexpected %s setting: %s", field.Name, field.Value)
+		}
+	}
+
+	sendConfig(callbacks.ConfigActionReset)
+
+	if content = recorder.lastMessage(t).Content; !strings.Contains(content, "Reset all settings") {
+		t.Errorf("Unexpected response: %q", content)
+	}
+
+	role = stateRole(t, session, testEphemeralRole)
+	if role.Name != testEphemeralRole || role.Color != handler.RoleColor || !role.Mentionable {
+		t.Errorf("Unexpected ephemeral role after reset: %+v", role)
+	}
+
+	if handler.GuildSettings(mockconstants.TestGuild).BotKeyword != handler.BotKeyword {
+		t.Error("Expected keyword to be reset")
+	}
+}
+
+func guildConfig(t *testing.T, store config.GuildConfigStore) *config.GuildConfig {
+	t.Helper()
+
+	stored, err := store.Get(mockconstants.TestGuild)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return stored
+}
+
+func stateRole(t *testing.T, session *discordgo.Session, roleID string) *discordgo.Role {
+	t.Helper()
+
+	role, err := session.State.Role(mockconstants.TestGuild, roleID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roleCopy := *role
+
+	return &roleCopy
+}
+
+// grantManageServer grants the Manage Server permission to the test user.
+func grantManageServer(t *testing.T, session *discordgo.Session) {
+	t.Helper()
+
+	role := stateRole(t, session, mockconstants.TestRole)
+	role.Permissions |= discordgo.PermissionManageServer
+
+	err := session.State.RoleAdd(mockconstants.TestGuild, role)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
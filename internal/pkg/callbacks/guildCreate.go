@@ -0,0 +1,21 @@
+package callbacks
+
+import (
+	"context"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// GuildCreate is the callback function for the GuildCreate event from Discord.
+func (handler *Handler) GuildCreate(session *discordgo.Session, guild *discordgo.GuildCreate) {
+	if guild.Unavailable {
+		return
+	}
+
+	handler.rebuildRoleMap(session, guild.Guild)
+
+	ctx, cancel := handler.contextWithTimeout(context.Background())
+	defer cancel()
+
+	handler.reconcileGuild(ctx, session, guild.Guild)
+}
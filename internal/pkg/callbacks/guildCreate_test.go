@@ -0,0 +1,124 @@
+package callbacks_test
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ewohltman/discordgo-mock/mockconstants"
+
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/callbacks"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/capacity"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/mock"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/monitor"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/operations"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/rolemap"
+)
+
+func TestHandler_GuildCreate(t *testing.T) {
+	session, err := mock.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log := mock.NewLogger()
+
+	handler := &callbacks.Handler{
+		Log:                     log,
+		BotName:                 "testBot",
+		BotKeyword:              "testKeyword",
+		RolePrefix:              "{eph}",
+		ReconcileAddedCounter:   monitor.ReconcileAddedCounter(&monitor.Config{Log: log}),
+		ReconcileRemovedCounter: monitor.ReconcileRemovedCounter(&monitor.Config{Log: log}),
+		OperationsGateway:       operations.NewGateway(session),
+		RoleMap:                 rolemap.New(),
+		Capacity:                capacity.NewManager(),
+	}
+
+	guild, err := session.State.Guild(mockconstants.TestGuild)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler.GuildCreate(session, &discordgo.GuildCreate{Guild: guild})
+
+	expectedRoleID := handler.RoleNameFromChannel(mockconstants.TestGuild, mockconstants.TestChannel)
+
+	roleID, found := handler.RoleMap.RoleID(mockconstants.TestGuild, mockconstants.TestChannel)
+	if !found || roleID != expectedRoleID {
+		t.Fatalf("unexpected role ID for channel %s: %q", mockconstants.TestChannel, roleID)
+	}
+
+	_, found = handler.RoleMap.RoleID(mockconstants.TestGuild, mockconstants.TestChannel2)
+	if found {
+		t.Errorf("unexpected role ID for channel %s", mockconstants.TestChannel2)
+	}
+
+	channel, err := session.State.Channel(mockconstants.TestChannel)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	renamedChannel := *channel
+	renamedChannel.Name = "renamed"
+
+	handler.ChannelDelete(session, &discordgo.ChannelDelete{Channel: &renamedChannel})
+
+	_, err = session.State.Role(mockconstants.TestGuild, expectedRoleID)
+	if err == nil {
+		t.Errorf("ephemeral role remains for renamed channel %s", mockconstants.TestChannel)
+	}
+}
+
+func TestHandler_GuildCreate_persistedRoleMap(t *testing.T) {
+	session, err := mock.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+
+	previousRoleMap, err := rolemap.Load(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The role named after the first channel was created for the second one,
+	// e.g. before the channels were renamed
+	previousRoleMap.Set(mockconstants.TestGuild, mockconstants.TestChannel2, testEphemeralRole)
+
+	roleMap, err := rolemap.Load(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log := mock.NewLogger()
+
+	handler := &callbacks.Handler{
+		Log:                     log,
+		BotName:                 "testBot",
+		BotKeyword:              "testKeyword",
+		RolePrefix:              "{eph}",
+		ReconcileAddedCounter:   monitor.ReconcileAddedCounter(&monitor.Config{Log: log}),
+		ReconcileRemovedCounter: monitor.ReconcileRemovedCounter(&monitor.Config{Log: log}),
+		OperationsGateway:       operations.NewGateway(session),
+		RoleMap:                 roleMap,
+		Capacity:                capacity.NewManager(),
+	}
+
+	guild, err := session.State.Guild(mockconstants.TestGuild)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler.GuildCreate(session, &discordgo.GuildCreate{Guild: guild})
+
+	roleID, _ := handler.RoleMap.RoleID(mockconstants.TestGuild, mockconstants.TestChannel2)
+	if roleID != testEphemeralRole {
+		t.Errorf("Persisted mapping for channel %s not kept, got: %q", mockconstants.TestChannel2, roleID)
+	}
+
+	roleID, _ = handler.RoleMap.RoleID(mockconstants.TestGuild, mockconstants.TestChannel)
+	if roleID == testEphemeralRole {
+		t.Errorf("Role mapped to channel %s claimed by name for channel %s", mockconstants.TestChannel2, mockconstants.TestChannel)
+	}
+}
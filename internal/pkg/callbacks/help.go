@@ -0,0 +1,97 @@
+package callbacks
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/config"
+)
+
+const botOwnersOnly = "Bot owners only."
+
+func (handler *Handler) runHelp(invocation *CommandInvocation) (*discordgo.MessageSend, error) {
+	return &discordgo.MessageSend{Embed: handler.helpMessage(handler.GuildSettings(invocation.GuildID))}, nil
+}
+
+// helpMessage returns an embed describing each registered command, its
+// aliases and its arguments.
+func (handler *Handler) helpMessage(settings *config.Settings) *discordgo.MessageEmbed {
+	commands := handler.commandRegistry().Commands()
+
+	embed := &discordgo.MessageEmbed{
+		Title: settings.BotName + " commands",
+		Color: helpMessageColor,
+		Description: fmt.Sprintf(
+			"Send `%s <command> [arguments]`. Quote arguments containing spaces.",
+			settings.BotKeyword,
+		),
+		Fields: make([]*discordgo.MessageEmbedField, len(commands)),
+	}
+
+	for i, command := range commands {
+		embed.Fields[i] = &discordgo.MessageEmbedField{
+			Name:  fmt.Sprintf("`%s`", command.Usage(settings.BotKeyword)),
+			Value: commandHelp(command),
+		}
+	}
+
+	return embed
+}
+
+func commandHelp(command *Command) string {
+	lines := []string{command.Description}
+
+	for _, argument := range command.Arguments {
+		line := fmt.Sprintf("`%s`: %s", argument.Name, argument.Description)
+
+		if len(argument.Choices) > 0 {
+			line += fmt.Sprintf(" One of: %s.", strings.Join(argument.Choices, ", "))
+		}
+
+		lines = append(lines, line)
+	}
+
+	if len(command.Aliases) > 0 {
+		lines = append(lines, "Aliases: "+strings.Join(command.Aliases, ", "))
+	}
+
+	switch {
+	case command.Requirement.BotOwner:
+		lines = append(lines, botOwnersOnly)
+	case command.Requirement.Permissions != 0:
+		lines = append(lines, "Requires: "+strings.Join(permissionNames(command.Requirement.Permissions), ", "))
+	}
+
+	return fieldValue("", lines, "")
+}
+
+// permissionNames returns the names of the permissions set in the provided
+// permission bits that commands are expected to require.
+func permissionNames(permissions int64) []string {
+	known := []struct {
+		permission int64
+		name       string
+	}{
+		{discordgo.PermissionAdministrator, "Administrator"},
+		{discordgo.PermissionManageServer, "Manage Server"},
+		{discordgo.PermissionManageRoles, "Manage Roles"},
+		{discordgo.PermissionManageChannels, "Manage Channels"},
+	}
+
+	var names []string
+
+	for _, knownPermission := range known {
+		if permissions&knownPermission.permission == knownPermission.permission {
+			names = append(names, knownPermission.name)
+			permissions &^= knownPermission.permission
+		}
+	}
+
+	if permissions != 0 {
+		names = append(names, fmt.Sprintf("permissions %d", permissions))
+	}
+
+	return names
+}
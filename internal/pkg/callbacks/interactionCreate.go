@@ -0,0 +1,285 @@
+package callbacks
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	interactionCreate           = "InteractionCreate"
+	interactionCreateEventError = "Unable to process event: " + interactionCreate
+
+	// ephemeralResponseFlag makes an interaction response visible only to the
+	// user who invoked it
+	ephemeralResponseFlag = 1 << 6
+
+	// Discord limits application command and option descriptions to 100
+	// characters
+	maxApplicationCommandDescriptionLength = 100
+
+	interactionDoneMessage          = "Done."
+	interactionNotAuthorizedMessage = "You are not allowed to run this command."
+	interactionErrorMessage         = "Something went wrong running this command. Please try again later."
+)
+
+// ApplicationCommand is a Discord application command with the default member
+// permissions discordgo does not support yet. Members without the default
+// member permissions do not see the command unless a guild overrides them.
+type ApplicationCommand struct {
+	*discordgo.ApplicationCommand
+	DefaultMemberPermissions *string `json:"default_member_permissions,omitempty"`
+}
+
+// Interactions configures registering the handler's commands as Discord
+// application commands, also known as slash commands. A nil *Interactions
+// registers nothing.
+type Interactions struct {
+	// Global registers the commands globally. Global commands can take up
+	// to an hour to become available.
+	Global bool
+
+	// GuildIDs are guilds to register the commands in directly. Guild
+	// commands are available immediately, which is useful for testing.
+	GuildIDs []string
+}
+
+// InteractionCreate is the callback function for the InteractionCreate event
+// from Discord. It runs application commands with the same implementations
+// as commands sent with the bot keyword, responding ephemerally. Commands may
+// take longer than Discord waits for a response, so the response is deferred
+// before the command runs and its result sent as a follow-up message.
+func (handler *Handler) InteractionCreate(session *discordgo.Session, interaction *discordgo.InteractionCreate) {
+	handler.InteractionCreateCounter.Inc()
+
+	if interaction.Type != discordgo.InteractionApplicationCommand {
+		return
+	}
+
+	err := session.InteractionRespond(interaction.Interaction, deferredResponse())
+	if err != nil {
+		handler.Log.WithError(fmt.Errorf("error responding to interaction: %w", err)).Error(interactionCreateEventError)
+		return
+	}
+
+	response, err := handler.parseInteraction(session, interaction.Interaction)
+	if err != nil {
+		handler.logCommandError(err, interactionCreateEventError)
+		response = interactionErrorResponse(err)
+	}
+
+	applicationID := session.State.User.ID // A bot's application ID is its user ID
+
+	_, err = session.FollowupMessageCreate(applicationID, interaction.Interaction, false, followupMessage(response))
+	if err != nil {
+		handler.Log.WithError(fmt.Errorf("error sending interaction follow-up: %w", err)).Error(interactionCreateEventError)
+	}
+}
+
+// RegisterApplicationCommands registers the handler's commands as Discord
+// application commands, replacing any previously registered. Global commands
+// are only registered by the first shard, and guild commands only by the
+// shard the guild belongs to.
+func (handler *Handler) RegisterApplicationCommands(session *discordgo.Session, guilds []*discordgo.Guild) error {
+	if handler.Interactions == nil {
+		return nil
+	}
+
+	applicationID := session.State.User.ID // A bot's application ID is its user ID
+	applicationCommands := handler.applicationCommands()
+
+	if handler.Interactions.Global && session.ShardID == 0 {
+		err := applicationCommandBulkOverwrite(session, applicationID, "", applicationCommands)
+		if err != nil {
+			return fmt.Errorf("unable to register global application commands: %w", err)
+		}
+	}
+
+	shardGuilds := make(map[string]bool, len(guilds))
+
+	for _, guild := range guilds {
+		shardGuilds[guild.ID] = true
+	}
+
+	for _, guildID := range handler.Interactions.GuildIDs {
+		if !shardGuilds[guildID] {
+			continue
+		}
+
+		err := applicationCommandBulkOverwrite(session, applicationID, guildID, applicationCommands)
+		if err != nil {
+			return fmt.Errorf("unable to register application commands in guild %s: %w", guildID, err)
+		}
+	}
+
+	return nil
+}
+
+func (handler *Handler) parseInteraction(s *discordgo.Session, interaction *discordgo.Interaction) (*discordgo.MessageSend, error) {
+	command, found := handler.commandRegistry().Lookup(interaction.Data.Name)
+	if !found {
+		return nil, fmt.Errorf("unknown application command: %s", interaction.Data.Name)
+	}
+
+	named := make(map[string]string, len(interaction.Data.Options))
+
+	for _, option := range interaction.Data.Options {
+		named[option.Name] = option.StringValue()
+	}
+
+	arguments, err := command.ValidateArguments(named)
+	if err != nil {
+		return &discordgo.MessageSend{Content: err.Error()}, nil
+	}
+
+	return handler.runCommand(&CommandInvocation{
+		Session:   s,
+		Command:   command,
+		UserID:    interactionUserID(interaction),
+		GuildID:   interaction.GuildID,
+		ChannelID: interaction.ChannelID,
+		Arguments: arguments,
+	})
+}
+
+// applicationCommandBulkOverwrite replaces the application commands of the
+// application associated with the provided applicationID in the guild
+// associated with the provided guildID, or its global commands if guildID is
+// empty. It mirrors discordgo's ApplicationCommandBulkOverwrite, which cannot
+// send default member permissions.
+func applicationCommandBulkOverwrite(
+	session *discordgo.Session,
+	applicationID, guildID string,
+	applicationCommands []*ApplicationCommand,
+) error {
+	endpoint := discordgo.EndpointApplicationGlobalCommands(applicationID)
+	if guildID != "" {
+		endpoint = discordgo.EndpointApplicationGuildCommands(applicationID, guildID)
+	}
+
+	_, err := session.RequestWithBucketID(http.MethodPut, endpoint, applicationCommands, endpoint)
+
+	return err
+}
+
+// applicationCommands returns the handler's commands as Discord application
+// commands. Aliases are not registered to keep the command list short.
+// Commands requiring permissions are hidden from members without them.
+func (handler *Handler) applicationCommands() []*ApplicationCommand {
+	commands := handler.commandRegistry().Commands()
+	applicationCommands := make([]*ApplicationCommand, len(commands))
+
+	for i, command := range commands {
+		applicationCommand := &ApplicationCommand{
+			ApplicationCommand: &discordgo.ApplicationCommand{
+				Name:        command.Name,
+				Description: applicationCommandDescription(command.Description, command.Name),
+				Options:     make([]*discordgo.ApplicationCommandOption, len(command.Arguments)),
+			},
+		}
+
+		if command.Requirement.Permissions != 0 {
+			permissions := strconv.FormatInt(command.Requirement.Permissions, 10)
+			applicationCommand.DefaultMemberPermissions = &permissions
+		}
+
+		for j, argument := range command.Arguments {
+			option := &discordgo.ApplicationCommandOption{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        argument.Name,
+				Description: applicationCommandDescription(argument.Description, argument.Name),
+				Required:    argument.Required,
+			}
+
+			for _, choice := range argument.Choices {
+				option.Choices = append(option.Choices, &discordgo.ApplicationCommandOptionChoice{
+					Name:  choice,
+					Value: choice,
+				})
+			}
+
+			applicationCommand.Options[j] = option
+		}
+
+		applicationCommands[i] = applicationCommand
+	}
+
+	return applicationCommands
+}
+
+// applicationCommandDescription returns the provided description within
+// Discord's length limit, or the provided name if the description is empty
+// since Discord requires one.
+func applicationCommandDescription(description, name string) string {
+	if description == "" {
+		return name
+	}
+
+	runes := []rune(description)
+
+	if len(runes) > maxApplicationCommandDescriptionLength {
+		return string(runes[:maxApplicationCommandDescriptionLength-3]) + "..."
+	}
+
+	return description
+}
+
+// interactionUserID returns the ID of the user who invoked the provided
+// interaction. Discord sets Member for interactions in guilds and User for
+// interactions in direct messages.
+func interactionUserID(interaction *discordgo.Interaction) string {
+	if interaction.Member != nil && interaction.Member.User != nil {
+		return interaction.Member.User.ID
+	}
+
+	if interaction.User != nil {
+		return interaction.User.ID
+	}
+
+	return ""
+}
+
+// interactionErrorResponse returns the response for a command that failed
+// with the provided error. Interactions must always be responded to.
+func interactionErrorResponse(err error) *discordgo.MessageSend {
+	var notAuthorized *CommandNotAuthorized
+
+	if errors.As(err, &notAuthorized) {
+		return &discordgo.MessageSend{Content: interactionNotAuthorizedMessage}
+	}
+
+	return &discordgo.MessageSend{Content: interactionErrorMessage}
+}
+
+// deferredResponse returns the interaction response acknowledging a command
+// whose result is sent later as a follow-up message. The follow-up inherits
+// the response's ephemeral flag.
+func deferredResponse() *discordgo.InteractionResponse {
+	return &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionApplicationCommandResponseData{
+			Flags: ephemeralResponseFlag,
+		},
+	}
+}
+
+// followupMessage returns the provided command response as the follow-up
+// message of a deferred interaction response.
+func followupMessage(response *discordgo.MessageSend) *discordgo.WebhookParams {
+	message := &discordgo.WebhookParams{
+		Content: interactionDoneMessage,
+	}
+
+	if response != nil {
+		message.Content = response.Content
+
+		if response.Embed != nil {
+			message.Embeds = []*discordgo.MessageEmbed{response.Embed}
+		}
+	}
+
+	return message
+}
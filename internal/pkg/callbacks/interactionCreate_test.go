@@ -0,0 +1,232 @@
+package callbacks_test
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ewohltman/discordgo-mock/mockconstants"
+
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/callbacks"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/mock"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/monitor"
+)
+
+const testEphemeralFlag = 1 << 6
+
+func TestHandler_Ready_registerApplicationCommands(t *testing.T) {
+	session, err := mock.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	interactions := mock.NewInteractions(session.Client.Transport)
+	session.Client.Transport = interactions
+
+	log := mock.NewLogger()
+
+	handler := &callbacks.Handler{
+		Log:          log,
+		BotName:      "testBot",
+		BotKeyword:   "testKeyword",
+		ReadyCounter: monitor.ReadyCounter(&monitor.Config{Log: log}),
+		Commands:     callbacks.NewCommandRegistry(),
+		Interactions: &callbacks.Interactions{
+			Global:   true,
+			GuildIDs: []string{mockconstants.TestGuild, "otherShardGuild"},
+		},
+	}
+
+	handler.Ready(session, &discordgo.Ready{
+		Guilds: session.State.Guilds,
+	})
+
+	globalCommands := interactions.Commands("")
+	if len(globalCommands) != len(handler.Commands.Commands()) {
+		t.Fatalf("Unexpected number of global application commands: %d", len(globalCommands))
+	}
+
+	if len(interactions.Commands(mockconstants.TestGuild)) != len(globalCommands) {
+		t.Errorf("Unexpected number of guild application commands: %d", len(interactions.Commands(mockconstants.TestGuild)))
+	}
+
+	if len(interactions.Commands("otherShardGuild")) != 0 {
+		t.Error("Unexpected application commands registered for a guild on another shard")
+	}
+
+	for _, applicationCommand := range globalCommands {
+		if applicationCommand.Description == "" {
+			t.Errorf("Application command %s missing description", applicationCommand.Name)
+		}
+
+		// Commands requiring permissions are hidden from members without them
+		permissions, found := interactions.DefaultMemberPermissions("", applicationCommand.Name)
+
+		switch applicationCommand.Name {
+		case callbacks.ConfigCommand, callbacks.DiagnoseCommand:
+			if permissions != strconv.FormatInt(discordgo.PermissionManageServer, 10) {
+				t.Errorf("Unexpected %s default member permissions: %q", applicationCommand.Name, permissions)
+			}
+		case callbacks.HelpCommand, callbacks.InfoCommand:
+			if found {
+				t.Errorf("Unexpected %s default member permissions: %q", applicationCommand.Name, permissions)
+			}
+		}
+
+		if applicationCommand.Name != callbacks.LogLevelCommand {
+			continue
+		}
+
+		if len(applicationCommand.Options) != 1 || !applicationCommand.Options[0].Required ||
+			len(applicationCommand.Options[0].Choices) == 0 {
+			t.Errorf("Unexpected %s application command options", callbacks.LogLevelCommand)
+		}
+	}
+
+	// Shards other than the first leave global commands to the first shard
+	session.ShardID = 1
+
+	err = handler.RegisterApplicationCommands(session, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if interactions.CommandWrites() != 2 {
+		t.Errorf("Unexpected application command writes: %d", interactions.CommandWrites())
+	}
+}
+
+func TestHandler_InteractionCreate(t *testing.T) {
+	session, err := mock.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	interactions := mock.NewInteractions(session.Client.Transport)
+	session.Client.Transport = interactions
+
+	log := mock.NewLogger()
+
+	handler := &callbacks.Handler{
+		Log:                      log,
+		BotName:                  "testBot",
+		BotKeyword:               "testKeyword",
+		InteractionCreateCounter: monitor.InteractionCreateCounter(&monitor.Config{Log: log}),
+		Commands:                 callbacks.NewCommandRegistry(),
+		Authorizer:               callbacks.NewAuthorizer([]string{testOwner}),
+	}
+
+	member := &discordgo.Member{User: &discordgo.User{ID: mockconstants.TestUser}}
+
+	testCases := []struct {
+		name     string
+		data     discordgo.ApplicationCommandInteractionData
+		member   *discordgo.Member
+		user     *discordgo.User
+		content  string
+		hasEmbed bool
+	}{
+		{
+			name:     "info",
+			data:     discordgo.ApplicationCommandInteractionData{Name: callbacks.InfoCommand},
+			member:   member,
+			hasEmbed: true,
+		},
+		{
+			name:    "diagnose without manage server",
+			data:    discordgo.ApplicationCommandInteractionData{Name: callbacks.DiagnoseCommand},
+			member:  member,
+			content: "not allowed",
+		},
+		{
+			name: "not authorized",
+			data: discordgo.ApplicationCommandInteractionData{
+				Name: callbacks.LogLevelCommand,
+				Options: []*discordgo.ApplicationCommandInteractionDataOption{
+					{Name: "level", Value: callbacks.LogLevelParamDebug},
+				},
+			},
+			member:  member,
+			content: "not allowed",
+		},
+		{
+			name: "bot owner direct message",
+			data: discordgo.ApplicationCommandInteractionData{
+				Name: callbacks.LogLevelCommand,
+				Options: []*discordgo.ApplicationCommandInteractionDataOption{
+					{Name: "level", Value: callbacks.LogLevelParamDebug},
+				},
+			},
+			user:    &discordgo.User{ID: testOwner},
+			content: "Logging level changed to debug",
+		},
+		{
+			name: "invalid argument",
+			data: discordgo.ApplicationCommandInteractionData{
+				Name: callbacks.LogLevelCommand,
+				Options: []*discordgo.ApplicationCommandInteractionDataOption{
+					{Name: "level", Value: "verbose"},
+				},
+			},
+			user:    &discordgo.User{ID: testOwner},
+			content: "must be one of",
+		},
+		{
+			name:    "unknown command",
+			data:    discordgo.ApplicationCommandInteractionData{Name: "ixnay"},
+			member:  member,
+			content: "Something went wrong",
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			interaction := &discordgo.Interaction{
+				ID:        testCase.name,
+				Type:      discordgo.InteractionApplicationCommand,
+				Data:      testCase.data,
+				ChannelID: mockconstants.TestChannel,
+				Member:    testCase.member,
+				User:      testCase.user,
+				Token:     testCase.name + "Token",
+			}
+
+			if testCase.member != nil {
+				interaction.GuildID = mockconstants.TestGuild
+			}
+
+			handler.InteractionCreate(session, &discordgo.InteractionCreate{Interaction: interaction})
+
+			// The response is deferred so commands may outlast Discord's
+			// response deadline, and the follow-up inherits its flags
+			response := interactions.Response(interaction.ID)
+			if response == nil || response.Data == nil {
+				t.Fatal("Expected an interaction response")
+			}
+
+			if response.Type != discordgo.InteractionResponseDeferredChannelMessageWithSource {
+				t.Errorf("Expected a deferred interaction response, got type: %d", response.Type)
+			}
+
+			if response.Data.Flags&testEphemeralFlag == 0 {
+				t.Error("Expected an ephemeral interaction response")
+			}
+
+			followup := interactions.Followup(interaction.Token)
+			if followup == nil {
+				t.Fatal("Expected an interaction follow-up")
+			}
+
+			if !strings.Contains(followup.Content, testCase.content) {
+				t.Errorf("Expected follow-up content to contain %q, got: %q", testCase.content, followup.Content)
+			}
+
+			if testCase.hasEmbed != (len(followup.Embeds) != 0) {
+				t.Errorf("Unexpected follow-up embeds: %d", len(followup.Embeds))
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package callbacks
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 
@@ -12,6 +13,9 @@ import (
 const (
 	InfoCommand     = "info"
 	LogLevelCommand = "log_level"
+	DiagnoseCommand = "diagnose"
+	HelpCommand     = "help"
+	ConfigCommand   = "config"
 )
 
 // Supported command parameters
@@ -24,24 +28,23 @@ const (
 	LogLevelParamPanic   = "panic"
 )
 
-// Content token parsing
-const (
-	numTokensMinimum               = 1
-	numTokensWithCommand           = 2
-	numTokensWithCommandParameters = 3
-)
-
 const (
 	messageCreate           = "MessageCreate"
 	messageCreateEventError = "Unable to process event: " + messageCreate
 
 	infoMessageColor = 0xffa500
+	helpMessageColor = infoMessageColor
 
 	logoURLBase = "https://raw.githubusercontent.com/ewohltman/ephemeral-roles"
 	logoURLPath = "/master/web/static/Testa_Anatomica-Filippo_Balbi.jpg"
 	logoURL     = logoURLBase + logoURLPath
 
-	logLevelChange = "Logging level changed"
+	logLevelArgument = "level"
+	logLevelChange   = "Logging level changed"
+
+	unknownCommandMessage   = "Unknown command `%s`. Send `%s %s` for a list of commands."
+	invalidArgumentsMessage = "%s\nUsage: `%s`"
+	invalidQuotesMessage    = "Unable to read that command: %s."
 )
 
 // MessageCreate is the callback function for the MessageCreate event from Discord.
@@ -52,73 +55,137 @@ func (handler *Handler) MessageCreate(session *discordgo.Session, message *disco
 		return
 	}
 
-	// [BOT_KEYWORD] [command] [options] :: "!eph" "log_level" "debug"
-	contentTokens := strings.Split(strings.TrimSpace(message.Content), " ")
-	if len(contentTokens) < numTokensMinimum {
+	// [BOT_KEYWORD] [command] [arguments] :: "!eph" "log_level" "debug"
+	settings := handler.GuildSettings(message.GuildID)
+
+	fields := strings.Fields(message.Content)
+	if len(fields) == 0 || fields[0] != settings.BotKeyword {
 		return
 	}
 
-	if contentTokens[0] != handler.BotKeyword {
+	response, err := handler.parseMessage(session, message.Message, settings.BotKeyword)
+	if err != nil {
+		handler.logCommandError(err, messageCreateEventError)
+	}
+
+	if response == nil {
 		return
 	}
 
-	err := handler.parseMessage(session, contentTokens, message.ChannelID)
+	_, err = session.ChannelMessageSendComplex(message.ChannelID, response)
 	if err != nil {
-		handler.Log.WithError(err).Error(messageCreateEventError)
+		handler.Log.WithError(fmt.Errorf("error sending command response: %w", err)).Error(messageCreateEventError)
 	}
 }
 
-func (handler *Handler) parseMessage(s *discordgo.Session, contentTokens []string, channelID string) error {
-	if len(contentTokens) < numTokensWithCommand {
-		err := handler.handleInfo(s, channelID)
-		if err != nil {
-			return err
-		}
+// parseMessage parses the command from the provided message and runs it,
+// returning the response to the message.
+func (handler *Handler) parseMessage(
+	s *discordgo.Session,
+	message *discordgo.Message,
+	botKeyword string,
+) (*discordgo.MessageSend, error) {
+	tokens, err := Tokenize(message.Content)
+	if err != nil {
+		return &discordgo.MessageSend{Content: fmt.Sprintf(invalidQuotesMessage, err)}, nil
+	}
+
+	commandName := InfoCommand
+	if len(tokens) > 1 {
+		commandName = tokens[1]
+	}
 
-		return nil
+	command, found := handler.commandRegistry().Lookup(commandName)
+	if !found {
+		return &discordgo.MessageSend{
+			Content: fmt.Sprintf(unknownCommandMessage, commandName, botKeyword, HelpCommand),
+		}, nil
 	}
 
-	switch strings.ToLower(contentTokens[1]) {
-	case InfoCommand:
-		err := handler.handleInfo(s, channelID)
-		if err != nil {
-			return err
-		}
-	case LogLevelCommand:
-		handler.handleLogLevel(contentTokens)
+	var argumentTokens []string
+	if len(tokens) > 2 {
+		argumentTokens = tokens[2:]
+	}
+
+	arguments, err := command.ParseArguments(argumentTokens)
+	if err != nil {
+		return &discordgo.MessageSend{
+			Content: fmt.Sprintf(invalidArgumentsMessage, err, command.Usage(botKeyword)),
+		}, nil
 	}
 
-	return nil
+	return handler.runCommand(&CommandInvocation{
+		Session:   s,
+		Command:   command,
+		UserID:    message.Author.ID,
+		GuildID:   message.GuildID,
+		ChannelID: message.ChannelID,
+		Arguments: arguments,
+	})
 }
 
-func (handler *Handler) handleInfo(s *discordgo.Session, channelID string) error {
-	_, err := s.ChannelMessageSendEmbed(channelID, infoMessage())
+// runCommand runs the provided invocation if its user is authorized to.
+func (handler *Handler) runCommand(invocation *CommandInvocation) (*discordgo.MessageSend, error) {
+	err := handler.Authorizer.Authorize(invocation)
 	if err != nil {
-		return fmt.Errorf("error sending info message: %w", err)
+		return nil, err
 	}
 
-	return nil
+	return invocation.Command.Run(handler, invocation)
 }
 
-func (handler *Handler) handleLogLevel(contentTokens []string) {
-	if len(contentTokens) >= numTokensWithCommandParameters {
-		logLevel := strings.ToLower(contentTokens[2])
+// logCommandError logs the provided error from running a command as the
+// provided eventError. Denied attempts are logged with the user and guild
+// attempting them.
+func (handler *Handler) logCommandError(err error, eventError string) {
+	var notAuthorized *CommandNotAuthorized
 
-		logFields := logrus.Fields{LogLevelCommand: logLevel}
+	if errors.As(err, &notAuthorized) {
+		handler.Log.WithFields(logrus.Fields{
+			"user":    notAuthorized.UserID,
+			"guild":   notAuthorized.GuildID,
+			"command": notAuthorized.Command,
+		}).WithError(err).Warn(commandDenied)
 
-		handler.updateLogLevel(logLevel)
+		return
+	}
+
+	handler.Log.WithError(err).Error(eventError)
+}
+
+// commandRegistry returns the handler's CommandRegistry, or a new
+// *CommandRegistry with the built-in commands if none is set.
+func (handler *Handler) commandRegistry() *CommandRegistry {
+	if handler.Commands == nil {
+		return NewCommandRegistry()
+	}
+
+	return handler.Commands
+}
+
+func (handler *Handler) runInfo(_ *CommandInvocation) (*discordgo.MessageSend, error) {
+	return &discordgo.MessageSend{Embed: infoMessage()}, nil
+}
+
+func (handler *Handler) runLogLevel(invocation *CommandInvocation) (*discordgo.MessageSend, error) {
+	logLevel := invocation.Argument(logLevelArgument)
 
-		switch logLevel {
-		case LogLevelParamDebug:
-			handler.Log.WithFields(logFields).Debugf(logLevelChange)
-		case LogLevelParamInfo:
-			handler.Log.WithFields(logFields).Infof(logLevelChange)
-		case LogLevelParamWarning:
-			handler.Log.WithFields(logFields).Warnf(logLevelChange)
-		case LogLevelParamError:
-			handler.Log.WithFields(logFields).Errorf(logLevelChange)
-		}
+	logFields := logrus.Fields{LogLevelCommand: logLevel}
+
+	handler.updateLogLevel(logLevel)
+
+	switch logLevel {
+	case LogLevelParamDebug:
+		handler.Log.WithFields(logFields).Debugf(logLevelChange)
+	case LogLevelParamInfo:
+		handler.Log.WithFields(logFields).Infof(logLevelChange)
+	case LogLevelParamWarning:
+		handler.Log.WithFields(logFields).Warnf(logLevelChange)
+	case LogLevelParamError:
+		handler.Log.WithFields(logFields).Errorf(logLevelChange)
 	}
+
+	return &discordgo.MessageSend{Content: fmt.Sprintf("%s to %s.", logLevelChange, logLevel)}, nil
 }
 
 func (handler *Handler) updateLogLevel(logLevel string) {
@@ -42,6 +42,7 @@ func TestHandler_MessageCreate(t *testing.T) {
 		JaegerTracer:         jaegerTracer,
 		ContextTimeout:       time.Second,
 		MessageCreateCounter: monitor.MessageCreateCounter(&monitor.Config{Log: log}),
+		Authorizer:           callbacks.NewAuthorizer([]string{mockconstants.TestUser}),
 	}
 
 	originalLogLevel := log.Level.String()
@@ -54,6 +55,8 @@ func TestHandler_MessageCreate(t *testing.T) {
 		handler.BotKeyword, // only keyword
 		fmt.Sprintf("%s %s", handler.BotKeyword, "ixnay"), // keyword, unrecognized command
 		fmt.Sprintf("%s %s", handler.BotKeyword, callbacks.InfoCommand),
+		fmt.Sprintf("%s %s", handler.BotKeyword, callbacks.DiagnoseCommand),
+		fmt.Sprintf("%s %s", handler.BotKeyword, callbacks.HelpCommand),
 		fmt.Sprintf("%s %s %s", handler.BotKeyword, callbacks.LogLevelCommand, callbacks.LogLevelParamDebug),
 		fmt.Sprintf("%s %s %s", handler.BotKeyword, callbacks.LogLevelCommand, callbacks.LogLevelParamInfo),
 		fmt.Sprintf("%s %s %s", handler.BotKeyword, callbacks.LogLevelCommand, callbacks.LogLevelParamWarning),
@@ -86,6 +89,7 @@ func sendMessage(s *discordgo.Session, handler *callbacks.Handler, message strin
 	handler.MessageCreate(s, &discordgo.MessageCreate{
 		Message: &discordgo.Message{
 			Author: &discordgo.User{
+				ID:       mockconstants.TestUser,
 				Username: handler.BotName,
 				Bot:      false,
 			},
@@ -31,4 +31,9 @@ func (handler *Handler) Ready(s *discordgo.Session, event *discordgo.Ready) {
 	if err != nil {
 		handler.Log.WithError(err).Error(readyEventError)
 	}
+
+	err = handler.RegisterApplicationCommands(s, event.Guilds)
+	if err != nil {
+		handler.Log.WithError(err).Error(readyEventError)
+	}
 }
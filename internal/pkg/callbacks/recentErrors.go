@@ -0,0 +1,81 @@
+package callbacks
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultRecentErrors is the default number of recent errors kept per guild.
+const DefaultRecentErrors = 5
+
+// RecentError is a CallbackError and the time it occurred.
+type RecentError struct {
+	Time time.Time
+	Err  CallbackError
+}
+
+// RecentErrors keeps the most recent CallbackErrors of each guild so they can
+// be reported by the diagnose command. A nil *RecentErrors records nothing.
+type RecentErrors struct {
+	Size int
+
+	mutex  *sync.Mutex
+	guilds map[string][]*RecentError
+}
+
+// NewRecentErrors returns a new *RecentErrors keeping up to size errors per
+// guild.
+func NewRecentErrors(size int) *RecentErrors {
+	return &RecentErrors{
+		Size:   size,
+		mutex:  &sync.Mutex{},
+		guilds: make(map[string][]*RecentError),
+	}
+}
+
+// Record records the provided CallbackError for its guild, dropping the
+// oldest error of the guild if it already has Size errors.
+func (recentErrors *RecentErrors) Record(callbackError CallbackError) {
+	if recentErrors == nil || recentErrors.Size <= 0 {
+		return
+	}
+
+	guild := callbackError.InGuild()
+	if guild == nil {
+		return
+	}
+
+	recentErrors.mutex.Lock()
+	defer recentErrors.mutex.Unlock()
+
+	guildErrors := append(recentErrors.guilds[guild.ID], &RecentError{
+		Time: time.Now(),
+		Err:  callbackError,
+	})
+
+	if len(guildErrors) > recentErrors.Size {
+		guildErrors = guildErrors[len(guildErrors)-recentErrors.Size:]
+	}
+
+	recentErrors.guilds[guild.ID] = guildErrors
+}
+
+// Guild returns the recent errors of the guild associated with the provided
+// guildID, newest first.
+func (recentErrors *RecentErrors) Guild(guildID string) []*RecentError {
+	if recentErrors == nil {
+		return nil
+	}
+
+	recentErrors.mutex.Lock()
+	defer recentErrors.mutex.Unlock()
+
+	guildErrors := recentErrors.guilds[guildID]
+	newestFirst := make([]*RecentError, len(guildErrors))
+
+	for i, recentError := range guildErrors {
+		newestFirst[len(guildErrors)-1-i] = recentError
+	}
+
+	return newestFirst
+}
@@ -0,0 +1,316 @@
+package callbacks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
+
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/operations"
+)
+
+const (
+	reconcile           = "Reconcile"
+	reconcileEventError = "Unable to process event: " + reconcile
+	reconciledGuild     = "Reconciled ephemeral roles"
+)
+
+// reconcileMember is a snapshot of a member's voice channel and the ephemeral
+// roles they hold.
+type reconcileMember struct {
+	userID         string
+	channelID      string
+	ephemeralRoles []string
+}
+
+// Reconcile sets up an infinite loop reconciling ephemeral role membership
+// with the voice states of every guild in the session's state cache.
+func (handler *Handler) Reconcile(ctx context.Context, session *discordgo.Session, interval time.Duration) {
+	reconcileTicker := time.NewTicker(interval)
+	defer reconcileTicker.Stop()
+
+	for {
+		select {
+		case <-reconcileTicker.C:
+			for _, guild := range stateGuilds(session) {
+				if ctx.Err() != nil {
+					return
+				}
+
+				guildCtx, cancel := handler.contextWithTimeout(ctx)
+				handler.reconcileGuild(guildCtx, session, guild)
+				cancel()
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// reconcileGuild compares the voice states of the provided guild with the
+// members holding ephemeral roles, adding and removing ephemeral roles to
+// correct any drift. Members are reconciled through the VoiceStateDispatcher
+// so reconciliation never races their VoiceStateUpdate events, and members
+// with an event being processed or in their role removal grace window are
+// left to VoiceStateUpdate.
+func (handler *Handler) reconcileGuild(ctx context.Context, session *discordgo.Session, guild *discordgo.Guild) {
+	var added, removed int
+
+	log := handler.Log.WithField("guild", guild.Name)
+
+	diagnosis, err := operations.AnalyzePermissions(session, guild, handler.GuildSettings(guild.ID).RolePrefix)
+	if err == nil && !diagnosis.ManageRoles {
+		log.WithError(diagnosis.Err()).Debug(reconcileEventError)
+		return
+	}
+
+	for _, member := range handler.reconcileMembers(session, guild) {
+		if ctx.Err() != nil {
+			break
+		}
+
+		var (
+			memberAdded, memberRemoved int
+			err                        error
+		)
+
+		handler.VoiceStateDispatcher.RunExclusive(session, guild.ID, member.userID, func() {
+			if handler.RoleRemovalGrace.Pending(guild.ID, member.userID) {
+				return
+			}
+
+			current := handler.currentReconcileMember(session, guild, member.userID)
+			if current == nil {
+				return
+			}
+
+			memberAdded, memberRemoved, err = handler.reconcileMember(ctx, session, guild, current)
+		})
+
+		added += memberAdded
+		removed += memberRemoved
+
+		if err != nil {
+			log.WithField("member", member.userID).WithError(err).Debug(reconcileEventError)
+		}
+	}
+
+	if added == 0 && removed == 0 {
+		return
+	}
+
+	handler.ReconcileAddedCounter.WithLabelValues(guild.ID).Add(float64(added))
+	handler.ReconcileRemovedCounter.WithLabelValues(guild.ID).Add(float64(removed))
+
+	log.WithFields(logrus.Fields{
+		"added":   added,
+		"removed": removed,
+	}).Info(reconciledGuild)
+}
+
+// reconcileMembers returns a snapshot of the members of the provided guild
+// that are either connected to a voice channel or hold an ephemeral role.
+func (handler *Handler) reconcileMembers(session *discordgo.Session, guild *discordgo.Guild) []*reconcileMember {
+	rolePrefix := handler.GuildSettings(guild.ID).RolePrefix
+
+	session.State.RLock()
+	defer session.State.RUnlock()
+
+	ephemeralRoles := make(map[string]bool)
+
+	for _, role := range guild.Roles {
+		if strings.HasPrefix(role.Name, rolePrefix) {
+			ephemeralRoles[role.ID] = true
+		}
+	}
+
+	members := make(map[string]*reconcileMember)
+
+	for _, voiceState := range guild.VoiceStates {
+		members[voiceState.UserID] = &reconcileMember{
+			userID:    voiceState.UserID,
+			channelID: voiceState.ChannelID,
+		}
+	}
+
+	for _, guildMember := range guild.Members {
+		if guildMember.User == nil {
+			continue
+		}
+
+		for _, roleID := range guildMember.Roles {
+			if !ephemeralRoles[roleID] {
+				continue
+			}
+
+			member, found := members[guildMember.User.ID]
+			if !found {
+				member = &reconcileMember{userID: guildMember.User.ID}
+				members[guildMember.User.ID] = member
+			}
+
+			member.ephemeralRoles = append(member.ephemeralRoles, roleID)
+		}
+	}
+
+	reconcileMembers := make([]*reconcileMember, 0, len(members))
+
+	for _, member := range members {
+		reconcileMembers = append(reconcileMembers, member)
+	}
+
+	return reconcileMembers
+}
+
+// currentReconcileMember returns a snapshot of the member of the provided
+// guild associated with the provided userID, or nil if they are no longer a
+// member. The snapshot taken by reconcileMembers may be outdated by the time
+// the member is reconciled.
+func (handler *Handler) currentReconcileMember(
+	session *discordgo.Session,
+	guild *discordgo.Guild,
+	userID string,
+) *reconcileMember {
+	rolePrefix := handler.GuildSettings(guild.ID).RolePrefix
+
+	guildMember, err := session.State.Member(guild.ID, userID)
+	if err != nil {
+		return nil
+	}
+
+	session.State.RLock()
+	defer session.State.RUnlock()
+
+	member := &reconcileMember{userID: userID}
+
+	for _, voiceState := range guild.VoiceStates {
+		if voiceState.UserID == userID {
+			member.channelID = voiceState.ChannelID
+			break
+		}
+	}
+
+	memberRoles := make(map[string]bool, len(guildMember.Roles))
+
+	for _, roleID := range guildMember.Roles {
+		memberRoles[roleID] = true
+	}
+
+	for _, role := range guild.Roles {
+		if memberRoles[role.ID] && strings.HasPrefix(role.Name, rolePrefix) {
+			member.ephemeralRoles = append(member.ephemeralRoles, role.ID)
+		}
+	}
+
+	return member
+}
+
+// reconcileMember adds the ephemeral roles for the member's voice channel if
+// they are missing them and removes any other ephemeral roles they hold. It
+// returns the number of roles added and removed.
+func (handler *Handler) reconcileMember(
+	ctx context.Context,
+	session *discordgo.Session,
+	guild *discordgo.Guild,
+	member *reconcileMember,
+) (added, removed int, err error) {
+	expectedRoleIDs, err := handler.reconcileExpectedRoles(ctx, session, guild, member)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	heldRoleIDs := make(map[string]bool, len(member.ephemeralRoles))
+
+	for _, roleID := range member.ephemeralRoles {
+		if expectedRoleIDs[roleID] {
+			heldRoleIDs[roleID] = true
+			continue
+		}
+
+		err = handler.removeRole(ctx, operations.PriorityBackground, guild, member.userID, roleID)
+		if err != nil {
+			return added, removed, err
+		}
+
+		removed++
+	}
+
+	for roleID := range expectedRoleIDs {
+		if heldRoleIDs[roleID] {
+			continue
+		}
+
+		err = handler.addRole(ctx, operations.PriorityBackground, guild, member.userID, roleID)
+		if err != nil {
+			return added, removed, err
+		}
+
+		added++
+	}
+
+	return added, removed, nil
+}
+
+// reconcileExpectedRoles returns the IDs of the ephemeral roles the member
+// should hold, which is empty if they should not hold any. Missing roles are
+// created at background priority, but no role is evicted to make room for
+// them; that is left to the member's next VoiceStateUpdate.
+func (handler *Handler) reconcileExpectedRoles(
+	ctx context.Context,
+	session *discordgo.Session,
+	guild *discordgo.Guild,
+	member *reconcileMember,
+) (map[string]bool, error) {
+	expectedRoleIDs := make(map[string]bool)
+
+	if member.channelID == "" {
+		return expectedRoleIDs, nil
+	}
+
+	metadata, err := handler.parseEvent(ctx, operations.PriorityBackground, session, &discordgo.VoiceStateUpdate{
+		VoiceState: &discordgo.VoiceState{
+			GuildID:   guild.ID,
+			UserID:    member.userID,
+			ChannelID: member.channelID,
+		},
+	})
+	if err != nil {
+		var (
+			channelNotFoundErr         *ChannelNotFound
+			channelExcludedErr         *ChannelExcluded
+			insufficientPermissionsErr *InsufficientPermissions
+			maxNumberOfRolesErr        *MaxNumberOfRoles
+		)
+
+		switch {
+		case errors.As(err, &channelNotFoundErr),
+			errors.As(err, &channelExcludedErr),
+			errors.As(err, &insufficientPermissionsErr),
+			errors.As(err, &maxNumberOfRolesErr):
+			return expectedRoleIDs, nil
+		default:
+			return nil, fmt.Errorf("unable to determine ephemeral roles: %w", err)
+		}
+	}
+
+	for _, role := range metadata.EphemeralRoles {
+		expectedRoleIDs[role.ID] = true
+	}
+
+	return expectedRoleIDs, nil
+}
+
+func stateGuilds(session *discordgo.Session) []*discordgo.Guild {
+	session.State.RLock()
+	defer session.State.RUnlock()
+
+	guilds := make([]*discordgo.Guild, len(session.State.Guilds))
+
+	copy(guilds, session.State.Guilds)
+
+	return guilds
+}
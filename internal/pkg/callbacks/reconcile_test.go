@@ -0,0 +1,214 @@
+package callbacks_test
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ewohltman/discordgo-mock/mockconstants"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/callbacks"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/capacity"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/mock"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/monitor"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/operations"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/rolemap"
+)
+
+const testReconcileInterval = 10 * time.Millisecond
+
+func TestHandler_Reconcile(t *testing.T) {
+	session, err := mock.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log := mock.NewLogger()
+
+	handler := &callbacks.Handler{
+		Log:                     log,
+		BotName:                 "testBot",
+		BotKeyword:              "testKeyword",
+		RolePrefix:              "{eph}",
+		ReconcileAddedCounter:   monitor.ReconcileAddedCounter(&monitor.Config{Log: log}),
+		ReconcileRemovedCounter: monitor.ReconcileRemovedCounter(&monitor.Config{Log: log}),
+		OperationsGateway:       operations.NewGateway(session),
+		RoleMap:                 rolemap.New(),
+		Capacity:                capacity.NewManager(),
+	}
+
+	guild, err := session.State.Guild(mockconstants.TestGuild)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	guild.VoiceStates = []*discordgo.VoiceState{
+		{
+			GuildID:   mockconstants.TestGuild,
+			UserID:    mockconstants.TestUser,
+			ChannelID: mockconstants.TestChannel2,
+		},
+	}
+
+	handler.GuildCreate(session, &discordgo.GuildCreate{Guild: guild})
+
+	roleID, found := handler.RoleMap.RoleID(mockconstants.TestGuild, mockconstants.TestChannel2)
+	if !found {
+		t.Fatalf("Ephemeral role not created for channel %s", mockconstants.TestChannel2)
+	}
+
+	member, err := session.State.Member(mockconstants.TestGuild, mockconstants.TestUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !hasRole(member, roleID) {
+		t.Errorf("Ephemeral role for channel %s not added to member", mockconstants.TestChannel2)
+	}
+
+	ctx, cancelCtx := context.WithTimeout(context.Background(), 2*testReconcileInterval)
+	defer cancelCtx()
+
+	handler.Reconcile(ctx, session, testReconcileInterval)
+}
+
+func hasRole(member *discordgo.Member, roleID string) bool {
+	for _, memberRoleID := range member.Roles {
+		if memberRoleID == roleID {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestHandler_Reconcile_roleRemovalGrace(t *testing.T) {
+	session, err := mock.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counter := &methodCounter{next: session.Client.Transport, methods: make(map[string]int)}
+	session.Client.Transport = counter
+
+	removedCounter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_reconcile_removed"}, []string{"guild"})
+
+	handler := &callbacks.Handler{
+		Log:                     mock.NewLogger(),
+		BotName:                 "testBot",
+		BotKeyword:              "testKeyword",
+		RolePrefix:              "{eph}",
+		ReconcileAddedCounter:   prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_reconcile_added"}, []string{"guild"}),
+		ReconcileRemovedCounter: removedCounter,
+		OperationsGateway:       operations.NewGateway(session),
+		RoleMap:                 rolemap.New(),
+		Capacity:                capacity.NewManager(),
+		RoleRemovalGrace:        callbacks.NewRoleRemovalGrace(time.Hour),
+	}
+
+	guild, err := session.State.Guild(mockconstants.TestGuild)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The members holding the ephemeral role disconnected, but are still in
+	// their grace window
+	guild.VoiceStates = nil
+
+	handler.RoleRemovalGrace.Schedule(mockconstants.TestGuild, mockconstants.TestUser, func() {})
+	handler.RoleRemovalGrace.Schedule(mockconstants.TestGuild, testBotUser, func() {})
+
+	handler.GuildCreate(session, &discordgo.GuildCreate{Guild: guild})
+
+	if methods := counter.reset(); len(methods) != 0 {
+		t.Errorf("Unexpected member requests for member in grace window: %v", methods)
+	}
+
+	handler.RoleRemovalGrace.Cancel(mockconstants.TestGuild, mockconstants.TestUser)
+	handler.GuildCreate(session, &discordgo.GuildCreate{Guild: guild})
+
+	if methods := counter.reset(); len(methods) != 1 || methods[http.MethodDelete] != 1 {
+		t.Errorf("Unexpected member requests after grace window: %v", methods)
+	}
+
+	if removed := testutil.ToFloat64(removedCounter.WithLabelValues(mockconstants.TestGuild)); removed != 1 {
+		t.Errorf("Unexpected reconcile removed count for guild: %v", removed)
+	}
+}
+
+func TestHandler_Reconcile_backgroundPriority(t *testing.T) {
+	session, err := mock.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log := mock.NewLogger()
+	gateway := &priorityRecorder{Gateway: operations.NewGateway(session)}
+
+	handler := &callbacks.Handler{
+		Log:                     log,
+		BotName:                 "testBot",
+		BotKeyword:              "testKeyword",
+		RolePrefix:              "{eph}",
+		ReconcileAddedCounter:   monitor.ReconcileAddedCounter(&monitor.Config{Log: log}),
+		ReconcileRemovedCounter: monitor.ReconcileRemovedCounter(&monitor.Config{Log: log}),
+		OperationsGateway:       gateway,
+		RoleMap:                 rolemap.New(),
+		Capacity:                capacity.NewManager(),
+	}
+
+	guild, err := session.State.Guild(mockconstants.TestGuild)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	guild.VoiceStates = []*discordgo.VoiceState{
+		{
+			GuildID:   mockconstants.TestGuild,
+			UserID:    mockconstants.TestUser,
+			ChannelID: mockconstants.TestChannel2,
+		},
+	}
+
+	handler.GuildCreate(session, &discordgo.GuildCreate{Guild: guild})
+
+	if _, found := handler.RoleMap.RoleID(mockconstants.TestGuild, mockconstants.TestChannel2); !found {
+		t.Fatalf("Ephemeral role not created for channel %s", mockconstants.TestChannel2)
+	}
+
+	priorities := gateway.createPriorities()
+	if len(priorities) != 1 || priorities[0] != operations.PriorityBackground {
+		t.Errorf("Unexpected reconcile create role priorities: %v", priorities)
+	}
+}
+
+// priorityRecorder records the priority of the create role requests passed
+// through to the wrapped *operations.Gateway.
+type priorityRecorder struct {
+	*operations.Gateway
+	mutex      sync.Mutex
+	priorities []operations.Priority
+}
+
+func (recorder *priorityRecorder) CreateRole(request *operations.CreateRoleRequest) *operations.RoleFuture {
+	recorder.mutex.Lock()
+	recorder.priorities = append(recorder.priorities, request.Priority)
+	recorder.mutex.Unlock()
+
+	return recorder.Gateway.CreateRole(request)
+}
+
+func (recorder *priorityRecorder) createPriorities() []operations.Priority {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	priorities := make([]operations.Priority, len(recorder.priorities))
+	copy(priorities, recorder.priorities)
+
+	return priorities
+}
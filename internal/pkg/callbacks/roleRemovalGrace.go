@@ -0,0 +1,102 @@
+package callbacks
+
+import (
+	"context"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
+)
+
+// RoleRemovalGrace delays removing ephemeral roles from members who
+// disconnect, so members who briefly reconnect keep their roles. A nil
+// *RoleRemovalGrace, or one with no window, removes roles immediately.
+type RoleRemovalGrace struct {
+	Window time.Duration
+
+	timers *timers
+}
+
+// NewRoleRemovalGrace returns a new *RoleRemovalGrace with the provided grace
+// window.
+func NewRoleRemovalGrace(window time.Duration) *RoleRemovalGrace {
+	return &RoleRemovalGrace{
+		Window: window,
+		timers: newTimers(),
+	}
+}
+
+// Schedule schedules removeRoles to be called once the grace window has
+// passed, replacing any removal already scheduled for the same member.
+// Schedule reports whether the removal was scheduled, or false if roles
+// should be removed immediately.
+func (roleRemovalGrace *RoleRemovalGrace) Schedule(guildID, userID string, removeRoles func()) bool {
+	if roleRemovalGrace == nil || roleRemovalGrace.Window <= 0 {
+		return false
+	}
+
+	roleRemovalGrace.timers.schedule(guildID+"/"+userID, roleRemovalGrace.Window, removeRoles)
+
+	return true
+}
+
+// Cancel cancels the removal scheduled for the member associated with the
+// provided userID. Cancel reports whether a scheduled removal was cancelled.
+func (roleRemovalGrace *RoleRemovalGrace) Cancel(guildID, userID string) bool {
+	if roleRemovalGrace == nil {
+		return false
+	}
+
+	return roleRemovalGrace.timers.cancel(guildID + "/" + userID)
+}
+
+// Pending reports whether a removal is scheduled for the member associated
+// with the provided userID.
+func (roleRemovalGrace *RoleRemovalGrace) Pending(guildID, userID string) bool {
+	if roleRemovalGrace == nil {
+		return false
+	}
+
+	return roleRemovalGrace.timers.pending(guildID + "/" + userID)
+}
+
+// removeDisconnectedRoles removes the ephemeral roles of the provided member
+// once their grace window has passed, unless they have since reconnected.
+func (handler *Handler) removeDisconnectedRoles(session *discordgo.Session, guild *discordgo.Guild, userID string) {
+	if memberConnected(session, guild, userID) {
+		return
+	}
+
+	member, err := session.State.Member(guild.ID, userID)
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := handler.contextWithTimeout(context.Background())
+	defer cancel()
+
+	err = handler.removeEphemeralRoles(ctx, &voiceStateUpdateMetadata{
+		Session: session,
+		Guild:   guild,
+		Member:  member,
+	})
+	if err != nil {
+		handler.Log.WithFields(logrus.Fields{
+			"guild":  guild.Name,
+			"member": member.User.Username,
+		}).WithError(err).Error(voiceStateUpdateEventError)
+	}
+}
+
+func memberConnected(session *discordgo.Session, guild *discordgo.Guild, userID string) bool {
+	session.State.RLock()
+	defer session.State.RUnlock()
+
+	for _, voiceState := range guild.VoiceStates {
+		if voiceState.UserID == userID && voiceState.ChannelID != "" {
+			return true
+		}
+	}
+
+	return false
+}
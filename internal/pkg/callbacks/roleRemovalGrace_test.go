@@ -0,0 +1,116 @@
+package callbacks_test
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ewohltman/discordgo-mock/mockconstants"
+
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/callbacks"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/capacity"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/mock"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/monitor"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/operations"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/rolemap"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/tracer"
+)
+
+const (
+	testRoleRemovalGrace   = 50 * time.Millisecond
+	testRoleRemovalTimeout = time.Second
+)
+
+type removalCounter struct {
+	next     http.RoundTripper
+	removals int64
+}
+
+func (counter *removalCounter) RoundTrip(req *http.Request) (*http.Response, error) {
+	removal := req.Method == http.MethodDelete || req.Method == http.MethodPatch
+
+	if removal && strings.Contains(req.URL.Path, "/members/") {
+		atomic.AddInt64(&counter.removals, 1)
+	}
+
+	return counter.next.RoundTrip(req)
+}
+
+func (counter *removalCounter) count() int64 {
+	return atomic.LoadInt64(&counter.removals)
+}
+
+func TestHandler_VoiceStateUpdate_roleRemovalGrace(t *testing.T) {
+	jaegerTracer, jaegerCloser, err := tracer.New("test")
+	if err != nil {
+		t.Fatalf("Error creating Jaeger tracer: %s", err)
+	}
+
+	defer func() {
+		closeErr := jaegerCloser.Close()
+		if closeErr != nil {
+			t.Errorf("Error closing Jaeger tracer: %s", err)
+		}
+	}()
+
+	session, err := mock.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counter := &removalCounter{next: session.Client.Transport}
+	session.Client.Transport = counter
+
+	log := mock.NewLogger()
+
+	handler := &callbacks.Handler{
+		Log:                     log,
+		BotName:                 "testBot",
+		BotKeyword:              "testKeyword",
+		RolePrefix:              "{eph}",
+		JaegerTracer:            jaegerTracer,
+		VoiceStateUpdateCounter: monitor.VoiceStateUpdateCounter(&monitor.Config{Log: log}),
+		OperationsGateway:       operations.NewGateway(session),
+		RoleMap:                 rolemap.New(),
+		Capacity:                capacity.NewManager(),
+		RoleRemovalGrace:        callbacks.NewRoleRemovalGrace(testRoleRemovalGrace),
+	}
+
+	// Reconnecting within the grace window keeps the role
+	sendUpdate(session, handler, mockconstants.TestGuild, mockconstants.TestUser, mockconstants.TestChannel)
+	sendUpdate(session, handler, mockconstants.TestGuild, mockconstants.TestUser, "")
+	sendUpdate(session, handler, mockconstants.TestGuild, mockconstants.TestUser, mockconstants.TestChannel)
+
+	time.Sleep(4 * testRoleRemovalGrace)
+
+	if counter.count() != 0 {
+		t.Errorf("Ephemeral role removed after member reconnected within the grace window")
+	}
+
+	// Moving to a different channel swaps roles immediately
+	sendUpdate(session, handler, mockconstants.TestGuild, mockconstants.TestUser, mockconstants.TestChannel2)
+
+	moved := counter.count()
+	if moved == 0 {
+		t.Fatal("Ephemeral role not removed immediately when member changed channels")
+	}
+
+	// Disconnecting removes the role once the grace window has passed
+	sendUpdate(session, handler, mockconstants.TestGuild, mockconstants.TestUser, "")
+
+	if counter.count() != moved {
+		t.Error("Ephemeral role removed before the grace window passed")
+	}
+
+	deadline := time.Now().Add(testRoleRemovalTimeout)
+
+	for counter.count() == moved && time.Now().Before(deadline) {
+		time.Sleep(testRoleRemovalGrace)
+	}
+
+	if counter.count() == moved {
+		t.Error("Ephemeral role not removed after the grace window passed")
+	}
+}
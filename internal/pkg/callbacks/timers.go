@@ -0,0 +1,78 @@
+package callbacks
+
+import (
+	"sync"
+	"time"
+)
+
+// timers is a set of keyed timers, each of which can be rescheduled or
+// cancelled before it fires.
+type timers struct {
+	mutex  *sync.Mutex
+	timers map[string]*time.Timer
+}
+
+func newTimers() *timers {
+	return &timers{
+		mutex:  &sync.Mutex{},
+		timers: make(map[string]*time.Timer),
+	}
+}
+
+// schedule calls f once the delay has passed, replacing any timer already
+// scheduled for the same key.
+func (t *timers) schedule(key string, delay time.Duration, f func()) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	existing, found := t.timers[key]
+	if found {
+		existing.Stop()
+	}
+
+	var timer *time.Timer
+
+	timer = time.AfterFunc(delay, func() {
+		t.mutex.Lock()
+
+		current := t.timers[key] == timer
+		if current {
+			delete(t.timers, key)
+		}
+
+		t.mutex.Unlock()
+
+		if current {
+			f()
+		}
+	})
+
+	t.timers[key] = timer
+}
+
+// cancel stops the timer scheduled for the key and reports whether there was
+// one.
+func (t *timers) cancel(key string) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	timer, found := t.timers[key]
+	if !found {
+		return false
+	}
+
+	timer.Stop()
+	delete(t.timers, key)
+
+	return true
+}
+
+// pending reports whether a timer is scheduled for the key.
+func (t *timers) pending(key string) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	_, found := t.timers[key]
+
+	return found
+}
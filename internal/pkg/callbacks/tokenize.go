@@ -0,0 +1,70 @@
+package callbacks
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+// ErrUnterminatedQuote is returned when a message has an opening quote without
+// a closing quote.
+var ErrUnterminatedQuote = errors.New("unterminated quote")
+
+// ErrDanglingEscape is returned when a message ends with a backslash which
+// does not escape anything.
+var ErrDanglingEscape = errors.New("dangling escape at end of message")
+
+// Tokenize splits the provided message content into tokens separated by any
+// amount of whitespace, including newlines. Text within double quotes is a
+// single token, and a backslash escapes the rune that follows it. Phone
+// keyboards' curly double quotes are treated as straight double quotes.
+func Tokenize(content string) ([]string, error) {
+	var (
+		tokens  []string
+		token   strings.Builder
+		inToken bool
+		quoted  bool
+		escaped bool
+	)
+
+	for _, r := range content {
+		switch {
+		case escaped:
+			token.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			inToken = true
+			escaped = true
+		case isQuote(r):
+			inToken = true
+			quoted = !quoted
+		case unicode.IsSpace(r) && !quoted:
+			if inToken {
+				tokens = append(tokens, token.String())
+				token.Reset()
+				inToken = false
+			}
+		default:
+			inToken = true
+			token.WriteRune(r)
+		}
+	}
+
+	if escaped {
+		return nil, ErrDanglingEscape
+	}
+
+	if quoted {
+		return nil, ErrUnterminatedQuote
+	}
+
+	if inToken {
+		tokens = append(tokens, token.String())
+	}
+
+	return tokens, nil
+}
+
+func isQuote(r rune) bool {
+	return r == '"' || r == '“' || r == '”'
+}
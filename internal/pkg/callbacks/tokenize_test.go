@@ -0,0 +1,78 @@
+package callbacks_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/callbacks"
+)
+
+func TestTokenize(t *testing.T) {
+	testCases := []struct {
+		name     string
+		content  string
+		expected []string
+		err      error
+	}{
+		{
+			name:    "empty",
+			content: "",
+		},
+		{
+			name:     "single spaces",
+			content:  "!eph log_level debug",
+			expected: []string{"!eph", "log_level", "debug"},
+		},
+		{
+			name:     "repeated whitespace and newlines",
+			content:  "  !eph \t log_level\n\ndebug  ",
+			expected: []string{"!eph", "log_level", "debug"},
+		},
+		{
+			name:     "quoted argument",
+			content:  `!eph config set prefix "[voice] "`,
+			expected: []string{"!eph", "config", "set", "prefix", "[voice] "},
+		},
+		{
+			name:     "curly quotes",
+			content:  "!eph set “two words”",
+			expected: []string{"!eph", "set", "two words"},
+		},
+		{
+			name:     "empty quotes",
+			content:  `!eph set ""`,
+			expected: []string{"!eph", "set", ""},
+		},
+		{
+			name:     "escaped quote",
+			content:  `!eph set \"quoted\"`,
+			expected: []string{"!eph", "set", `"quoted"`},
+		},
+		{
+			name:    "unterminated quote",
+			content: `!eph set "oops`,
+			err:     callbacks.ErrUnterminatedQuote,
+		},
+		{
+			name:    "dangling escape",
+			content: `!eph set prefix foo\`,
+			err:     callbacks.ErrDanglingEscape,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			tokens, err := callbacks.Tokenize(testCase.content)
+			if !errors.Is(err, testCase.err) {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(tokens, testCase.expected) {
+				t.Errorf("Unexpected tokens: %q", tokens)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package callbacks
+
+import (
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// VoiceStateDispatcher serializes VoiceStateUpdate events for each member in
+// front of Handler.VoiceStateUpdate. Events for a member arriving while one is
+// being processed are coalesced so only the latest is processed next, while
+// events for different members are processed in parallel.
+type VoiceStateDispatcher struct {
+	Handler *Handler
+
+	mutex   *sync.Mutex
+	members map[string]*memberEvents
+}
+
+// memberEvents holds the latest event received for a member while an earlier
+// event is being processed.
+type memberEvents struct {
+	pending *discordgo.VoiceStateUpdate
+}
+
+// NewVoiceStateDispatcher returns a new *VoiceStateDispatcher dispatching
+// events to the provided handler.
+func NewVoiceStateDispatcher(handler *Handler) *VoiceStateDispatcher {
+	return &VoiceStateDispatcher{
+		Handler: handler,
+		mutex:   &sync.Mutex{},
+		members: make(map[string]*memberEvents),
+	}
+}
+
+// VoiceStateUpdate is the callback function for the VoiceStateUpdate event
+// from Discord. If an event for the same member is already being processed,
+// VoiceStateUpdate replaces any pending event for the member and returns
+// immediately.
+func (dispatcher *VoiceStateDispatcher) VoiceStateUpdate(
+	session *discordgo.Session,
+	voiceState *discordgo.VoiceStateUpdate,
+) {
+	key := voiceState.GuildID + "/" + voiceState.UserID
+
+	if !dispatcher.acquire(key, voiceState) {
+		return
+	}
+
+	defer dispatcher.release(session, key)
+
+	dispatcher.Handler.VoiceStateUpdate(session, latestVoiceState(session, voiceState))
+}
+
+// RunExclusive runs f for the member associated with the provided userID
+// unless an event for the member is being processed, and reports whether f
+// was run. Events for the member arriving while f runs are processed once it
+// returns. A nil *VoiceStateDispatcher always runs f.
+func (dispatcher *VoiceStateDispatcher) RunExclusive(session *discordgo.Session, guildID, userID string, f func()) bool {
+	if dispatcher == nil {
+		f()
+		return true
+	}
+
+	key := guildID + "/" + userID
+
+	if !dispatcher.acquire(key, nil) {
+		return false
+	}
+
+	defer dispatcher.release(session, key)
+
+	f()
+
+	return true
+}
+
+// acquire marks the member associated with the provided key as busy and
+// reports true if they were idle. Otherwise the provided voiceState, if any,
+// replaces the pending event for the member.
+func (dispatcher *VoiceStateDispatcher) acquire(key string, voiceState *discordgo.VoiceStateUpdate) bool {
+	dispatcher.mutex.Lock()
+	defer dispatcher.mutex.Unlock()
+
+	events, found := dispatcher.members[key]
+	if found {
+		if voiceState != nil {
+			events.pending = voiceState
+		}
+
+		return false
+	}
+
+	dispatcher.members[key] = &memberEvents{}
+
+	return true
+}
+
+// release processes the events received for the member associated with the
+// provided key while they were busy, then marks them as idle. If processing
+// panicked, pending events are dropped and the member is marked as idle so
+// their later events are not swallowed.
+func (dispatcher *VoiceStateDispatcher) release(session *discordgo.Session, key string) {
+	if recovered := recover(); recovered != nil {
+		dispatcher.mutex.Lock()
+		delete(dispatcher.members, key)
+		dispatcher.mutex.Unlock()
+
+		panic(recovered)
+	}
+
+	idle := false
+
+	defer func() {
+		if !idle {
+			dispatcher.mutex.Lock()
+			delete(dispatcher.members, key)
+			dispatcher.mutex.Unlock()
+		}
+	}()
+
+	for {
+		dispatcher.mutex.Lock()
+
+		events := dispatcher.members[key]
+
+		voiceState := events.pending
+		events.pending = nil
+
+		if voiceState == nil {
+			delete(dispatcher.members, key)
+			dispatcher.mutex.Unlock()
+
+			idle = true
+
+			return
+		}
+
+		dispatcher.mutex.Unlock()
+
+		dispatcher.Handler.VoiceStateUpdate(session, latestVoiceState(session, voiceState))
+	}
+}
+
+// latestVoiceState returns the member's voice state from the session's state
+// cache, which is updated in the order events are received, rather than the
+// provided event which may have been dispatched out of order. The provided
+// event is returned if voice states are not being tracked.
+func latestVoiceState(session *discordgo.Session, voiceState *discordgo.VoiceStateUpdate) *discordgo.VoiceStateUpdate {
+	if !session.StateEnabled || !session.State.TrackVoice {
+		return voiceState
+	}
+
+	guild, err := session.State.Guild(voiceState.GuildID)
+	if err != nil {
+		return voiceState
+	}
+
+	session.State.RLock()
+	defer session.State.RUnlock()
+
+	latest := &discordgo.VoiceState{
+		GuildID: voiceState.GuildID,
+		UserID:  voiceState.UserID,
+	}
+
+	for _, guildVoiceState := range guild.VoiceStates {
+		if guildVoiceState.UserID == voiceState.UserID {
+			copied := *guildVoiceState
+			latest = &copied
+
+			break
+		}
+	}
+
+	return &discordgo.VoiceStateUpdate{VoiceState: latest}
+}
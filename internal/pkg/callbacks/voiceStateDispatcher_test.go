@@ -0,0 +1,248 @@
+package callbacks_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ewohltman/discordgo-mock/mockconstants"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/callbacks"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/capacity"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/mock"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/operations"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/rolemap"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/tracer"
+)
+
+const testBotUser = mockconstants.TestUser + "Bot"
+
+type blockingRoundTripper struct {
+	next    http.RoundTripper
+	blocked chan struct{}
+	release chan struct{}
+}
+
+func (rt *blockingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == http.MethodPost {
+		select {
+		case rt.blocked <- struct{}{}:
+			<-rt.release
+		default:
+		}
+	}
+
+	return rt.next.RoundTrip(req)
+}
+
+func TestVoiceStateDispatcher_VoiceStateUpdate(t *testing.T) {
+	jaegerTracer, jaegerCloser, err := tracer.New("test")
+	if err != nil {
+		t.Fatalf("Error creating Jaeger tracer: %s", err)
+	}
+
+	defer func() {
+		closeErr := jaegerCloser.Close()
+		if closeErr != nil {
+			t.Errorf("Error closing Jaeger tracer: %s", err)
+		}
+	}()
+
+	session, err := mock.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roundTripper := &blockingRoundTripper{
+		next:    session.Client.Transport,
+		blocked: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	session.Client.Transport = roundTripper
+
+	voiceStateUpdateCounter := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_voice_state_updates"})
+
+	handler := &callbacks.Handler{
+		Log:                     mock.NewLogger(),
+		BotName:                 "testBot",
+		BotKeyword:              "testKeyword",
+		RolePrefix:              "{eph}",
+		JaegerTracer:            jaegerTracer,
+		VoiceStateUpdateCounter: voiceStateUpdateCounter,
+		OperationsGateway:       operations.NewGateway(session),
+		RoleMap:                 rolemap.New(),
+		Capacity:                capacity.NewManager(),
+	}
+
+	dispatcher := callbacks.NewVoiceStateDispatcher(handler)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		dispatch(t, session, dispatcher, mockconstants.TestUser, mockconstants.TestChannel2)
+	}()
+
+	<-roundTripper.blocked
+
+	// Events for other members are not blocked
+	dispatch(t, session, dispatcher, testBotUser, mockconstants.TestChannel)
+
+	// Events for the same member are coalesced
+	dispatch(t, session, dispatcher, mockconstants.TestUser, "")
+	dispatch(t, session, dispatcher, mockconstants.TestUser, mockconstants.TestChannel)
+	dispatch(t, session, dispatcher, mockconstants.TestUser, mockconstants.TestChannel2)
+
+	if processed := testutil.ToFloat64(voiceStateUpdateCounter); processed != 2 {
+		t.Errorf("Unexpected number of processed events while blocked: %v", processed)
+	}
+
+	close(roundTripper.release)
+	<-done
+
+	if processed := testutil.ToFloat64(voiceStateUpdateCounter); processed != 3 {
+		t.Errorf("Unexpected number of processed events: %v", processed)
+	}
+
+	roleID, found := handler.RoleMap.RoleID(mockconstants.TestGuild, mockconstants.TestChannel2)
+	if !found {
+		t.Fatalf("Ephemeral role not created for channel %s", mockconstants.TestChannel2)
+	}
+
+	member, err := session.State.Member(mockconstants.TestGuild, mockconstants.TestUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !hasRole(member, roleID) {
+		t.Errorf("Ephemeral role for channel %s not added to member", mockconstants.TestChannel2)
+	}
+}
+
+// dispatch updates the session's state cache before dispatching the event, as
+// discordgo does.
+func dispatch(
+	t *testing.T,
+	session *discordgo.Session,
+	dispatcher *callbacks.VoiceStateDispatcher,
+	userID, channelID string,
+) {
+	voiceStateUpdate := &discordgo.VoiceStateUpdate{
+		VoiceState: &discordgo.VoiceState{
+			UserID:    userID,
+			GuildID:   mockconstants.TestGuild,
+			ChannelID: channelID,
+		},
+	}
+
+	err := session.State.OnInterface(session, voiceStateUpdate)
+	if err != nil {
+		t.Error(err)
+	}
+
+	dispatcher.VoiceStateUpdate(session, voiceStateUpdate)
+}
+
+func TestVoiceStateDispatcher_RunExclusive(t *testing.T) {
+	jaegerTracer, jaegerCloser, err := tracer.New("test")
+	if err != nil {
+		t.Fatalf("Error creating Jaeger tracer: %s", err)
+	}
+
+	defer func() {
+		closeErr := jaegerCloser.Close()
+		if closeErr != nil {
+			t.Errorf("Error closing Jaeger tracer: %s", err)
+		}
+	}()
+
+	var nilDispatcher *callbacks.VoiceStateDispatcher
+
+	if ran := nilDispatcher.RunExclusive(nil, mockconstants.TestGuild, mockconstants.TestUser, func() {}); !ran {
+		t.Error("Expected nil dispatcher to run f")
+	}
+
+	session, err := mock.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	voiceStateUpdateCounter := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_voice_state_updates"})
+
+	handler := &callbacks.Handler{
+		Log:                     mock.NewLogger(),
+		BotName:                 "testBot",
+		BotKeyword:              "testKeyword",
+		RolePrefix:              "{eph}",
+		JaegerTracer:            jaegerTracer,
+		VoiceStateUpdateCounter: voiceStateUpdateCounter,
+		OperationsGateway:       operations.NewGateway(session),
+		RoleMap:                 rolemap.New(),
+		Capacity:                capacity.NewManager(),
+	}
+
+	dispatcher := callbacks.NewVoiceStateDispatcher(handler)
+
+	ran := dispatcher.RunExclusive(session, mockconstants.TestGuild, mockconstants.TestUser, func() {
+		// Members are busy while f runs, so their events wait for it
+		nested := dispatcher.RunExclusive(session, mockconstants.TestGuild, mockconstants.TestUser, func() {
+			t.Error("Unexpected nested run for a busy member")
+		})
+		if nested {
+			t.Error("Expected busy member not to be run")
+		}
+
+		dispatch(t, session, dispatcher, mockconstants.TestUser, mockconstants.TestChannel2)
+
+		if processed := testutil.ToFloat64(voiceStateUpdateCounter); processed != 0 {
+			t.Errorf("Unexpected event processed while member is busy: %v", processed)
+		}
+	})
+	if !ran {
+		t.Fatal("Expected idle member to be run")
+	}
+
+	if processed := testutil.ToFloat64(voiceStateUpdateCounter); processed != 1 {
+		t.Errorf("Expected event received while busy to be processed afterwards, processed: %v", processed)
+	}
+}
+
+func TestVoiceStateDispatcher_panic(t *testing.T) {
+	session, err := mock.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// VoiceStateUpdate panics without a VoiceStateUpdateCounter
+	dispatcher := callbacks.NewVoiceStateDispatcher(&callbacks.Handler{Log: mock.NewLogger()})
+
+	mustPanic(t, func() {
+		dispatch(t, session, dispatcher, mockconstants.TestUser, mockconstants.TestChannel2)
+	})
+
+	mustPanic(t, func() {
+		dispatcher.RunExclusive(session, mockconstants.TestGuild, testBotUser, func() {
+			panic("test panic")
+		})
+	})
+
+	// Members are idle again after a panic
+	for _, userID := range []string{mockconstants.TestUser, testBotUser} {
+		if ran := dispatcher.RunExclusive(session, mockconstants.TestGuild, userID, func() {}); !ran {
+			t.Errorf("Expected member %s to be idle after a panic", userID)
+		}
+	}
+}
+
+func mustPanic(t *testing.T, f func()) {
+	t.Helper()
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected a panic")
+		}
+	}()
+
+	f()
+}
@@ -1,6 +1,7 @@
 package callbacks
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"sort"
@@ -18,11 +19,26 @@ const (
 )
 
 type voiceStateUpdateMetadata struct {
-	Session       *discordgo.Session
-	Guild         *discordgo.Guild
-	Member        *discordgo.Member
-	Channel       *discordgo.Channel
-	EphemeralRole *discordgo.Role
+	Session *discordgo.Session
+	Guild   *discordgo.Guild
+	Member  *discordgo.Member
+	Channel *discordgo.Channel
+
+	// EphemeralRoles are the ephemeral roles for the member's voice channel
+	// and, depending on the guild's role mode, its category.
+	EphemeralRoles []*discordgo.Role
+}
+
+// hasEphemeralRole returns whether the role associated with the provided
+// roleID is one of the ephemeral roles the member should hold.
+func (metadata *voiceStateUpdateMetadata) hasEphemeralRole(roleID string) bool {
+	for _, role := range metadata.EphemeralRoles {
+		if role.ID == roleID {
+			return true
+		}
+	}
+
+	return false
 }
 
 // VoiceStateUpdate is the callback function for the VoiceStateUpdate event from Discord.
@@ -32,9 +48,12 @@ func (handler *Handler) VoiceStateUpdate(session *discordgo.Session, voiceState
 	span := handler.JaegerTracer.StartSpan(voiceStateUpdate)
 	defer span.Finish()
 
-	metadata, err := handler.parseEvent(session, voiceState)
+	ctx, cancel := handler.contextWithTimeout(context.Background())
+	defer cancel()
+
+	metadata, err := handler.parseEvent(ctx, operations.PriorityInteractive, session, voiceState)
 	if err != nil {
-		handler.handleParseEventError(session, err)
+		handler.handleParseEventError(ctx, session, err)
 		return
 	}
 
@@ -45,13 +64,44 @@ func (handler *Handler) VoiceStateUpdate(session *discordgo.Session, voiceState
 		},
 	)
 
-	if metadata.EphemeralRole != nil {
-		if handler.memberHasRole(metadata.Member, metadata.EphemeralRole) {
+	handler.RoleRemovalGrace.Cancel(metadata.Guild.ID, metadata.Member.User.ID)
+
+	if len(metadata.EphemeralRoles) != 0 {
+		hasRoles := true
+
+		for _, role := range metadata.EphemeralRoles {
+			handler.EmptyRoleDeleter.Cancel(metadata.Guild.ID, role.ID)
+
+			if handler.memberHasRole(metadata.Member, role) {
+				handler.Capacity.Touch(metadata.Guild.ID, role.ID)
+			} else {
+				hasRoles = false
+			}
+		}
+
+		if hasRoles {
+			return
+		}
+	}
+
+	if metadata.Channel == nil {
+		userID := metadata.Member.User.ID
+
+		scheduled := handler.RoleRemovalGrace.Schedule(metadata.Guild.ID, userID, func() {
+			handler.removeDisconnectedRoles(session, metadata.Guild, userID)
+		})
+		if scheduled {
 			return
 		}
 	}
 
-	err = handler.removeEphemeralRoles(metadata)
+	swapped, err := handler.swapEphemeralRoles(ctx, metadata)
+	if swapped || err != nil {
+		logRoleUpdateError(log, err)
+		return
+	}
+
+	err = handler.removeEphemeralRoles(ctx, metadata)
 	if err != nil {
 		log.WithError(err).Error(voiceStateUpdateEventError)
 	}
@@ -60,20 +110,26 @@ func (handler *Handler) VoiceStateUpdate(session *discordgo.Session, voiceState
 		return
 	}
 
-	err = handler.addEphemeralRole(metadata)
-	if err != nil {
-		if operations.ShouldLogDebug(err) {
-			log.WithError(err).Debug(voiceStateUpdateEventError)
-			return
-		}
+	err = handler.addEphemeralRoles(ctx, metadata)
+	logRoleUpdateError(log, err)
+}
 
-		log.WithError(err).Error(voiceStateUpdateEventError)
+func logRoleUpdateError(log *logrus.Entry, err error) {
+	if err == nil {
+		return
+	}
 
+	if operations.ShouldLogDebug(err) {
+		log.WithError(err).Debug(voiceStateUpdateEventError)
 		return
 	}
+
+	log.WithError(err).Error(voiceStateUpdateEventError)
 }
 
 func (handler *Handler) parseEvent(
+	ctx context.Context,
+	priority operations.Priority,
 	session *discordgo.Session,
 	voiceState *discordgo.VoiceStateUpdate,
 ) (*voiceStateUpdateMetadata, error) {
@@ -107,6 +163,12 @@ func (handler *Handler) parseEvent(
 		}
 	}
 
+	settings := handler.GuildSettings(guild.ID)
+
+	if !settings.ChannelEnabled(channel, guild.AfkChannelID) {
+		return nil, &ChannelExcluded{Guild: guild, Member: member, Channel: channel}
+	}
+
 	err = operations.BotHasChannelPermission(session, channel)
 	if err != nil {
 		return nil, &InsufficientPermissions{
@@ -117,9 +179,49 @@ func (handler *Handler) parseEvent(
 		}
 	}
 
-	ephemeralRole, err := handler.lookupGuildRole(guild, channel)
+	diagnosis, err := operations.AnalyzePermissions(session, guild, settings.RolePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("unable to analyze permissions: %w", err)
+	}
+
+	if !diagnosis.ManageRoles {
+		return nil, &InsufficientPermissions{Guild: guild, Member: member, Channel: channel, Err: diagnosis.Err()}
+	}
+
+	metadata := &voiceStateUpdateMetadata{
+		Session: session,
+		Guild:   guild,
+		Member:  member,
+		Channel: channel,
+	}
+
+	for _, roleChannel := range roleChannels(session, settings, channel) {
+		ephemeralRole, err := handler.ephemeralRole(ctx, priority, metadata, diagnosis, roleChannel)
+		if err != nil {
+			return nil, err
+		}
+
+		metadata.EphemeralRoles = append(metadata.EphemeralRoles, ephemeralRole)
+	}
+
+	return metadata, nil
+}
+
+// ephemeralRole returns the ephemeral role mapped to the provided
+// roleChannel, which is either the member's voice channel or its category,
+// creating the role at the provided priority if it does not exist yet.
+func (handler *Handler) ephemeralRole(
+	ctx context.Context,
+	priority operations.Priority,
+	metadata *voiceStateUpdateMetadata,
+	diagnosis *operations.PermissionDiagnosis,
+	roleChannel *discordgo.Channel,
+) (*discordgo.Role, error) {
+	guild, member, channel := metadata.Guild, metadata.Member, metadata.Channel
+
+	ephemeralRole, err := handler.lookupChannelRole(metadata.Session, guild, roleChannel)
 	if errors.Is(err, &RoleNotFound{}) {
-		ephemeralRole, err = handler.createRole(guild, handler.RoleNameFromChannel(channel.Name))
+		ephemeralRole, err = handler.createRoleWithEviction(ctx, priority, metadata.Session, guild, roleChannel)
 		if err != nil {
 			switch {
 			case operations.IsDeadlineExceeded(err):
@@ -134,19 +236,18 @@ func (handler *Handler) parseEvent(
 		}
 	}
 
-	return &voiceStateUpdateMetadata{
-		Session:       session,
-		Guild:         guild,
-		Member:        member,
-		Channel:       channel,
-		EphemeralRole: ephemeralRole,
-	}, nil
+	if !diagnosis.CanManage(ephemeralRole.ID) {
+		return nil, &InsufficientPermissions{Guild: guild, Member: member, Channel: channel, Err: diagnosis.Err()}
+	}
+
+	return ephemeralRole, nil
 }
 
-func (handler *Handler) handleParseEventError(session *discordgo.Session, err error) {
+func (handler *Handler) handleParseEventError(ctx context.Context, session *discordgo.Session, err error) {
 	var (
 		memberNotFoundErr          *MemberNotFound
 		channelNotFoundErr         *ChannelNotFound
+		channelExcludedErr         *ChannelExcluded
 		insufficientPermissionsErr *InsufficientPermissions
 		maxNumberOfRolesErr        *MaxNumberOfRoles
 		deadlineExceededErr        *DeadlineExceeded
@@ -154,13 +255,17 @@ func (handler *Handler) handleParseEventError(session *discordgo.Session, err er
 
 	switch {
 	case errors.As(err, &memberNotFoundErr):
-		handler.logCleanup(session, memberNotFoundErr)
+		handler.logCleanup(ctx, session, memberNotFoundErr)
 	case errors.As(err, &channelNotFoundErr):
-		handler.logCleanup(session, channelNotFoundErr)
+		handler.logCleanup(ctx, session, channelNotFoundErr)
+	case errors.As(err, &channelExcludedErr):
+		// Excluded channels are not an error, but members joining them
+		// from another voice channel still lose its ephemeral role
+		handler.cleanupParseEventError(ctx, session, channelExcludedErr)
 	case errors.As(err, &insufficientPermissionsErr):
-		handler.logCleanup(session, insufficientPermissionsErr)
+		handler.logCleanup(ctx, session, insufficientPermissionsErr)
 	case errors.As(err, &maxNumberOfRolesErr):
-		handler.logCleanup(session, maxNumberOfRolesErr)
+		handler.logCleanup(ctx, session, maxNumberOfRolesErr)
 	case errors.As(err, &deadlineExceededErr):
 		handler.logParseEventError(deadlineExceededErr)
 	default:
@@ -168,16 +273,18 @@ func (handler *Handler) handleParseEventError(session *discordgo.Session, err er
 	}
 }
 
-func (handler *Handler) logCleanup(session *discordgo.Session, callbackError CallbackError) {
+func (handler *Handler) logCleanup(ctx context.Context, session *discordgo.Session, callbackError CallbackError) {
 	handler.logParseEventError(callbackError)
-	handler.cleanupParseEventError(session, callbackError)
+	handler.cleanupParseEventError(ctx, session, callbackError)
 }
 
 func (handler *Handler) logParseEventError(callbackError CallbackError) {
+	handler.RecentErrors.Record(callbackError)
+
 	handler.newCallbackErrorLogger(callbackError).WithError(callbackError).Debug(voiceStateUpdateEventError)
 }
 
-func (handler *Handler) cleanupParseEventError(session *discordgo.Session, callbackError CallbackError) {
+func (handler *Handler) cleanupParseEventError(ctx context.Context, session *discordgo.Session, callbackError CallbackError) {
 	metadata := &voiceStateUpdateMetadata{
 		Session: session,
 		Guild:   callbackError.InGuild(),
@@ -188,7 +295,15 @@ func (handler *Handler) cleanupParseEventError(session *discordgo.Session, callb
 		return
 	}
 
-	err := handler.removeEphemeralRoles(metadata)
+	// Without Manage Roles, removing the member's ephemeral roles would only
+	// be forbidden as well
+	var insufficientRolePermissionsErr *operations.InsufficientRolePermissions
+
+	if errors.As(callbackError, &insufficientRolePermissionsErr) && !insufficientRolePermissionsErr.Diagnosis.ManageRoles {
+		return
+	}
+
+	err := handler.removeEphemeralRoles(ctx, metadata)
 	if err != nil {
 		handler.newCallbackErrorLogger(callbackError).WithError(err).Debug(voiceStateUpdateEventError)
 	}
@@ -231,60 +346,183 @@ func (handler *Handler) memberHasRole(member *discordgo.Member, role *discordgo.
 	return index != len(memberRoles) && memberRoles[index] == role.ID
 }
 
-func (handler *Handler) lookupGuildRole(guild *discordgo.Guild, channel *discordgo.Channel) (*discordgo.Role, error) {
-	guildRoles := make([]*discordgo.Role, len(guild.Roles))
-	ephemeralRoleName := handler.RoleNameFromChannel(channel.Name)
+func (handler *Handler) createRole(
+	ctx context.Context,
+	priority operations.Priority,
+	guild *discordgo.Guild,
+	channel *discordgo.Channel,
+) (*discordgo.Role, error) {
+	settings := handler.GuildSettings(guild.ID)
+
+	role, err := handler.OperationsGateway.CreateRole(&operations.CreateRoleRequest{
+		Guild:           guild,
+		ChannelID:       channel.ID,
+		RoleName:        settings.RoleName(channel.Name),
+		RoleColor:       settings.RoleColor,
+		RoleHoist:       settings.RoleHoist,
+		RoleMentionable: settings.RoleMentionable,
+		Priority:        priority,
+	}).Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-	copy(guildRoles, guild.Roles)
+	handler.RoleMap.Set(guild.ID, channel.ID, role.ID)
+	handler.Capacity.Touch(guild.ID, role.ID)
 
-	sort.Slice(guildRoles, func(i, j int) bool {
-		return guildRoles[i].Name < guildRoles[j].Name
-	})
+	return role, nil
+}
 
-	index := sort.Search(len(guildRoles), func(i int) bool {
-		return guildRoles[i].Name >= ephemeralRoleName
-	})
+func (handler *Handler) addRole(
+	ctx context.Context,
+	priority operations.Priority,
+	guild *discordgo.Guild,
+	userID, roleID string,
+) error {
+	return handler.OperationsGateway.AddRole(&operations.AddRoleRequest{
+		Guild:    guild,
+		UserID:   userID,
+		RoleID:   roleID,
+		Priority: priority,
+	}).Wait(ctx)
+}
 
-	if index != len(guildRoles) && guildRoles[index].Name == ephemeralRoleName {
-		return guildRoles[index], nil
+func (handler *Handler) removeRole(
+	ctx context.Context,
+	priority operations.Priority,
+	guild *discordgo.Guild,
+	userID, roleID string,
+) error {
+	return handler.OperationsGateway.RemoveRole(&operations.RemoveRoleRequest{
+		Guild:    guild,
+		UserID:   userID,
+		RoleID:   roleID,
+		Priority: priority,
+	}).Wait(ctx)
+}
+
+func (handler *Handler) setRoles(
+	ctx context.Context,
+	priority operations.Priority,
+	guild *discordgo.Guild,
+	userID string,
+	roleIDs []string,
+) error {
+	return handler.OperationsGateway.SetRoles(&operations.SetRolesRequest{
+		Guild:    guild,
+		UserID:   userID,
+		RoleIDs:  roleIDs,
+		Priority: priority,
+	}).Wait(ctx)
+}
+
+// addEphemeralRoles adds the ephemeral roles for the member's channel which
+// they do not hold yet.
+func (handler *Handler) addEphemeralRoles(ctx context.Context, metadata *voiceStateUpdateMetadata) error {
+	for _, role := range metadata.EphemeralRoles {
+		if handler.memberHasRole(metadata.Member, role) {
+			continue
+		}
+
+		err := handler.addRole(ctx, operations.PriorityInteractive, metadata.Guild, metadata.Member.User.ID, role.ID)
+		if err != nil {
+			return err
+		}
+
+		handler.Capacity.Touch(metadata.Guild.ID, role.ID)
 	}
 
-	return nil, &RoleNotFound{}
+	return nil
 }
 
-func (handler *Handler) createRole(guild *discordgo.Guild, roleName string) (*discordgo.Role, error) {
-	resultChannel := operations.NewResultChannel()
+// swapEphemeralRoles replaces the member's other ephemeral roles with the
+// ephemeral roles for their channel in a single request. It reports false without making
+// any request if the member has no ephemeral roles to remove, so a single add
+// is cheaper, or if the bot cannot see all of the member's roles, in which
+// case the roles must be swapped one at a time. As the request replaces all
+// of the member's roles, the member is fetched again right before it so
+// role changes made elsewhere since the member was cached are kept.
+func (handler *Handler) swapEphemeralRoles(ctx context.Context, metadata *voiceStateUpdateMetadata) (bool, error) {
+	if len(metadata.EphemeralRoles) == 0 {
+		return false, nil
+	}
 
-	handler.OperationsGateway.Process(resultChannel, &operations.Request{
-		Type: operations.CreateRole,
-		CreateRole: &operations.CreateRoleRequest{
-			Guild:     guild,
-			RoleName:  roleName,
-			RoleColor: handler.RoleColor,
-		},
-	})
+	_, removedRoleIDs, ok := handler.swapRoleIDs(metadata, metadata.Member.Roles)
+	if !ok || len(removedRoleIDs) == 0 {
+		return false, nil
+	}
 
-	result := <-resultChannel
+	member, err := metadata.Session.GuildMember(metadata.Guild.ID, metadata.Member.User.ID)
+	if err != nil {
+		return false, nil
+	}
 
-	switch typedResult := result.(type) {
-	case *discordgo.Role:
-		return typedResult, nil
-	case error:
-		return nil, typedResult
-	default:
-		return nil, fmt.Errorf("unrecognized operations result type: %T", typedResult)
+	roleIDs, removedRoleIDs, ok := handler.swapRoleIDs(metadata, member.Roles)
+	if !ok || len(removedRoleIDs) == 0 {
+		return false, nil
 	}
+
+	err = handler.setRoles(ctx, operations.PriorityInteractive, metadata.Guild, metadata.Member.User.ID, roleIDs)
+	if err != nil {
+		return true, err
+	}
+
+	for _, role := range metadata.EphemeralRoles {
+		handler.Capacity.Touch(metadata.Guild.ID, role.ID)
+	}
+
+	for _, roleID := range removedRoleIDs {
+		handler.Capacity.Touch(metadata.Guild.ID, roleID)
+		handler.scheduleEmptyRoleDeletion(metadata.Session, metadata.Guild, roleID)
+	}
+
+	return true, nil
 }
 
-func (handler *Handler) addEphemeralRole(metadata *voiceStateUpdateMetadata) error {
-	return operations.AddRoleToMember(metadata.Session, metadata.Guild.ID, metadata.Member.User.ID, metadata.EphemeralRole.ID)
+// swapRoleIDs returns the role IDs the member holding the provided
+// memberRoles holds after swapping their ephemeral roles, and the IDs of the
+// ephemeral roles removed by the swap. It reports false if the bot cannot see
+// all of the provided memberRoles.
+func (handler *Handler) swapRoleIDs(
+	metadata *voiceStateUpdateMetadata,
+	memberRoles []string,
+) (roleIDs, removedRoleIDs []string, ok bool) {
+	rolePrefix := handler.GuildSettings(metadata.Guild.ID).RolePrefix
+
+	roleIDs = make([]string, 0, len(memberRoles)+len(metadata.EphemeralRoles))
+	removedRoleIDs = make([]string, 0)
+
+	for _, roleID := range memberRoles {
+		role, err := metadata.Session.State.Role(metadata.Guild.ID, roleID)
+		if err != nil {
+			return nil, nil, false
+		}
+
+		// The ephemeral roles for the member's channel are added back below
+		if metadata.hasEphemeralRole(roleID) {
+			continue
+		}
+
+		if strings.HasPrefix(role.Name, rolePrefix) {
+			removedRoleIDs = append(removedRoleIDs, roleID)
+			continue
+		}
+
+		roleIDs = append(roleIDs, roleID)
+	}
+
+	for _, role := range metadata.EphemeralRoles {
+		roleIDs = append(roleIDs, role.ID)
+	}
+
+	return roleIDs, removedRoleIDs, true
 }
 
-func (handler *Handler) removeEphemeralRoles(metadata *voiceStateUpdateMetadata) error {
+func (handler *Handler) removeEphemeralRoles(ctx context.Context, metadata *voiceStateUpdateMetadata) error {
 	var err error
 
 	for _, roleID := range metadata.Member.Roles {
-		removeError := handler.removeEphemeralRole(metadata, roleID)
+		removeError := handler.removeEphemeralRole(ctx, metadata, roleID)
 		if removeError != nil {
 			if err == nil {
 				err = removeError
@@ -298,7 +536,7 @@ func (handler *Handler) removeEphemeralRoles(metadata *voiceStateUpdateMetadata)
 	return err
 }
 
-func (handler *Handler) removeEphemeralRole(metadata *voiceStateUpdateMetadata, roleID string) error {
+func (handler *Handler) removeEphemeralRole(ctx context.Context, metadata *voiceStateUpdateMetadata, roleID string) error {
 	role, err := metadata.Session.State.Role(metadata.Guild.ID, roleID)
 	if err != nil {
 		if errors.Is(err, discordgo.ErrStateNotFound) {
@@ -308,16 +546,21 @@ func (handler *Handler) removeEphemeralRole(metadata *voiceStateUpdateMetadata,
 		return fmt.Errorf("unable to remove ephemeral role: %w", err)
 	}
 
-	if !strings.HasPrefix(role.Name, handler.RolePrefix) {
+	if !strings.HasPrefix(role.Name, handler.GuildSettings(metadata.Guild.ID).RolePrefix) || metadata.hasEphemeralRole(role.ID) {
 		return nil
 	}
 
-	err = operations.RemoveRoleFromMember(metadata.Session, metadata.Guild.ID, metadata.Member.User.ID, role.ID)
+	err = handler.removeRole(ctx, operations.PriorityInteractive, metadata.Guild, metadata.Member.User.ID, role.ID)
 	if err != nil {
 		if !operations.IsForbiddenResponse(err) {
 			return err
 		}
+
+		return nil
 	}
 
+	handler.Capacity.Touch(metadata.Guild.ID, role.ID)
+	handler.scheduleEmptyRoleDeletion(metadata.Session, metadata.Guild, role.ID)
+
 	return nil
 }
@@ -1,6 +1,10 @@
 package callbacks_test
 
 import (
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -8,9 +12,12 @@ import (
 	"github.com/ewohltman/discordgo-mock/mockconstants"
 
 	"github.com/ewohltman/ephemeral-roles/internal/pkg/callbacks"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/capacity"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/config"
 	"github.com/ewohltman/ephemeral-roles/internal/pkg/mock"
 	"github.com/ewohltman/ephemeral-roles/internal/pkg/monitor"
 	"github.com/ewohltman/ephemeral-roles/internal/pkg/operations"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/rolemap"
 	"github.com/ewohltman/ephemeral-roles/internal/pkg/tracer"
 )
 
@@ -43,6 +50,8 @@ func TestHandler_VoiceStateUpdate(t *testing.T) {
 		ContextTimeout:          time.Second,
 		VoiceStateUpdateCounter: monitor.VoiceStateUpdateCounter(&monitor.Config{Log: log}),
 		OperationsGateway:       operations.NewGateway(session),
+		RoleMap:                 rolemap.New(),
+		Capacity:                capacity.NewManager(),
 	}
 
 	type testCase struct {
@@ -115,3 +124,395 @@ func sendUpdate(session *discordgo.Session, handler *callbacks.Handler, guildID,
 		},
 	})
 }
+
+type methodCounter struct {
+	next    http.RoundTripper
+	mutex   sync.Mutex
+	methods map[string]int
+}
+
+func (counter *methodCounter) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.Contains(req.URL.Path, "/members/") {
+		counter.mutex.Lock()
+		counter.methods[req.Method]++
+		counter.mutex.Unlock()
+	}
+
+	return counter.next.RoundTrip(req)
+}
+
+func (counter *methodCounter) reset() map[string]int {
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+
+	methods := counter.methods
+	counter.methods = make(map[string]int)
+
+	return methods
+}
+
+func TestHandler_VoiceStateUpdate_swap(t *testing.T) {
+	jaegerTracer, jaegerCloser, err := tracer.New("test")
+	if err != nil {
+		t.Fatalf("Error creating Jaeger tracer: %s", err)
+	}
+
+	defer func() {
+		closeErr := jaegerCloser.Close()
+		if closeErr != nil {
+			t.Errorf("Error closing Jaeger tracer: %s", err)
+		}
+	}()
+
+	session, err := mock.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counter := &methodCounter{next: session.Client.Transport, methods: make(map[string]int)}
+	session.Client.Transport = counter
+
+	log := mock.NewLogger()
+
+	handler := &callbacks.Handler{
+		Log:                     log,
+		BotName:                 "testBot",
+		BotKeyword:              "testKeyword",
+		RolePrefix:              "{eph}",
+		JaegerTracer:            jaegerTracer,
+		VoiceStateUpdateCounter: monitor.VoiceStateUpdateCounter(&monitor.Config{Log: log}),
+		OperationsGateway:       operations.NewGateway(session),
+		RoleMap:                 rolemap.New(),
+		Capacity:                capacity.NewManager(),
+	}
+
+	// Moving channels swaps ephemeral roles in a single request, made right
+	// after fetching the member
+	sendUpdate(session, handler, mockconstants.TestGuild, mockconstants.TestUser, mockconstants.TestChannel2)
+
+	methods := counter.reset()
+	if len(methods) != 2 || methods[http.MethodGet] != 1 || methods[http.MethodPatch] != 1 {
+		t.Errorf("Unexpected member requests for role swap: %v", methods)
+	}
+
+	roleID, found := handler.RoleMap.RoleID(mockconstants.TestGuild, mockconstants.TestChannel2)
+	if !found {
+		t.Fatalf("Ephemeral role not created for channel %s", mockconstants.TestChannel2)
+	}
+
+	member, err := session.State.Member(mockconstants.TestGuild, mockconstants.TestUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedRoles := []string{mockconstants.TestRole, roleID}
+	if !reflect.DeepEqual(member.Roles, expectedRoles) {
+		t.Errorf("Unexpected member roles after swap: %v", member.Roles)
+	}
+
+	// Roles the bot cannot see fall back to swapping roles one at a time
+	member.Roles = append(member.Roles, "unknownRole")
+
+	sendUpdate(session, handler, mockconstants.TestGuild, mockconstants.TestUser, mockconstants.TestChannel)
+
+	methods = counter.reset()
+	if methods[http.MethodPatch] != 0 || methods[http.MethodDelete] != 1 || methods[http.MethodPut] != 1 {
+		t.Errorf("Unexpected member requests for role swap fallback: %v", methods)
+	}
+}
+
+func TestHandler_VoiceStateUpdate_swapRefetch(t *testing.T) {
+	jaegerTracer, jaegerCloser, err := tracer.New("test")
+	if err != nil {
+		t.Fatalf("Error creating Jaeger tracer: %s", err)
+	}
+
+	defer func() {
+		closeErr := jaegerCloser.Close()
+		if closeErr != nil {
+			t.Errorf("Error closing Jaeger tracer: %s", err)
+		}
+	}()
+
+	session, err := mock.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = session.State.RoleAdd(mockconstants.TestGuild, &discordgo.Role{ID: "externalRole", Name: "externalRole"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	member, err := session.State.Member(mockconstants.TestGuild, mockconstants.TestUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another bot adds a role to the member after it was cached, just before
+	// the member is fetched for the swap
+	session.Client.Transport = &memberFetchHook{
+		next: session.Client.Transport,
+		hook: func() {
+			member.Roles = append(member.Roles, "externalRole")
+		},
+	}
+
+	log := mock.NewLogger()
+
+	handler := &callbacks.Handler{
+		Log:                     log,
+		BotName:                 "testBot",
+		BotKeyword:              "testKeyword",
+		RolePrefix:              "{eph}",
+		JaegerTracer:            jaegerTracer,
+		VoiceStateUpdateCounter: monitor.VoiceStateUpdateCounter(&monitor.Config{Log: log}),
+		OperationsGateway:       operations.NewGateway(session),
+		RoleMap:                 rolemap.New(),
+		Capacity:                capacity.NewManager(),
+	}
+
+	sendUpdate(session, handler, mockconstants.TestGuild, mockconstants.TestUser, mockconstants.TestChannel2)
+
+	roleID, found := handler.RoleMap.RoleID(mockconstants.TestGuild, mockconstants.TestChannel2)
+	if !found {
+		t.Fatalf("Ephemeral role not created for channel %s", mockconstants.TestChannel2)
+	}
+
+	assertMemberRoles(t, session, []string{mockconstants.TestRole, "externalRole", roleID})
+}
+
+type memberFetchHook struct {
+	next http.RoundTripper
+	hook func()
+}
+
+func (memberFetchHook *memberFetchHook) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == http.MethodGet && strings.Contains(req.URL.Path, "/members/") {
+		memberFetchHook.hook()
+	}
+
+	return memberFetchHook.next.RoundTrip(req)
+}
+
+func TestHandler_VoiceStateUpdate_deadlineExceeded(t *testing.T) {
+	jaegerTracer, jaegerCloser, err := tracer.New("test")
+	if err != nil {
+		t.Fatalf("Error creating Jaeger tracer: %s", err)
+	}
+
+	defer func() {
+		closeErr := jaegerCloser.Close()
+		if closeErr != nil {
+			t.Errorf("Error closing Jaeger tracer: %s", err)
+		}
+	}()
+
+	session, err := mock.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roundTripper := &blockingRoundTripper{
+		next:    session.Client.Transport,
+		blocked: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	session.Client.Transport = roundTripper
+
+	defer close(roundTripper.release)
+
+	log := mock.NewLogger()
+
+	handler := &callbacks.Handler{
+		Log:                     log,
+		BotName:                 "testBot",
+		BotKeyword:              "testKeyword",
+		RolePrefix:              "{eph}",
+		JaegerTracer:            jaegerTracer,
+		ContextTimeout:          10 * time.Millisecond,
+		VoiceStateUpdateCounter: monitor.VoiceStateUpdateCounter(&monitor.Config{Log: log}),
+		OperationsGateway:       operations.NewGateway(session),
+		RoleMap:                 rolemap.New(),
+		Capacity:                capacity.NewManager(),
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		sendUpdate(session, handler, mockconstants.TestGuild, mockconstants.TestUser, mockconstants.TestChannel2)
+	}()
+
+	<-roundTripper.blocked
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("VoiceStateUpdate did not return after its context deadline passed")
+	}
+
+	member, err := session.State.Member(mockconstants.TestGuild, mockconstants.TestUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(member.Roles) != 2 {
+		t.Errorf("Unexpected member roles after deadline exceeded: %v", member.Roles)
+	}
+}
+
+func TestHandler_VoiceStateUpdate_insufficientRolePermissions(t *testing.T) {
+	jaegerTracer, jaegerCloser, err := tracer.New("test")
+	if err != nil {
+		t.Fatalf("Error creating Jaeger tracer: %s", err)
+	}
+
+	defer func() {
+		closeErr := jaegerCloser.Close()
+		if closeErr != nil {
+			t.Errorf("Error closing Jaeger tracer: %s", err)
+		}
+	}()
+
+	session, err := mock.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counter := &methodCounter{next: session.Client.Transport, methods: make(map[string]int)}
+	session.Client.Transport = counter
+
+	log := mock.NewLogger()
+
+	handler := &callbacks.Handler{
+		Log:                     log,
+		BotName:                 "testBot",
+		BotKeyword:              "testKeyword",
+		RolePrefix:              "{eph}",
+		JaegerTracer:            jaegerTracer,
+		VoiceStateUpdateCounter: monitor.VoiceStateUpdateCounter(&monitor.Config{Log: log}),
+		OperationsGateway:       operations.NewGateway(session),
+		RoleMap:                 rolemap.New(),
+		Capacity:                capacity.NewManager(),
+	}
+
+	botRole, err := session.State.Role(mockconstants.TestGuild, mock.BotRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	botRole.Permissions = discordgo.PermissionViewChannel
+
+	sendUpdate(session, handler, mockconstants.TestGuild, mockconstants.TestUser, mockconstants.TestChannel2)
+
+	if _, found := handler.RoleMap.RoleID(mockconstants.TestGuild, mockconstants.TestChannel2); found {
+		t.Error("Unexpected ephemeral role created without Manage Roles")
+	}
+
+	if methods := counter.reset(); len(methods) != 0 {
+		t.Errorf("Unexpected member requests without Manage Roles: %v", methods)
+	}
+}
+
+func TestHandler_VoiceStateUpdate_excludedChannel(t *testing.T) {
+	jaegerTracer, jaegerCloser, err := tracer.New("test")
+	if err != nil {
+		t.Fatalf("Error creating Jaeger tracer: %s", err)
+	}
+
+	defer func() {
+		closeErr := jaegerCloser.Close()
+		if closeErr != nil {
+			t.Errorf("Error closing Jaeger tracer: %s", err)
+		}
+	}()
+
+	testCases := []struct {
+		name        string
+		guildConfig *config.GuildConfig
+		afkChannel  string
+	}{
+		{
+			name: "exclude rule",
+			guildConfig: &config.GuildConfig{
+				GuildID:         mockconstants.TestGuild,
+				ExcludeChannels: []config.ChannelRule{{ChannelID: mockconstants.TestChannel2}},
+			},
+		},
+		{
+			name: "include rule",
+			guildConfig: &config.GuildConfig{
+				GuildID:         mockconstants.TestGuild,
+				IncludeChannels: []config.ChannelRule{{Pattern: mockconstants.TestChannel}},
+			},
+		},
+		{
+			name:       "AFK channel",
+			afkChannel: mockconstants.TestChannel2,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			session, err := mock.NewSession()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			store := config.NewMemoryStore()
+
+			if testCase.guildConfig != nil {
+				err = store.Put(testCase.guildConfig)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			guild, err := session.State.Guild(mockconstants.TestGuild)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			guild.AfkChannelID = testCase.afkChannel
+
+			counter := &methodCounter{next: session.Client.Transport, methods: make(map[string]int)}
+			session.Client.Transport = counter
+
+			log := mock.NewLogger()
+
+			handler := &callbacks.Handler{
+				Log:                     log,
+				BotName:                 "testBot",
+				BotKeyword:              "testKeyword",
+				RolePrefix:              "{eph}",
+				JaegerTracer:            jaegerTracer,
+				VoiceStateUpdateCounter: monitor.VoiceStateUpdateCounter(&monitor.Config{Log: log}),
+				OperationsGateway:       operations.NewGateway(session),
+				RoleMap:                 rolemap.New(),
+				Capacity:                capacity.NewManager(),
+				RecentErrors:            callbacks.NewRecentErrors(10),
+				GuildConfigs:            store,
+			}
+
+			// Joining an excluded channel removes the ephemeral role of the
+			// previous channel without creating one
+			sendUpdate(session, handler, mockconstants.TestGuild, mockconstants.TestUser, mockconstants.TestChannel2)
+
+			if _, found := handler.RoleMap.RoleID(mockconstants.TestGuild, mockconstants.TestChannel2); found {
+				t.Error("Unexpected ephemeral role created for excluded channel")
+			}
+
+			methods := counter.reset()
+			if len(methods) != 1 || methods[http.MethodDelete] != 1 {
+				t.Errorf("Unexpected member requests after joining excluded channel: %v", methods)
+			}
+
+			if recentErrors := handler.RecentErrors.Guild(mockconstants.TestGuild); len(recentErrors) != 0 {
+				t.Errorf("Unexpected recent errors for excluded channel: %v", recentErrors)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+// Package capacity provides tracking of when ephemeral roles were last used
+// to choose which role to evict when a guild reaches its role capacity.
+package capacity
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Manager tracks when each ephemeral role was last used, partitioned by guild
+// ID.
+type Manager struct {
+	mutex    *sync.Mutex
+	lastUsed map[string]map[string]time.Time
+}
+
+// NewManager returns a new *Manager ready to track role usage.
+func NewManager() *Manager {
+	return &Manager{
+		mutex:    &sync.Mutex{},
+		lastUsed: make(map[string]map[string]time.Time),
+	}
+}
+
+// Touch records the role associated with the provided roleID as used now.
+func (manager *Manager) Touch(guildID, roleID string) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	roles, found := manager.lastUsed[guildID]
+	if !found {
+		roles = make(map[string]time.Time)
+		manager.lastUsed[guildID] = roles
+	}
+
+	roles[roleID] = time.Now()
+}
+
+// Forget stops tracking the role associated with the provided roleID.
+func (manager *Manager) Forget(guildID, roleID string) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	delete(manager.lastUsed[guildID], roleID)
+}
+
+// LastUsed returns when the role associated with the provided roleID was last
+// used. The zero time is returned for roles which have not been used since
+// tracking began.
+func (manager *Manager) LastUsed(guildID, roleID string) time.Time {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	return manager.lastUsed[guildID][roleID]
+}
+
+// LeastRecentlyUsed returns the least recently used of the provided
+// candidate role IDs. Roles which have not been used since tracking began are
+// considered the least recently used, ordered by role ID.
+func (manager *Manager) LeastRecentlyUsed(guildID string, candidates []string) (roleID string, found bool) {
+	if len(candidates) == 0 {
+		return "", false
+	}
+
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	roles := manager.lastUsed[guildID]
+	sorted := make([]string, len(candidates))
+
+	copy(sorted, candidates)
+
+	sort.Slice(sorted, func(i, j int) bool {
+		iLastUsed, jLastUsed := roles[sorted[i]], roles[sorted[j]]
+
+		if iLastUsed.Equal(jLastUsed) {
+			return sorted[i] < sorted[j]
+		}
+
+		return iLastUsed.Before(jLastUsed)
+	})
+
+	return sorted[0], true
+}
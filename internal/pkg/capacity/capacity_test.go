@@ -0,0 +1,57 @@
+package capacity_test
+
+import (
+	"testing"
+
+	"github.com/ewohltman/discordgo-mock/mockconstants"
+
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/capacity"
+)
+
+const (
+	testRole2 = mockconstants.TestRole + "2"
+	testRole3 = mockconstants.TestRole + "3"
+)
+
+func TestNewManager(t *testing.T) {
+	if capacity.NewManager() == nil {
+		t.Fatal("unexpected nil manager")
+	}
+}
+
+func TestManager_LeastRecentlyUsed(t *testing.T) {
+	manager := capacity.NewManager()
+
+	_, found := manager.LeastRecentlyUsed(mockconstants.TestGuild, nil)
+	if found {
+		t.Error("unexpected least recently used role for no candidates")
+	}
+
+	candidates := []string{testRole3, testRole2, mockconstants.TestRole}
+
+	manager.Touch(mockconstants.TestGuild, mockconstants.TestRole)
+	manager.Touch(mockconstants.TestGuild, testRole2)
+
+	roleID, found := manager.LeastRecentlyUsed(mockconstants.TestGuild, candidates)
+	if !found || roleID != testRole3 {
+		t.Errorf("unexpected least recently used role: %q", roleID)
+	}
+
+	manager.Touch(mockconstants.TestGuild, testRole3)
+
+	roleID, _ = manager.LeastRecentlyUsed(mockconstants.TestGuild, candidates)
+	if roleID != mockconstants.TestRole {
+		t.Errorf("unexpected least recently used role: %q", roleID)
+	}
+
+	manager.Forget(mockconstants.TestGuild, testRole2)
+
+	if !manager.LastUsed(mockconstants.TestGuild, testRole2).IsZero() {
+		t.Error("unexpected last used time for forgotten role")
+	}
+
+	roleID, _ = manager.LeastRecentlyUsed(mockconstants.TestGuild, candidates)
+	if roleID != testRole2 {
+		t.Errorf("unexpected least recently used role: %q", roleID)
+	}
+}
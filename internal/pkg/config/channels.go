@@ -0,0 +1,171 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Channel rule prefixes.
+const (
+	ChannelRulePrefix  = "channel:"
+	CategoryRulePrefix = "category:"
+	NameRulePrefix     = "name:"
+)
+
+// MaxChannelRules is the maximum number of include or exclude rules a guild
+// may have.
+const MaxChannelRules = 25
+
+// ErrEmptyChannelRule is returned when parsing an empty channel rule.
+var ErrEmptyChannelRule = errors.New("empty channel rule")
+
+// ChannelRule matches voice channels by their ID, by the ID of their
+// category, or by a case-insensitive name pattern as understood by
+// path.Match.
+type ChannelRule struct {
+	ChannelID  string `json:"channelID,omitempty"`
+	CategoryID string `json:"categoryID,omitempty"`
+	Pattern    string `json:"pattern,omitempty"`
+}
+
+// ParseChannelRule parses a channel rule of the form channel:<ID>,
+// category:<ID> or name:<pattern>. Channel mentions such as <#ID> are channel
+// rules, and anything else is a name pattern.
+func ParseChannelRule(value string) (ChannelRule, error) {
+	value = strings.TrimSpace(value)
+
+	var rule ChannelRule
+
+	switch {
+	case strings.HasPrefix(value, "<#") && strings.HasSuffix(value, ">"):
+		rule.ChannelID = strings.TrimSuffix(strings.TrimPrefix(value, "<#"), ">")
+	case strings.HasPrefix(value, ChannelRulePrefix):
+		rule.ChannelID = strings.TrimSpace(strings.TrimPrefix(value, ChannelRulePrefix))
+	case strings.HasPrefix(value, CategoryRulePrefix):
+		rule.CategoryID = strings.TrimSpace(strings.TrimPrefix(value, CategoryRulePrefix))
+	default:
+		rule.Pattern = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(value, NameRulePrefix)))
+
+		if _, err := path.Match(rule.Pattern, ""); err != nil {
+			return ChannelRule{}, fmt.Errorf("invalid name pattern %q: %w", rule.Pattern, err)
+		}
+	}
+
+	if rule == (ChannelRule{}) {
+		return ChannelRule{}, ErrEmptyChannelRule
+	}
+
+	return rule, nil
+}
+
+// ParseChannelRules parses a comma separated list of channel rules, as
+// understood by ParseChannelRule.
+func ParseChannelRules(value string) ([]ChannelRule, error) {
+	values := strings.Split(value, ",")
+
+	if len(values) > MaxChannelRules {
+		return nil, fmt.Errorf("at most %d channel rules are allowed, got %d", MaxChannelRules, len(values))
+	}
+
+	rules := make([]ChannelRule, len(values))
+
+	for i, ruleValue := range values {
+		rule, err := ParseChannelRule(ruleValue)
+		if err != nil {
+			return nil, err
+		}
+
+		rules[i] = rule
+	}
+
+	return rules, nil
+}
+
+// String returns the channel rule in the form understood by
+// ParseChannelRule.
+func (rule ChannelRule) String() string {
+	switch {
+	case rule.ChannelID != "":
+		return ChannelRulePrefix + rule.ChannelID
+	case rule.CategoryID != "":
+		return CategoryRulePrefix + rule.CategoryID
+	default:
+		return NameRulePrefix + rule.Pattern
+	}
+}
+
+// Matches returns whether the rule matches the provided channel.
+func (rule ChannelRule) Matches(channel *discordgo.Channel) bool {
+	switch {
+	case rule.ChannelID != "":
+		return rule.ChannelID == channel.ID
+	case rule.CategoryID != "":
+		return rule.CategoryID == channel.ParentID
+	default:
+		matched, err := path.Match(rule.Pattern, strings.ToLower(channel.Name))
+
+		return err == nil && matched
+	}
+}
+
+// ChannelEnabled returns whether the provided voice channel gets an ephemeral
+// role. Channels matching an exclude rule are disabled. If there are include
+// rules, only channels matching one of them are enabled. The AFK channel of
+// the guild, associated with the provided afkChannelID, is disabled unless an
+// include rule names it by ID.
+func (settings *Settings) ChannelEnabled(channel *discordgo.Channel, afkChannelID string) bool {
+	for _, rule := range settings.ExcludeChannels {
+		if rule.Matches(channel) {
+			return false
+		}
+	}
+
+	if channel.ID == afkChannelID {
+		for _, rule := range settings.IncludeChannels {
+			if rule.ChannelID == channel.ID {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if len(settings.IncludeChannels) == 0 {
+		return true
+	}
+
+	for _, rule := range settings.IncludeChannels {
+		if rule.Matches(channel) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// FormatChannelRules returns the provided channel rules as a comma separated
+// list, as understood by ParseChannelRules.
+func FormatChannelRules(rules []ChannelRule) string {
+	values := make([]string, len(rules))
+
+	for i, rule := range rules {
+		values[i] = rule.String()
+	}
+
+	return strings.Join(values, ", ")
+}
+
+func copyChannelRules(rules []ChannelRule) []ChannelRule {
+	if rules == nil {
+		return nil
+	}
+
+	rulesCopy := make([]ChannelRule, len(rules))
+	copy(rulesCopy, rules)
+
+	return rulesCopy
+}
@@ -0,0 +1,112 @@
+package config_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/config"
+)
+
+const (
+	testAFKChannel = "afkChannel"
+	testCategory   = "staffCategory"
+)
+
+func TestParseChannelRules(t *testing.T) {
+	rules, err := config.ParseChannelRules("channel:123, <#456>, category: 789, name:Music*, Staff ?")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []config.ChannelRule{
+		{ChannelID: "123"},
+		{ChannelID: "456"},
+		{CategoryID: "789"},
+		{Pattern: "music*"},
+		{Pattern: "staff ?"},
+	}
+
+	if !reflect.DeepEqual(rules, expected) {
+		t.Errorf("Unexpected rules: %+v", rules)
+	}
+
+	formatted := config.FormatChannelRules(rules)
+	if formatted != "channel:123, channel:456, category:789, name:music*, name:staff ?" {
+		t.Errorf("Unexpected formatted rules: %s", formatted)
+	}
+
+	reparsed, err := config.ParseChannelRules(formatted)
+	if err != nil || !reflect.DeepEqual(reparsed, expected) {
+		t.Errorf("Formatted rules do not parse to the same rules: %+v, %v", reparsed, err)
+	}
+
+	_, err = config.ParseChannelRules("channel:123,,name:music")
+	if !errors.Is(err, config.ErrEmptyChannelRule) {
+		t.Errorf("Expected empty rule error, got: %v", err)
+	}
+
+	_, err = config.ParseChannelRules("name:[music")
+	if err == nil {
+		t.Error("Expected invalid pattern error")
+	}
+}
+
+func TestSettings_ChannelEnabled(t *testing.T) {
+	general := &discordgo.Channel{ID: "general", Name: "General"}
+	music := &discordgo.Channel{ID: "music", Name: "Music Bot"}
+	staff := &discordgo.Channel{ID: "staff", Name: "Staff Room", ParentID: testCategory}
+	afk := &discordgo.Channel{ID: testAFKChannel, Name: "AFK"}
+
+	testCases := []struct {
+		name     string
+		include  []config.ChannelRule
+		exclude  []config.ChannelRule
+		expected map[*discordgo.Channel]bool
+	}{
+		{
+			name:     "no rules",
+			expected: map[*discordgo.Channel]bool{general: true, music: true, staff: true, afk: false},
+		},
+		{
+			name:     "exclude",
+			exclude:  []config.ChannelRule{{Pattern: "music*"}, {CategoryID: testCategory}},
+			expected: map[*discordgo.Channel]bool{general: true, music: false, staff: false, afk: false},
+		},
+		{
+			name:     "include",
+			include:  []config.ChannelRule{{ChannelID: general.ID}, {CategoryID: testCategory}},
+			expected: map[*discordgo.Channel]bool{general: true, music: false, staff: true, afk: false},
+		},
+		{
+			name:     "exclude wins over include",
+			include:  []config.ChannelRule{{Pattern: "*"}},
+			exclude:  []config.ChannelRule{{ChannelID: staff.ID}},
+			expected: map[*discordgo.Channel]bool{general: true, music: true, staff: false, afk: false},
+		},
+		{
+			name:     "include AFK channel by ID",
+			include:  []config.ChannelRule{{ChannelID: testAFKChannel}},
+			expected: map[*discordgo.Channel]bool{general: false, music: false, staff: false, afk: true},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			settings := &config.Settings{
+				IncludeChannels: testCase.include,
+				ExcludeChannels: testCase.exclude,
+			}
+
+			for channel, expected := range testCase.expected {
+				if settings.ChannelEnabled(channel, testAFKChannel) != expected {
+					t.Errorf("Expected channel %s enabled to be %t", channel.Name, expected)
+				}
+			}
+		})
+	}
+}
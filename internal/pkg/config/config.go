@@ -0,0 +1,193 @@
+// Package config provides per-guild configuration of the bot, falling back to
+// process-wide defaults for settings a guild has not customized.
+package config
+
+import "fmt"
+
+// Role modes, controlling which ephemeral roles members connected to a voice
+// channel get.
+const (
+	// RoleModeChannel gives members a role for their voice channel.
+	RoleModeChannel = "channel"
+
+	// RoleModeCategory gives members a role for the category of their voice
+	// channel. Members of voice channels without a category get a role for
+	// their voice channel instead.
+	RoleModeCategory = "category"
+
+	// RoleModeBoth gives members a role for their voice channel and a role
+	// for its category.
+	RoleModeBoth = "both"
+)
+
+// RoleModes are the valid role modes.
+func RoleModes() []string {
+	return []string{RoleModeChannel, RoleModeCategory, RoleModeBoth}
+}
+
+// Settings are the resolved settings of the bot in a guild.
+type Settings struct {
+	BotName         string
+	BotKeyword      string
+	RolePrefix      string
+	RoleColor       int
+	RoleHoist       bool
+	RoleMentionable bool
+	RoleMode        string
+	IncludeChannels []ChannelRule
+	ExcludeChannels []ChannelRule
+}
+
+// RoleName returns the name of the ephemeral role for the channel associated
+// with the provided channelName.
+func (settings *Settings) RoleName(channelName string) string {
+	return fmt.Sprintf("%s %s", settings.RolePrefix, channelName)
+}
+
+// ChannelRoles returns whether members get a role for their voice channel.
+func (settings *Settings) ChannelRoles() bool {
+	return settings.RoleMode != RoleModeCategory
+}
+
+// CategoryRoles returns whether members get a role for the category of their
+// voice channel.
+func (settings *Settings) CategoryRoles() bool {
+	return settings.RoleMode == RoleModeCategory || settings.RoleMode == RoleModeBoth
+}
+
+// GuildConfig is the configuration of a guild. Nil fields are not customized
+// and resolve to the defaults. Guilds have no channel rules by default.
+type GuildConfig struct {
+	GuildID         string        `json:"guildID"`
+	BotName         *string       `json:"botName,omitempty"`
+	BotKeyword      *string       `json:"botKeyword,omitempty"`
+	RolePrefix      *string       `json:"rolePrefix,omitempty"`
+	RoleColor       *int          `json:"roleColor,omitempty"`
+	RoleHoist       *bool         `json:"roleHoist,omitempty"`
+	RoleMentionable *bool         `json:"roleMentionable,omitempty"`
+	RoleMode        *string       `json:"roleMode,omitempty"`
+	IncludeChannels []ChannelRule `json:"includeChannels,omitempty"`
+	ExcludeChannels []ChannelRule `json:"excludeChannels,omitempty"`
+}
+
+// Resolve returns the provided defaults overridden by the settings the guild
+// has customized. A nil *GuildConfig resolves to the defaults.
+func (guildConfig *GuildConfig) Resolve(defaults *Settings) *Settings {
+	settings := *defaults
+
+	if guildConfig == nil {
+		return &settings
+	}
+
+	if guildConfig.BotName != nil {
+		settings.BotName = *guildConfig.BotName
+	}
+
+	if guildConfig.BotKeyword != nil {
+		settings.BotKeyword = *guildConfig.BotKeyword
+	}
+
+	if guildConfig.RolePrefix != nil {
+		settings.RolePrefix = *guildConfig.RolePrefix
+	}
+
+	if guildConfig.RoleColor != nil {
+		settings.RoleColor = *guildConfig.RoleColor
+	}
+
+	if guildConfig.RoleHoist != nil {
+		settings.RoleHoist = *guildConfig.RoleHoist
+	}
+
+	if guildConfig.RoleMentionable != nil {
+		settings.RoleMentionable = *guildConfig.RoleMentionable
+	}
+
+	if guildConfig.RoleMode != nil {
+		settings.RoleMode = *guildConfig.RoleMode
+	}
+
+	if guildConfig.IncludeChannels != nil {
+		settings.IncludeChannels = copyChannelRules(guildConfig.IncludeChannels)
+	}
+
+	if guildConfig.ExcludeChannels != nil {
+		settings.ExcludeChannels = copyChannelRules(guildConfig.ExcludeChannels)
+	}
+
+	return &settings
+}
+
+// Copy returns a deep copy of the GuildConfig.
+func (guildConfig *GuildConfig) Copy() *GuildConfig {
+	if guildConfig == nil {
+		return nil
+	}
+
+	guildConfigCopy := &GuildConfig{GuildID: guildConfig.GuildID}
+
+	if guildConfig.BotName != nil {
+		guildConfigCopy.BotName = String(*guildConfig.BotName)
+	}
+
+	if guildConfig.BotKeyword != nil {
+		guildConfigCopy.BotKeyword = String(*guildConfig.BotKeyword)
+	}
+
+	if guildConfig.RolePrefix != nil {
+		guildConfigCopy.RolePrefix = String(*guildConfig.RolePrefix)
+	}
+
+	if guildConfig.RoleColor != nil {
+		guildConfigCopy.RoleColor = Int(*guildConfig.RoleColor)
+	}
+
+	if guildConfig.RoleHoist != nil {
+		guildConfigCopy.RoleHoist = Bool(*guildConfig.RoleHoist)
+	}
+
+	if guildConfig.RoleMentionable != nil {
+		guildConfigCopy.RoleMentionable = Bool(*guildConfig.RoleMentionable)
+	}
+
+	if guildConfig.RoleMode != nil {
+		guildConfigCopy.RoleMode = String(*guildConfig.RoleMode)
+	}
+
+	guildConfigCopy.IncludeChannels = copyChannelRules(guildConfig.IncludeChannels)
+	guildConfigCopy.ExcludeChannels = copyChannelRules(guildConfig.ExcludeChannels)
+
+	return guildConfigCopy
+}
+
+// GuildConfigStore is an interface abstraction for storing the configuration
+// of guilds.
+type GuildConfigStore interface {
+	// Get returns the configuration of the guild associated with the
+	// provided guildID. Guilds without a stored configuration get an empty
+	// *GuildConfig.
+	Get(guildID string) (*GuildConfig, error)
+
+	// Put stores the provided configuration, replacing any stored for its
+	// guild.
+	Put(guildConfig *GuildConfig) error
+
+	// Delete removes the stored configuration of the guild associated with
+	// the provided guildID.
+	Delete(guildID string) error
+}
+
+// String returns a pointer to the provided string.
+func String(value string) *string {
+	return &value
+}
+
+// Int returns a pointer to the provided int.
+func Int(value int) *int {
+	return &value
+}
+
+// Bool returns a pointer to the provided bool.
+func Bool(value bool) *bool {
+	return &value
+}
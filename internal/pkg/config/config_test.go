@@ -0,0 +1,167 @@
+package config_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/config"
+)
+
+const testGuild = "testGuild"
+
+func testDefaults() *config.Settings {
+	return &config.Settings{
+		BotName:         "testBot",
+		BotKeyword:      "!eph",
+		RolePrefix:      "{eph}",
+		RoleColor:       16753920,
+		RoleHoist:       true,
+		RoleMentionable: true,
+		RoleMode:        config.RoleModeChannel,
+	}
+}
+
+func TestGuildConfig_Resolve(t *testing.T) {
+	defaults := testDefaults()
+
+	var nilGuildConfig *config.GuildConfig
+
+	if !reflect.DeepEqual(nilGuildConfig.Resolve(defaults), defaults) {
+		t.Error("Expected nil guild config to resolve to the defaults")
+	}
+
+	guildConfig := &config.GuildConfig{
+		GuildID:         testGuild,
+		RolePrefix:      config.String("[voice]"),
+		RoleColor:       config.Int(0),
+		RoleHoist:       config.Bool(false),
+		RoleMode:        config.String(config.RoleModeBoth),
+		IncludeChannels: []config.ChannelRule{{CategoryID: "voiceCategory"}},
+	}
+
+	settings := guildConfig.Resolve(defaults)
+
+	expected := testDefaults()
+	expected.RolePrefix = "[voice]"
+	expected.RoleColor = 0
+	expected.RoleHoist = false
+	expected.RoleMode = config.RoleModeBoth
+	expected.IncludeChannels = []config.ChannelRule{{CategoryID: "voiceCategory"}}
+
+	if !reflect.DeepEqual(settings, expected) {
+		t.Errorf("Unexpected settings: %+v", settings)
+	}
+
+	if !reflect.DeepEqual(defaults, testDefaults()) {
+		t.Error("Resolve modified the defaults")
+	}
+
+	if settings.RoleName("General") != "[voice] General" {
+		t.Errorf("Unexpected role name: %s", settings.RoleName("General"))
+	}
+}
+
+func TestGuildConfig_Copy(t *testing.T) {
+	guildConfig := &config.GuildConfig{
+		GuildID:         testGuild,
+		BotName:         config.String("name"),
+		BotKeyword:      config.String("keyword"),
+		RolePrefix:      config.String("prefix"),
+		RoleColor:       config.Int(1),
+		RoleHoist:       config.Bool(true),
+		RoleMentionable: config.Bool(false),
+		RoleMode:        config.String(config.RoleModeCategory),
+		ExcludeChannels: []config.ChannelRule{{Pattern: "music*"}},
+	}
+
+	guildConfigCopy := guildConfig.Copy()
+
+	if !reflect.DeepEqual(guildConfigCopy, guildConfig) {
+		t.Errorf("Unexpected copy: %+v", guildConfigCopy)
+	}
+
+	*guildConfigCopy.RolePrefix = "changed"
+	*guildConfigCopy.RoleHoist = false
+	guildConfigCopy.ExcludeChannels[0].Pattern = "changed"
+
+	if *guildConfig.RolePrefix != "prefix" || !*guildConfig.RoleHoist || guildConfig.ExcludeChannels[0].Pattern != "music*" {
+		t.Error("Copy shares fields with the original")
+	}
+}
+
+func TestSettings_RoleMode(t *testing.T) {
+	testCases := []struct {
+		roleMode      string
+		channelRoles  bool
+		categoryRoles bool
+	}{
+		{roleMode: "", channelRoles: true, categoryRoles: false},
+		{roleMode: config.RoleModeChannel, channelRoles: true, categoryRoles: false},
+		{roleMode: config.RoleModeCategory, channelRoles: false, categoryRoles: true},
+		{roleMode: config.RoleModeBoth, channelRoles: true, categoryRoles: true},
+	}
+
+	for _, testCase := range testCases {
+		settings := &config.Settings{RoleMode: testCase.roleMode}
+
+		if settings.ChannelRoles() != testCase.channelRoles {
+			t.Errorf("Unexpected channel roles for role mode %q: %t", testCase.roleMode, settings.ChannelRoles())
+		}
+
+		if settings.CategoryRoles() != testCase.categoryRoles {
+			t.Errorf("Unexpected category roles for role mode %q: %t", testCase.roleMode, settings.CategoryRoles())
+		}
+	}
+}
+
+// testStore exercises the GuildConfigStore contract.
+func testStore(t *testing.T, store config.GuildConfigStore) {
+	t.Helper()
+
+	guildConfig, err := store.Get(testGuild)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(guildConfig, &config.GuildConfig{GuildID: testGuild}) {
+		t.Errorf("Expected empty guild config, got: %+v", guildConfig)
+	}
+
+	guildConfig.RolePrefix = config.String("[voice]")
+
+	err = store.Put(guildConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Changes to a stored config are not visible until it is Put again
+	*guildConfig.RolePrefix = "changed"
+
+	stored, err := store.Get(testGuild)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stored.RolePrefix == nil || *stored.RolePrefix != "[voice]" {
+		t.Errorf("Unexpected stored guild config: %+v", stored)
+	}
+
+	err = store.Put(&config.GuildConfig{})
+	if err == nil {
+		t.Error("Expected error storing guild config without guild ID")
+	}
+
+	err = store.Delete(testGuild)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err = store.Get(testGuild)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stored.RolePrefix != nil {
+		t.Errorf("Expected deleted guild config, got: %+v", stored)
+	}
+}
@@ -0,0 +1,162 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/atomicfile"
+)
+
+const filePermissions = 0o600
+
+//nolint:gochecknoglobals // override stdlib json package
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+// FileStore is a GuildConfigStore persisting guild configurations to a JSON
+// file on disk. Configurations are kept in memory and the file is rewritten
+// on every change, so it is meant for the small number of guilds that
+// customize the bot rather than as a general database.
+type FileStore struct {
+	Path string
+
+	mutex  *sync.RWMutex
+	guilds map[string]*GuildConfig
+}
+
+// NewFileStore returns a new *FileStore persisting to the file at the
+// provided path, loading the configurations already stored there. Loaded
+// configurations are validated like changes made with the config command, so
+// a hand-edited file cannot set invalid settings. The file is created on the
+// first change if it does not exist.
+func NewFileStore(path string) (*FileStore, error) {
+	store := &FileStore{
+		Path:   path,
+		mutex:  &sync.RWMutex{},
+		guilds: make(map[string]*GuildConfig),
+	}
+
+	data, err := ioutil.ReadFile(filepath.Clean(path))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return store, nil
+		}
+
+		return nil, fmt.Errorf("unable to read guild config file: %w", err)
+	}
+
+	var guildConfigs []*GuildConfig
+
+	err = json.Unmarshal(data, &guildConfigs)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse guild config file: %w", err)
+	}
+
+	for _, guildConfig := range guildConfigs {
+		if guildConfig == nil || guildConfig.GuildID == "" {
+			continue
+		}
+
+		err = guildConfig.Validate()
+		if err != nil {
+			return nil, fmt.Errorf("unable to load guild config file: %w", err)
+		}
+
+		store.guilds[guildConfig.GuildID] = guildConfig
+	}
+
+	return store, nil
+}
+
+// Get satisfies the GuildConfigStore interface.
+func (store *FileStore) Get(guildID string) (*GuildConfig, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	guildConfig, found := store.guilds[guildID]
+	if !found {
+		return &GuildConfig{GuildID: guildID}, nil
+	}
+
+	return guildConfig.Copy(), nil
+}
+
+// Put satisfies the GuildConfigStore interface.
+func (store *FileStore) Put(guildConfig *GuildConfig) error {
+	if guildConfig == nil || guildConfig.GuildID == "" {
+		return fmt.Errorf("unable to store guild config: missing guild ID")
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	previous, found := store.guilds[guildConfig.GuildID]
+
+	store.guilds[guildConfig.GuildID] = guildConfig.Copy()
+
+	err := store.save()
+	if err != nil {
+		if found {
+			store.guilds[guildConfig.GuildID] = previous
+		} else {
+			delete(store.guilds, guildConfig.GuildID)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// Delete satisfies the GuildConfigStore interface.
+func (store *FileStore) Delete(guildID string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	previous, found := store.guilds[guildID]
+	if !found {
+		return nil
+	}
+
+	delete(store.guilds, guildID)
+
+	err := store.save()
+	if err != nil {
+		store.guilds[guildID] = previous
+
+		return err
+	}
+
+	return nil
+}
+
+// save writes the configurations to the store's file.
+func (store *FileStore) save() error {
+	guildConfigs := make([]*GuildConfig, 0, len(store.guilds))
+
+	for _, guildConfig := range store.guilds {
+		guildConfigs = append(guildConfigs, guildConfig)
+	}
+
+	sort.Slice(guildConfigs, func(i, j int) bool {
+		return guildConfigs[i].GuildID < guildConfigs[j].GuildID
+	})
+
+	data, err := json.MarshalIndent(guildConfigs, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to encode guild configs: %w", err)
+	}
+
+	err = atomicfile.WriteFile(store.Path, data, filePermissions)
+	if err != nil {
+		return fmt.Errorf("unable to write guild config file: %w", err)
+	}
+
+	return nil
+}
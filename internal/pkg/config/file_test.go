@@ -0,0 +1,114 @@
+package config_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/config"
+)
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "guilds.json")
+
+	store, err := config.NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testStore(t, store)
+
+	err = store.Put(&config.GuildConfig{GuildID: testGuild, BotKeyword: config.String("!voice")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := config.NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	guildConfig, err := reloaded.Get(testGuild)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if guildConfig.BotKeyword == nil || *guildConfig.BotKeyword != "!voice" {
+		t.Errorf("Unexpected reloaded guild config: %+v", guildConfig)
+	}
+
+	matches, err := filepath.Glob(filepath.Join(filepath.Dir(path), "*.tmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(matches) != 0 {
+		t.Errorf("Unexpected temporary files left behind: %v", matches)
+	}
+}
+
+func TestNewFileStore_invalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "guilds.json")
+
+	err := ioutil.WriteFile(path, []byte("not json"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = config.NewFileStore(path)
+	if err == nil {
+		t.Error("Expected error loading invalid guild config file")
+	}
+}
+
+func TestNewFileStore_invalidSettings(t *testing.T) {
+	testCases := map[string]string{
+		"empty prefix":    `[{"guildID": "testGuild", "rolePrefix": ""}]`,
+		"long prefix":     `[{"guildID": "testGuild", "rolePrefix": "123456789012345678901234567890123"}]`,
+		"color range":     `[{"guildID": "testGuild", "roleColor": 16777216}]`,
+		"negative color":  `[{"guildID": "testGuild", "roleColor": -1}]`,
+		"role mode":       `[{"guildID": "testGuild", "roleMode": "server"}]`,
+		"keyword spaces":  `[{"guildID": "testGuild", "botKeyword": "two words"}]`,
+		"empty rule":      `[{"guildID": "testGuild", "includeChannels": [{}]}]`,
+		"invalid pattern": `[{"guildID": "testGuild", "excludeChannels": [{"pattern": "["}]}]`,
+	}
+
+	for name, data := range testCases {
+		data := data
+
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "guilds.json")
+
+			err := ioutil.WriteFile(path, []byte(data), 0o600)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = config.NewFileStore(path)
+			if err == nil {
+				t.Error("Expected error loading guild config file with invalid settings")
+			}
+		})
+	}
+}
+
+func TestFileStore_writeError(t *testing.T) {
+	store, err := config.NewFileStore(filepath.Join(t.TempDir(), "missing", "guilds.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Put(&config.GuildConfig{GuildID: testGuild, BotName: config.String("name")})
+	if err == nil {
+		t.Fatal("Expected error writing guild config file to a missing directory")
+	}
+
+	guildConfig, err := store.Get(testGuild)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if guildConfig.BotName != nil {
+		t.Error("Expected failed Put to be rolled back")
+	}
+}
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"fmt"
+	"sync"
+)
+
+// MemoryStore is a GuildConfigStore keeping guild configurations in memory.
+// Configurations are lost when the process exits.
+type MemoryStore struct {
+	mutex  *sync.RWMutex
+	guilds map[string]*GuildConfig
+}
+
+// NewMemoryStore returns a new, empty *MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		mutex:  &sync.RWMutex{},
+		guilds: make(map[string]*GuildConfig),
+	}
+}
+
+// Get satisfies the GuildConfigStore interface.
+func (store *MemoryStore) Get(guildID string) (*GuildConfig, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	guildConfig, found := store.guilds[guildID]
+	if !found {
+		return &GuildConfig{GuildID: guildID}, nil
+	}
+
+	return guildConfig.Copy(), nil
+}
+
+// Put satisfies the GuildConfigStore interface.
+func (store *MemoryStore) Put(guildConfig *GuildConfig) error {
+	if guildConfig == nil || guildConfig.GuildID == "" {
+		return fmt.Errorf("unable to store guild config: missing guild ID")
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.guilds[guildConfig.GuildID] = guildConfig.Copy()
+
+	return nil
+}
+
+// Delete satisfies the GuildConfigStore interface.
+func (store *MemoryStore) Delete(guildID string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	delete(store.guilds, guildID)
+
+	return nil
+}
@@ -0,0 +1,11 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/config"
+)
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, config.NewMemoryStore())
+}
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// MaxRolePrefixLength is the maximum length of a role prefix, leaving
+	// room for the channel name in Discord's 100 character role names.
+	MaxRolePrefixLength = 32
+
+	// MaxBotKeywordLength is the maximum length of a bot keyword.
+	MaxBotKeywordLength = 32
+
+	// MaxRoleColor is the largest role color, white.
+	MaxRoleColor = 0xffffff
+)
+
+// ValidateRolePrefix returns an error if the provided role prefix is empty,
+// has surrounding whitespace or is longer than MaxRolePrefixLength.
+func ValidateRolePrefix(rolePrefix string) error {
+	switch {
+	case strings.TrimSpace(rolePrefix) == "":
+		return fmt.Errorf("must not be empty")
+	case strings.TrimSpace(rolePrefix) != rolePrefix:
+		return fmt.Errorf("must not begin or end with spaces")
+	case utf8.RuneCountInString(rolePrefix) > MaxRolePrefixLength:
+		return fmt.Errorf("must be at most %d characters", MaxRolePrefixLength)
+	}
+
+	return nil
+}
+
+// ValidateRoleColor returns an error if the provided role color is not
+// between black and MaxRoleColor.
+func ValidateRoleColor(roleColor int) error {
+	if roleColor < 0 || roleColor > MaxRoleColor {
+		return fmt.Errorf("must be between #000000 and #%06x, got %d", MaxRoleColor, roleColor)
+	}
+
+	return nil
+}
+
+// ValidateRoleMode returns an error if the provided role mode is not one of
+// RoleModes.
+func ValidateRoleMode(roleMode string) error {
+	for _, validMode := range RoleModes() {
+		if roleMode == validMode {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("must be one of %s, got %q", strings.Join(RoleModes(), ", "), roleMode)
+}
+
+// ValidateBotKeyword returns an error if the provided bot keyword is not a
+// single word of at most MaxBotKeywordLength characters, as messages are
+// matched to it by their first word.
+func ValidateBotKeyword(botKeyword string) error {
+	switch {
+	case botKeyword == "":
+		return fmt.Errorf("must not be empty")
+	case strings.IndexFunc(botKeyword, unicode.IsSpace) >= 0:
+		return fmt.Errorf("must not contain spaces")
+	case utf8.RuneCountInString(botKeyword) > MaxBotKeywordLength:
+		return fmt.Errorf("must be at most %d characters", MaxBotKeywordLength)
+	}
+
+	return nil
+}
+
+// ValidateChannelRules returns an error if there are more than
+// MaxChannelRules of the provided rules, or if any of them is empty or has an
+// invalid name pattern.
+func ValidateChannelRules(rules []ChannelRule) error {
+	if len(rules) > MaxChannelRules {
+		return fmt.Errorf("at most %d channel rules are allowed, got %d", MaxChannelRules, len(rules))
+	}
+
+	for _, rule := range rules {
+		if rule == (ChannelRule{}) {
+			return ErrEmptyChannelRule
+		}
+
+		if _, err := path.Match(rule.Pattern, ""); err != nil {
+			return fmt.Errorf("invalid name pattern %q: %w", rule.Pattern, err)
+		}
+	}
+
+	return nil
+}
+
+// Validate returns an error if any setting the guild has customized is
+// invalid, applying the same checks as changing the setting does.
+func (guildConfig *GuildConfig) Validate() error {
+	if guildConfig.BotKeyword != nil {
+		err := ValidateBotKeyword(*guildConfig.BotKeyword)
+		if err != nil {
+			return guildConfig.invalidSetting("bot keyword", err)
+		}
+	}
+
+	if guildConfig.RolePrefix != nil {
+		err := ValidateRolePrefix(*guildConfig.RolePrefix)
+		if err != nil {
+			return guildConfig.invalidSetting("role prefix", err)
+		}
+	}
+
+	if guildConfig.RoleColor != nil {
+		err := ValidateRoleColor(*guildConfig.RoleColor)
+		if err != nil {
+			return guildConfig.invalidSetting("role color", err)
+		}
+	}
+
+	if guildConfig.RoleMode != nil {
+		err := ValidateRoleMode(*guildConfig.RoleMode)
+		if err != nil {
+			return guildConfig.invalidSetting("role mode", err)
+		}
+	}
+
+	err := ValidateChannelRules(guildConfig.IncludeChannels)
+	if err != nil {
+		return guildConfig.invalidSetting("include channels", err)
+	}
+
+	err = ValidateChannelRules(guildConfig.ExcludeChannels)
+	if err != nil {
+		return guildConfig.invalidSetting("exclude channels", err)
+	}
+
+	return nil
+}
+
+func (guildConfig *GuildConfig) invalidSetting(setting string, err error) error {
+	return fmt.Errorf("invalid %s for guild %s: %w", setting, guildConfig.GuildID, err)
+}
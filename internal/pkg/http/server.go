@@ -18,8 +18,10 @@ import (
 
 // Supported endpoints.
 const (
-	RootEndpoint   = "/"
-	GuildsEndpoint = "/guilds"
+	RootEndpoint             = "/"
+	GuildsEndpoint           = "/guilds"
+	GarbageCollectorEndpoint = "/gc"
+	CircuitBreakerEndpoint   = "/breakers"
 )
 
 const (
@@ -61,8 +63,25 @@ func (guilds SortableGuilds) Swap(i, j int) {
 	guilds[i], guilds[j] = guilds[j], guilds[i]
 }
 
+// Reporter is an interface abstraction for providing a report to be served
+// as JSON.
+type Reporter interface {
+	Report() interface{}
+}
+
+// OptionFunc is used to configure additional endpoints for the *http.Server.
+type OptionFunc func(log logging.Interface, mux *http.ServeMux)
+
+// OptionalReport returns an OptionFunc to serve the report provided by
+// reporter as JSON on the given endpoint.
+func OptionalReport(endpoint string, reporter Reporter) OptionFunc {
+	return func(log logging.Interface, mux *http.ServeMux) {
+		mux.HandleFunc(endpoint, reportHandler(log, reporter))
+	}
+}
+
 // NewServer returns a new pre-configured *http.Server..
-func NewServer(log logging.Interface, session *discordgo.Session, port string) *http.Server {
+func NewServer(log logging.Interface, session *discordgo.Session, port string, options ...OptionFunc) *http.Server {
 	mux := http.NewServeMux()
 
 	mux.HandleFunc(RootEndpoint, rootHandler(log))
@@ -74,6 +93,10 @@ func NewServer(log logging.Interface, session *discordgo.Session, port string) *
 	mux.HandleFunc(pprofTraceEndpoint, pprof.Trace)
 	mux.Handle(metricsEndpoint, promhttp.Handler())
 
+	for _, option := range options {
+		option(log, mux)
+	}
+
 	errorLog := stdLog.New(log.WrappedLogger().WriterLevel(logrus.ErrorLevel), "", 0)
 
 	return &http.Server{
@@ -112,6 +135,24 @@ func guildsHandler(log logging.Interface, session *discordgo.Session) http.Handl
 	}
 }
 
+func reportHandler(log logging.Interface, reporter Reporter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer drainCloseRequest(log, r)
+
+		reportJSON, err := json.MarshalIndent(reporter.Report(), "", "    ")
+		if err != nil {
+			log.WithError(err).Errorf("Error marshaling report to JSON")
+			return
+		}
+
+		_, err = w.Write(reportJSON)
+		if err != nil {
+			log.WithError(err).Errorf("Error writing report response")
+			return
+		}
+	}
+}
+
 func rootHandler(log logging.Interface) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		drainCloseRequest(log, r)
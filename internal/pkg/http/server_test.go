@@ -23,8 +23,16 @@ const (
 	serverStartupDelay = 50 * time.Millisecond
 
 	expectedGuildsFile = "testdata/guilds.json"
+
+	testReportEndpoint = "/report"
 )
 
+type testReporter struct{}
+
+func (testReporter) Report() interface{} {
+	return []string{testReportEndpoint}
+}
+
 //nolint:gochecknoglobals // override stdlib json package
 var json = jsoniter.ConfigCompatibleWithStandardLibrary
 
@@ -42,7 +50,10 @@ func TestNewServer(t *testing.T) {
 		&discordgo.Guild{Name: "testGuild3", MemberCount: 4},
 	)
 
-	testServer := internalHTTP.NewServer(log, session, testPort)
+	testServer := internalHTTP.NewServer(
+		log, session, testPort,
+		internalHTTP.OptionalReport(testReportEndpoint, testReporter{}),
+	)
 
 	go func() {
 		serverErr := testServer.ListenAndServe()
@@ -57,6 +68,7 @@ func TestNewServer(t *testing.T) {
 
 	testRootEndpoint(t, client)
 	testGuildsEndpoint(t, client)
+	testReport(t, client)
 
 	ctx, cancelContext := context.WithTimeout(context.Background(), time.Second)
 	defer cancelContext()
@@ -117,3 +129,26 @@ func testGuildsEndpoint(t *testing.T, client *http.Client) {
 		)
 	}
 }
+
+func testReport(t *testing.T, client *http.Client) {
+	resp, err := doContextRequest(context.Background(), client, testURL+testReportEndpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reportBytes, err := readCloseResponse(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := make([]string, 0)
+
+	err = json.Unmarshal(reportBytes, &report)
+	if err != nil {
+		t.Fatalf("Error unmarshaling report: %s", err)
+	}
+
+	if !reflect.DeepEqual(report, testReporter{}.Report()) {
+		t.Errorf("Unexpected report: %s", string(reportBytes))
+	}
+}
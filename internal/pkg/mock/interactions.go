@@ -0,0 +1,228 @@
+package mock
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Interactions is an http.RoundTripper mocking the Discord REST endpoints for
+// application commands, interaction responses and their follow-up messages.
+// It records the commands registered and the responses and follow-ups sent,
+// passing every other request to next.
+type Interactions struct {
+	next                     http.RoundTripper
+	commandsPath             *regexp.Regexp
+	responsePath             *regexp.Regexp
+	followupPath             *regexp.Regexp
+	mutex                    *sync.Mutex
+	commands                 map[string][]*discordgo.ApplicationCommand
+	defaultMemberPermissions map[string]map[string]string
+	responses                map[string]*discordgo.InteractionResponse
+	followups                map[string]*discordgo.WebhookParams
+	commandWrites            int
+}
+
+// registeredCommand is an application command as registered, including the
+// default member permissions discordgo does not support yet.
+type registeredCommand struct {
+	*discordgo.ApplicationCommand
+	DefaultMemberPermissions *string `json:"default_member_permissions,omitempty"`
+}
+
+// NewInteractions returns a new *Interactions passing requests for other
+// endpoints to next.
+func NewInteractions(next http.RoundTripper) *Interactions {
+	return &Interactions{
+		next:                     next,
+		commandsPath:             regexp.MustCompile(`/applications/([^/]+)(?:/guilds/([^/]+))?/commands$`),
+		responsePath:             regexp.MustCompile(`/interactions/([^/]+)/[^/]+/callback$`),
+		followupPath:             regexp.MustCompile(`/webhooks/[^/]+/([^/]+)$`),
+		mutex:                    &sync.Mutex{},
+		commands:                 make(map[string][]*discordgo.ApplicationCommand),
+		defaultMemberPermissions: make(map[string]map[string]string),
+		responses:                make(map[string]*discordgo.InteractionResponse),
+		followups:                make(map[string]*discordgo.WebhookParams),
+	}
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (interactions *Interactions) RoundTrip(req *http.Request) (*http.Response, error) {
+	if matches := interactions.commandsPath.FindStringSubmatch(req.URL.Path); matches != nil {
+		return interactions.roundTripCommands(req, matches[1], matches[2])
+	}
+
+	if matches := interactions.responsePath.FindStringSubmatch(req.URL.Path); matches != nil {
+		return interactions.roundTripResponse(req, matches[1])
+	}
+
+	if matches := interactions.followupPath.FindStringSubmatch(req.URL.Path); matches != nil && req.Method == http.MethodPost {
+		return interactions.roundTripFollowup(req, matches[1])
+	}
+
+	return interactions.next.RoundTrip(req)
+}
+
+// Commands returns the application commands registered in the guild
+// associated with the provided guildID, or the global application commands
+// if guildID is empty.
+func (interactions *Interactions) Commands(guildID string) []*discordgo.ApplicationCommand {
+	interactions.mutex.Lock()
+	defer interactions.mutex.Unlock()
+
+	return interactions.commands[guildID]
+}
+
+// CommandWrites returns the number of times application commands have been
+// overwritten.
+func (interactions *Interactions) CommandWrites() int {
+	interactions.mutex.Lock()
+	defer interactions.mutex.Unlock()
+
+	return interactions.commandWrites
+}
+
+// Response returns the response sent to the interaction associated with the
+// provided interactionID, or nil if none was sent.
+func (interactions *Interactions) Response(interactionID string) *discordgo.InteractionResponse {
+	interactions.mutex.Lock()
+	defer interactions.mutex.Unlock()
+
+	return interactions.responses[interactionID]
+}
+
+// Followup returns the latest follow-up message sent for the interaction
+// associated with the provided interaction token, or nil if none was sent.
+func (interactions *Interactions) Followup(token string) *discordgo.WebhookParams {
+	interactions.mutex.Lock()
+	defer interactions.mutex.Unlock()
+
+	return interactions.followups[token]
+}
+
+// DefaultMemberPermissions returns the default member permissions the
+// application command with the provided name was registered with in the guild
+// associated with the provided guildID, or globally if guildID is empty. It
+// reports false if the command has no default member permissions.
+func (interactions *Interactions) DefaultMemberPermissions(guildID, name string) (string, bool) {
+	interactions.mutex.Lock()
+	defer interactions.mutex.Unlock()
+
+	permissions, found := interactions.defaultMemberPermissions[guildID][name]
+
+	return permissions, found
+}
+
+func (interactions *Interactions) roundTripCommands(req *http.Request, applicationID, guildID string) (*http.Response, error) {
+	interactions.mutex.Lock()
+	defer interactions.mutex.Unlock()
+
+	switch req.Method {
+	case http.MethodGet:
+		return jsonResponse(req, http.StatusOK, interactions.commands[guildID])
+	case http.MethodPut:
+		var registered []*registeredCommand
+
+		err := decodeRequestBody(req, &registered)
+		if err != nil {
+			return nil, err
+		}
+
+		commands := make([]*discordgo.ApplicationCommand, len(registered))
+		defaultMemberPermissions := make(map[string]string)
+
+		for i, command := range registered {
+			command.ID = fmt.Sprintf("%s%s", guildID, command.Name)
+			command.ApplicationID = applicationID
+			commands[i] = command.ApplicationCommand
+
+			if command.DefaultMemberPermissions != nil {
+				defaultMemberPermissions[command.Name] = *command.DefaultMemberPermissions
+			}
+		}
+
+		interactions.commands[guildID] = commands
+		interactions.defaultMemberPermissions[guildID] = defaultMemberPermissions
+		interactions.commandWrites++
+
+		return jsonResponse(req, http.StatusOK, commands)
+	default:
+		return jsonResponse(req, http.StatusMethodNotAllowed, nil)
+	}
+}
+
+func (interactions *Interactions) roundTripResponse(req *http.Request, interactionID string) (*http.Response, error) {
+	response := &discordgo.InteractionResponse{}
+
+	err := decodeRequestBody(req, response)
+	if err != nil {
+		return nil, err
+	}
+
+	interactions.mutex.Lock()
+	interactions.responses[interactionID] = response
+	interactions.mutex.Unlock()
+
+	return &http.Response{
+		Status:     http.StatusText(http.StatusNoContent),
+		StatusCode: http.StatusNoContent,
+		Header:     make(http.Header),
+		Request:    req,
+		Body:       ioutil.NopCloser(bytes.NewReader([]byte{})),
+	}, nil
+}
+
+func (interactions *Interactions) roundTripFollowup(req *http.Request, token string) (*http.Response, error) {
+	followup := &discordgo.WebhookParams{}
+
+	err := decodeRequestBody(req, followup)
+	if err != nil {
+		return nil, err
+	}
+
+	interactions.mutex.Lock()
+	interactions.followups[token] = followup
+	interactions.mutex.Unlock()
+
+	return &http.Response{
+		Status:     http.StatusText(http.StatusNoContent),
+		StatusCode: http.StatusNoContent,
+		Header:     make(http.Header),
+		Request:    req,
+		Body:       ioutil.NopCloser(bytes.NewReader([]byte{})),
+	}, nil
+}
+
+func decodeRequestBody(req *http.Request, v interface{}) error {
+	if req.Body == nil {
+		return fmt.Errorf("missing request body: %s %s", req.Method, req.URL.Path)
+	}
+
+	defer func() {
+		_ = req.Body.Close()
+	}()
+
+	return json.NewDecoder(req.Body).Decode(v)
+}
+
+func jsonResponse(req *http.Request, statusCode int, v interface{}) (*http.Response, error) {
+	respBody, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Response{
+		Status:        http.StatusText(statusCode),
+		StatusCode:    statusCode,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Request:       req,
+		ContentLength: int64(len(respBody)),
+		Body:          ioutil.NopCloser(bytes.NewReader(respBody)),
+	}, nil
+}
@@ -0,0 +1,77 @@
+package mock_test
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ewohltman/discordgo-mock/mockconstants"
+
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/mock"
+)
+
+func TestInteractions(t *testing.T) {
+	session, err := mock.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	interactions := mock.NewInteractions(session.Client.Transport)
+	session.Client.Transport = interactions
+
+	_, err = session.ApplicationCommandBulkOverwrite("testApp", mockconstants.TestGuild, []*discordgo.ApplicationCommand{
+		{Name: "test", Description: "test"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commands, err := session.ApplicationCommands("testApp", mockconstants.TestGuild)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(commands) != 1 || commands[0].ID == "" || commands[0].ApplicationID != "testApp" {
+		t.Errorf("Unexpected application commands: %+v", commands)
+	}
+
+	if len(interactions.Commands("")) != 0 {
+		t.Error("Unexpected global application commands")
+	}
+
+	err = session.InteractionRespond(
+		&discordgo.Interaction{ID: "testInteraction", Token: "testToken"},
+		&discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionApplicationCommandResponseData{Content: "test"},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response := interactions.Response("testInteraction")
+	if response == nil || response.Data.Content != "test" {
+		t.Errorf("Unexpected interaction response: %+v", response)
+	}
+
+	_, err = session.FollowupMessageCreate(
+		"testApp",
+		&discordgo.Interaction{ID: "testInteraction", Token: "testToken"},
+		false,
+		&discordgo.WebhookParams{Content: "followup"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	followup := interactions.Followup("testToken")
+	if followup == nil || followup.Content != "followup" {
+		t.Errorf("Unexpected interaction follow-up: %+v", followup)
+	}
+
+	// Other requests are passed through
+	_, err = session.GuildRoles(mockconstants.TestGuild)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -4,8 +4,11 @@ package mock
 
 import (
 	"bytes"
+	"fmt"
 	"io/ioutil"
 	"net/http"
+	"regexp"
+	"sync"
 )
 
 // roundTripperFunc allows functions to satisfy the http.RoundTripper
@@ -51,3 +54,59 @@ func NewMirrorRoundTripper() http.RoundTripper {
 		return resp, nil
 	})
 }
+
+// Failure describes REST requests to be failed by a round tripper returned
+// from NewFailureRoundTripper.
+type Failure struct {
+	Method     string
+	Path       *regexp.Regexp
+	StatusCode int
+	Code       int
+	Times      int
+}
+
+// NewFailureRoundTripper returns an http.RoundTripper that fails requests
+// matching any of the provided failures with the failure's status code and
+// Discord API error code. Each failure applies to the first Times matching
+// requests, or to every matching request if Times is zero. Requests that do
+// not fail are passed to next.
+func NewFailureRoundTripper(next http.RoundTripper, failures ...*Failure) http.RoundTripper {
+	mutex := &sync.Mutex{}
+	counts := make(map[*Failure]int, len(failures))
+
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		mutex.Lock()
+
+		for _, failure := range failures {
+			if req.Method != failure.Method || !failure.Path.MatchString(req.URL.Path) {
+				continue
+			}
+
+			if failure.Times != 0 && counts[failure] >= failure.Times {
+				continue
+			}
+
+			counts[failure]++
+			mutex.Unlock()
+
+			return failureResponse(req, failure), nil
+		}
+
+		mutex.Unlock()
+
+		return next.RoundTrip(req)
+	})
+}
+
+func failureResponse(req *http.Request, failure *Failure) *http.Response {
+	respBody := []byte(fmt.Sprintf(`{"code":%d,"message":"%s"}`, failure.Code, http.StatusText(failure.StatusCode)))
+
+	return &http.Response{
+		Status:        http.StatusText(failure.StatusCode),
+		StatusCode:    failure.StatusCode,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Request:       req,
+		ContentLength: int64(len(respBody)),
+		Body:          ioutil.NopCloser(bytes.NewReader(respBody)),
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"reflect"
+	"regexp"
 	"testing"
 
 	"github.com/ewohltman/ephemeral-roles/internal/pkg/mock"
@@ -45,3 +46,38 @@ func TestNewMirrorRoundTripper(t *testing.T) {
 		)
 	}
 }
+
+func TestNewFailureRoundTripper(t *testing.T) {
+	failure := &mock.Failure{
+		Method:     http.MethodPost,
+		Path:       regexp.MustCompile(`/fail$`),
+		StatusCode: http.StatusBadRequest,
+		Code:       1,
+		Times:      1,
+	}
+
+	roundTripper := mock.NewFailureRoundTripper(mock.NewMirrorRoundTripper(), failure)
+
+	expectedStatusCodes := []int{http.StatusBadRequest, http.StatusOK}
+
+	for _, expectedStatusCode := range expectedStatusCodes {
+		req, err := http.NewRequestWithContext(context.TODO(), http.MethodPost, "/fail", nil)
+		if err != nil {
+			t.Fatalf("Error creating test request: %s", err)
+		}
+
+		resp, err := roundTripper.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("Error performing round trip: %s", err)
+		}
+
+		err = resp.Body.Close()
+		if err != nil {
+			t.Fatalf("Error closing test response body: %s", err)
+		}
+
+		if resp.StatusCode != expectedStatusCode {
+			t.Errorf("Unexpected status code. Expected: %d, Got: %d", expectedStatusCode, resp.StatusCode)
+		}
+	}
+}
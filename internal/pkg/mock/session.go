@@ -21,6 +21,10 @@ const (
 	largeGuildSize = 3000
 )
 
+// BotRole is the ID and name of the role held by the bot user. It grants the
+// Manage Roles permission and is positioned above every other role.
+const BotRole = "testBotRole"
+
 // NewSession provides a *discordgo.Session instance to be used in unit
 // testing with pre-populated initial state.
 func NewSession() (*discordgo.Session, error) {
@@ -36,6 +40,14 @@ func NewSession() (*discordgo.Session, error) {
 		mockrole.WithPermissions(discordgo.PermissionViewChannel),
 	)
 
+	botRole := mockrole.New(
+		mockrole.WithID(BotRole),
+		mockrole.WithName(BotRole),
+		mockrole.WithPermissions(discordgo.PermissionViewChannel|discordgo.PermissionManageRoles),
+	)
+
+	botRole.Position = 1
+
 	botUser := mockuser.New(
 		mockuser.WithID(mockconstants.TestUser+"Bot"),
 		mockuser.WithUsername(mockconstants.TestUser+"Bot"),
@@ -45,8 +57,8 @@ func NewSession() (*discordgo.Session, error) {
 	state, err := mockstate.New(
 		mockstate.WithUser(botUser),
 		mockstate.WithGuilds(
-			smallGuild(botUser, role, ephRole),
-			largeGuild(botUser, role, ephRole),
+			smallGuild(botUser, botRole, role, ephRole),
+			largeGuild(botUser, botRole, role, ephRole),
 		),
 	)
 	if err != nil {
@@ -61,11 +73,11 @@ func NewSession() (*discordgo.Session, error) {
 	)
 }
 
-func smallGuild(botUser *discordgo.User, role, ephRole *discordgo.Role) *discordgo.Guild {
+func smallGuild(botUser *discordgo.User, botRole, role, ephRole *discordgo.Role) *discordgo.Guild {
 	botMember := mockmember.New(
 		mockmember.WithUser(botUser),
 		mockmember.WithGuildID(mockconstants.TestGuild),
-		mockmember.WithRoles(role, ephRole),
+		mockmember.WithRoles(botRole, role, ephRole),
 	)
 
 	userMember := mockmember.New(
@@ -106,14 +118,14 @@ func smallGuild(botUser *discordgo.User, role, ephRole *discordgo.Role) *discord
 	return mockguild.New(
 		mockguild.WithID(mockconstants.TestGuild),
 		mockguild.WithName(mockconstants.TestGuild),
-		mockguild.WithRoles(role, ephRole),
+		mockguild.WithRoles(botRole, role, ephRole),
 		mockguild.WithChannels(channel1, channel2, privateChannel),
 		mockguild.WithMembers(botMember, userMember),
 	)
 }
 
-func largeGuild(botUser *discordgo.User, role, ephRole *discordgo.Role) *discordgo.Guild {
-	guild := smallGuild(botUser, role, ephRole)
+func largeGuild(botUser *discordgo.User, botRole, role, ephRole *discordgo.Role) *discordgo.Guild {
+	guild := smallGuild(botUser, botRole, role, ephRole)
 
 	largeGuildMembers := make([]*discordgo.Member, largeGuildSize)
 
@@ -23,24 +23,40 @@ type Config struct {
 // Metrics contains fields for tracking and exposing metrics to Prometheus.
 type Metrics struct {
 	*Config
-	Guilds                  *Guilds
-	Members                 *Members
-	ReadyCounter            prometheus.Counter
-	MessageCreateCounter    prometheus.Counter
-	VoiceStateUpdateCounter prometheus.Counter
-	GuildsGauge             prometheus.Gauge
-	MembersGauge            prometheus.Gauge
+	Guilds                   *Guilds
+	Members                  *Members
+	ReadyCounter             prometheus.Counter
+	MessageCreateCounter     prometheus.Counter
+	InteractionCreateCounter prometheus.Counter
+	VoiceStateUpdateCounter  prometheus.Counter
+	ReconcileAddedCounter    *prometheus.CounterVec
+	ReconcileRemovedCounter  *prometheus.CounterVec
+	RoleEvictionCounter      prometheus.Counter
+	GuildsGauge              prometheus.Gauge
+	MembersGauge             prometheus.Gauge
+	QueueDepthGauge          *prometheus.GaugeVec
+	QueueWaitHistogram       *prometheus.HistogramVec
+	OperationAttemptsCounter *prometheus.CounterVec
+	OpenCircuitBreakersGauge prometheus.Gauge
 }
 
 // NewMetrics returns a new *Metrics configured using the provided config.
 func NewMetrics(config *Config) *Metrics {
 	metrics := &Metrics{
-		Config:                  config,
-		ReadyCounter:            ReadyCounter(config),
-		MessageCreateCounter:    MessageCreateCounter(config),
-		VoiceStateUpdateCounter: VoiceStateUpdateCounter(config),
-		GuildsGauge:             GuildsGauge(config),
-		MembersGauge:            MembersGauge(config),
+		Config:                   config,
+		ReadyCounter:             ReadyCounter(config),
+		MessageCreateCounter:     MessageCreateCounter(config),
+		InteractionCreateCounter: InteractionCreateCounter(config),
+		VoiceStateUpdateCounter:  VoiceStateUpdateCounter(config),
+		ReconcileAddedCounter:    ReconcileAddedCounter(config),
+		ReconcileRemovedCounter:  ReconcileRemovedCounter(config),
+		RoleEvictionCounter:      RoleEvictionCounter(config),
+		GuildsGauge:              GuildsGauge(config),
+		MembersGauge:             MembersGauge(config),
+		QueueDepthGauge:          QueueDepthGauge(config),
+		QueueWaitHistogram:       QueueWaitHistogram(config),
+		OperationAttemptsCounter: OperationAttemptsCounter(config),
+		OpenCircuitBreakersGauge: OpenCircuitBreakersGauge(config),
 	}
 
 	metrics.newGuilds()
@@ -113,6 +129,26 @@ func MessageCreateCounter(config *Config) prometheus.Counter {
 	return prometheusMessageCreateCounter
 }
 
+// InteractionCreateCounter returns a Prometheus counter for
+// InteractionCreate events.
+func InteractionCreateCounter(config *Config) prometheus.Counter {
+	prometheusInteractionCreateCounter := prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "ephemeral_roles",
+			Name:      "interaction_create_events",
+			Help:      "Total InteractionCreate events",
+		},
+	)
+
+	err := prometheus.Register(prometheusInteractionCreateCounter)
+	if err != nil && !alreadyRegisteredError(err) {
+		config.Log.WithError(err).Error("Unable to register InteractionCreate events metric with Prometheus")
+		return nil
+	}
+
+	return prometheusInteractionCreateCounter
+}
+
 // VoiceStateUpdateCounter returns a Prometheus counter for VoiceStateUpdate
 // events.
 func VoiceStateUpdateCounter(config *Config) prometheus.Counter {
@@ -133,6 +169,68 @@ func VoiceStateUpdateCounter(config *Config) prometheus.Counter {
 	return prometheusVoiceStateUpdateCounter
 }
 
+// ReconcileAddedCounter returns a Prometheus counter for ephemeral roles added
+// to members by reconciliation, labeled by guild ID.
+func ReconcileAddedCounter(config *Config) *prometheus.CounterVec {
+	prometheusReconcileAddedCounter := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "ephemeral_roles",
+			Name:      "reconcile_roles_added",
+			Help:      "Total ephemeral roles added by reconciliation",
+		},
+		[]string{"guild"},
+	)
+
+	err := prometheus.Register(prometheusReconcileAddedCounter)
+	if err != nil && !alreadyRegisteredError(err) {
+		config.Log.WithError(err).Error("Unable to register reconcile roles added metric with Prometheus")
+		return nil
+	}
+
+	return prometheusReconcileAddedCounter
+}
+
+// ReconcileRemovedCounter returns a Prometheus counter for ephemeral roles
+// removed from members by reconciliation, labeled by guild ID.
+func ReconcileRemovedCounter(config *Config) *prometheus.CounterVec {
+	prometheusReconcileRemovedCounter := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "ephemeral_roles",
+			Name:      "reconcile_roles_removed",
+			Help:      "Total ephemeral roles removed by reconciliation",
+		},
+		[]string{"guild"},
+	)
+
+	err := prometheus.Register(prometheusReconcileRemovedCounter)
+	if err != nil && !alreadyRegisteredError(err) {
+		config.Log.WithError(err).Error("Unable to register reconcile roles removed metric with Prometheus")
+		return nil
+	}
+
+	return prometheusReconcileRemovedCounter
+}
+
+// RoleEvictionCounter returns a Prometheus counter for ephemeral roles
+// evicted to free capacity in guilds with the max number of roles.
+func RoleEvictionCounter(config *Config) prometheus.Counter {
+	prometheusRoleEvictionCounter := prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "ephemeral_roles",
+			Name:      "role_evictions",
+			Help:      "Total ephemeral roles evicted at the max number of roles",
+		},
+	)
+
+	err := prometheus.Register(prometheusRoleEvictionCounter)
+	if err != nil && !alreadyRegisteredError(err) {
+		config.Log.WithError(err).Error("Unable to register role evictions metric with Prometheus")
+		return nil
+	}
+
+	return prometheusRoleEvictionCounter
+}
+
 // GuildsGauge returns a Prometheus gauge for the number of guilds the bot
 // belongs to.
 func GuildsGauge(config *Config) prometheus.Gauge {
@@ -173,6 +271,91 @@ func MembersGauge(config *Config) prometheus.Gauge {
 	return prometheusMembersGauge
 }
 
+// QueueDepthGauge returns a Prometheus gauge for the number of operations
+// requests queued in the operations gateway, labeled by priority.
+func QueueDepthGauge(config *Config) *prometheus.GaugeVec {
+	prometheusQueueDepthGauge := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "ephemeral_roles",
+			Name:      "operations_queue_depth",
+			Help:      "Current operations requests waiting in the queue",
+		},
+		[]string{"priority"},
+	)
+
+	err := prometheus.Register(prometheusQueueDepthGauge)
+	if err != nil && !alreadyRegisteredError(err) {
+		config.Log.WithError(err).Error("Unable to register operations queue depth gauge with Prometheus")
+		return nil
+	}
+
+	return prometheusQueueDepthGauge
+}
+
+// QueueWaitHistogram returns a Prometheus histogram for the time operations
+// requests wait in the operations gateway queue, labeled by priority.
+func QueueWaitHistogram(config *Config) *prometheus.HistogramVec {
+	prometheusQueueWaitHistogram := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "ephemeral_roles",
+			Name:      "operations_queue_wait_seconds",
+			Help:      "Time operations requests wait in the queue",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+		},
+		[]string{"priority"},
+	)
+
+	err := prometheus.Register(prometheusQueueWaitHistogram)
+	if err != nil && !alreadyRegisteredError(err) {
+		config.Log.WithError(err).Error("Unable to register operations queue wait histogram with Prometheus")
+		return nil
+	}
+
+	return prometheusQueueWaitHistogram
+}
+
+// OperationAttemptsCounter returns a Prometheus counter for every attempt of
+// an operation in the operations gateway, labeled by operation, attempt and
+// result.
+func OperationAttemptsCounter(config *Config) *prometheus.CounterVec {
+	prometheusOperationAttemptsCounter := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "ephemeral_roles",
+			Name:      "operations_attempts_total",
+			Help:      "Total operation attempts",
+		},
+		[]string{"operation", "attempt", "result"},
+	)
+
+	err := prometheus.Register(prometheusOperationAttemptsCounter)
+	if err != nil && !alreadyRegisteredError(err) {
+		config.Log.WithError(err).Error("Unable to register operation attempts counter with Prometheus")
+		return nil
+	}
+
+	return prometheusOperationAttemptsCounter
+}
+
+// OpenCircuitBreakersGauge returns a Prometheus gauge for the number of guilds
+// with an open operations circuit breaker.
+func OpenCircuitBreakersGauge(config *Config) prometheus.Gauge {
+	prometheusOpenCircuitBreakersGauge := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "ephemeral_roles",
+			Name:      "operations_open_circuit_breakers",
+			Help:      "Current guilds with an open operations circuit breaker",
+		},
+	)
+
+	err := prometheus.Register(prometheusOpenCircuitBreakersGauge)
+	if err != nil && !alreadyRegisteredError(err) {
+		config.Log.WithError(err).Error("Unable to register open circuit breakers gauge with Prometheus")
+		return nil
+	}
+
+	return prometheusOpenCircuitBreakersGauge
+}
+
 func alreadyRegisteredError(err error) bool {
 	_, alreadyRegistered := err.(prometheus.AlreadyRegisteredError)
 	return alreadyRegistered
@@ -39,9 +39,41 @@ func TestMetrics(t *testing.T) {
 		t.Error("Unexpected nil MessageCreate counter")
 	}
 
+	if metrics.InteractionCreateCounter == nil {
+		t.Error("Unexpected nil InteractionCreate counter")
+	}
+
 	if metrics.VoiceStateUpdateCounter == nil {
 		t.Error("Unexpected nil VoiceStateUpdate counter")
 	}
+
+	if metrics.ReconcileAddedCounter == nil {
+		t.Error("Unexpected nil reconcile roles added counter")
+	}
+
+	if metrics.ReconcileRemovedCounter == nil {
+		t.Error("Unexpected nil reconcile roles removed counter")
+	}
+
+	if metrics.RoleEvictionCounter == nil {
+		t.Error("Unexpected nil role evictions counter")
+	}
+
+	if metrics.QueueDepthGauge == nil {
+		t.Error("Unexpected nil operations queue depth gauge")
+	}
+
+	if metrics.QueueWaitHistogram == nil {
+		t.Error("Unexpected nil operations queue wait histogram")
+	}
+
+	if metrics.OperationAttemptsCounter == nil {
+		t.Error("Unexpected nil operation attempts counter")
+	}
+
+	if metrics.OpenCircuitBreakersGauge == nil {
+		t.Error("Unexpected nil open circuit breakers gauge")
+	}
 }
 
 func TestMonitor(t *testing.T) {
@@ -0,0 +1,281 @@
+package operations
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Circuit breaker state string representations.
+const (
+	CircuitClosedString   = "closed"
+	CircuitOpenString     = "open"
+	CircuitHalfOpenString = "half-open"
+)
+
+// Default circuit breaker configuration.
+const (
+	DefaultBreakerThreshold = 5
+	DefaultBreakerCoolDown  = 10 * time.Minute
+)
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+func (state circuitState) String() string {
+	switch state {
+	case circuitClosed:
+		return CircuitClosedString
+	case circuitOpen:
+		return CircuitOpenString
+	case circuitHalfOpen:
+		return CircuitHalfOpenString
+	default:
+		return UnknownString
+	}
+}
+
+// CircuitOpen is an error for requests rejected because the circuit breaker
+// for their guild is open. It wraps the forbidden response which opened the
+// circuit, so IsForbiddenResponse reports true for it.
+type CircuitOpen struct {
+	GuildID string
+	ProbeAt time.Time
+	Err     error
+}
+
+// Error satisfies the error interface.
+func (circuitOpen *CircuitOpen) Error() string {
+	return fmt.Sprintf(
+		"circuit breaker open for guild %s until %s: %s",
+		circuitOpen.GuildID,
+		circuitOpen.ProbeAt.Format(time.RFC3339),
+		circuitOpen.Err,
+	)
+}
+
+// Unwrap returns the forbidden response which opened the circuit.
+func (circuitOpen *CircuitOpen) Unwrap() error {
+	return circuitOpen.Err
+}
+
+// CircuitBreakerReport is the state of the circuit breaker of a guild.
+type CircuitBreakerReport struct {
+	GuildID   string    `json:"guildID"`
+	GuildName string    `json:"guildName"`
+	State     string    `json:"state"`
+	Failures  int       `json:"failures"`
+	OpenedAt  time.Time `json:"openedAt"`
+	ProbeAt   time.Time `json:"probeAt"`
+	LastError string    `json:"lastError"`
+}
+
+// guildCircuit is the circuit breaker state of a guild.
+type guildCircuit struct {
+	guildName string
+	state     circuitState
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	lastErr   error
+}
+
+// CircuitBreaker stops operations in guilds where the bot keeps receiving
+// forbidden responses, such as when it is missing the Manage Roles permission.
+// After Threshold consecutive forbidden responses in a guild, the circuit for
+// the guild opens and requests are rejected without calling the Discord API
+// for CoolDown. A single probe request is then let through. If it succeeds
+// the circuit closes, and if it is forbidden the circuit opens again. A nil
+// *CircuitBreaker never rejects requests.
+type CircuitBreaker struct {
+	Threshold int
+	CoolDown  time.Duration
+
+	mutex        *sync.Mutex
+	guilds       map[string]*guildCircuit
+	openBreakers prometheus.Gauge
+}
+
+// NewCircuitBreaker returns a new *CircuitBreaker. The number of guilds with
+// an open circuit is reported to the provided gauge, if not nil.
+func NewCircuitBreaker(threshold int, coolDown time.Duration, openBreakers prometheus.Gauge) *CircuitBreaker {
+	return &CircuitBreaker{
+		Threshold:    threshold,
+		CoolDown:     coolDown,
+		mutex:        &sync.Mutex{},
+		guilds:       make(map[string]*guildCircuit),
+		openBreakers: openBreakers,
+	}
+}
+
+// Allow returns a *CircuitOpen error if requests in the guild associated with
+// the provided guildID should not be sent to the Discord API. Once the
+// cool-down has passed, Allow lets a single probe request through.
+func (breaker *CircuitBreaker) Allow(guildID string) error {
+	if breaker == nil {
+		return nil
+	}
+
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	circuit, found := breaker.guilds[guildID]
+	if !found || circuit.state == circuitClosed {
+		return nil
+	}
+
+	probeAt := circuit.openedAt.Add(breaker.CoolDown)
+
+	if circuit.probing || time.Now().Before(probeAt) {
+		return &CircuitOpen{
+			GuildID: guildID,
+			ProbeAt: probeAt,
+			Err:     circuit.lastErr,
+		}
+	}
+
+	circuit.state = circuitHalfOpen
+	circuit.probing = true
+
+	return nil
+}
+
+// Record records the result of a request in the provided guild which was
+// allowed through.
+func (breaker *CircuitBreaker) Record(guild *discordgo.Guild, err error) {
+	if breaker == nil {
+		return
+	}
+
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	circuit, found := breaker.guilds[guild.ID]
+
+	switch {
+	case err == nil:
+		if !found {
+			return
+		}
+
+		if circuit.state != circuitClosed {
+			breaker.setOpenBreakers(-1)
+		}
+
+		delete(breaker.guilds, guild.ID)
+	case IsForbiddenResponse(err):
+		if !found {
+			circuit = &guildCircuit{}
+			breaker.guilds[guild.ID] = circuit
+		}
+
+		circuit.guildName = guild.Name
+		circuit.failures++
+		circuit.lastErr = err
+
+		breaker.trip(circuit)
+	default:
+		if found {
+			circuit.probing = false
+		}
+	}
+}
+
+// trip opens the provided circuit if it was probing or has reached the
+// threshold of forbidden responses. The caller must hold the breaker's mutex.
+func (breaker *CircuitBreaker) trip(circuit *guildCircuit) {
+	switch circuit.state {
+	case circuitClosed:
+		if circuit.failures < breaker.Threshold {
+			return
+		}
+
+		breaker.setOpenBreakers(1)
+	case circuitHalfOpen:
+		if !circuit.probing {
+			return
+		}
+	case circuitOpen:
+		return
+	}
+
+	circuit.state = circuitOpen
+	circuit.openedAt = time.Now()
+	circuit.probing = false
+}
+
+// breakerResult returns the error of an attempt in the provided guild as the
+// circuit breaker should record it. Forbidden responses only count towards
+// opening the circuit if the bot is missing the Manage Roles permission in the
+// guild. Otherwise they are specific to the role requested, such as one placed
+// above the bot's roles, and other requests in the guild may still succeed.
+func (gateway *Gateway) breakerResult(guild *discordgo.Guild, err error) error {
+	if !IsForbiddenResponse(err) {
+		return err
+	}
+
+	stateGuild, stateErr := gateway.Session.State.Guild(guild.ID)
+	if stateErr == nil {
+		guild = stateGuild
+	}
+
+	diagnosis, diagnosisErr := AnalyzePermissions(gateway.Session, guild, "")
+	if diagnosisErr != nil || !diagnosis.ManageRoles {
+		return err
+	}
+
+	return nil
+}
+
+func (breaker *CircuitBreaker) setOpenBreakers(delta float64) {
+	if breaker.openBreakers == nil {
+		return
+	}
+
+	breaker.openBreakers.Add(delta)
+}
+
+// Report returns a []*CircuitBreakerReport of the guilds with an open or
+// half-open circuit, sorted by guild ID, to satisfy the http.Reporter
+// interface.
+func (breaker *CircuitBreaker) Report() interface{} {
+	reports := make([]*CircuitBreakerReport, 0)
+
+	if breaker == nil {
+		return reports
+	}
+
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	for guildID, circuit := range breaker.guilds {
+		if circuit.state == circuitClosed {
+			continue
+		}
+
+		reports = append(reports, &CircuitBreakerReport{
+			GuildID:   guildID,
+			GuildName: circuit.guildName,
+			State:     circuit.state.String(),
+			Failures:  circuit.failures,
+			OpenedAt:  circuit.openedAt,
+			ProbeAt:   circuit.openedAt.Add(breaker.CoolDown),
+			LastError: circuit.lastErr.Error(),
+		})
+	}
+
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].GuildID < reports[j].GuildID
+	})
+
+	return reports
+}
@@ -0,0 +1,179 @@
+package operations_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ewohltman/discordgo-mock/mockconstants"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/mock"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/operations"
+)
+
+const testBreakerCoolDown = 20 * time.Millisecond
+
+func TestCircuitBreaker(t *testing.T) {
+	var nilBreaker *operations.CircuitBreaker
+
+	if nilBreaker.Allow(mockconstants.TestGuild) != nil {
+		t.Error("unexpected request rejected by nil circuit breaker")
+	}
+
+	openBreakers := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_open_breakers"})
+	breaker := operations.NewCircuitBreaker(2, testBreakerCoolDown, openBreakers)
+	guild := &discordgo.Guild{ID: mockconstants.TestGuild, Name: mockconstants.TestGuild}
+	forbidden := &discordgo.RESTError{Response: &http.Response{StatusCode: http.StatusForbidden}}
+
+	breaker.Record(guild, forbidden)
+
+	if breaker.Allow(guild.ID) != nil {
+		t.Fatal("unexpected circuit opened below the threshold")
+	}
+
+	breaker.Record(guild, forbidden)
+	checkCircuitOpen(t, breaker, guild.ID)
+
+	if testutil.ToFloat64(openBreakers) != 1 {
+		t.Errorf("unexpected open circuit breakers: %f", testutil.ToFloat64(openBreakers))
+	}
+
+	reports, ok := breaker.Report().([]*operations.CircuitBreakerReport)
+	if !ok || len(reports) != 1 || reports[0].State != operations.CircuitOpenString {
+		t.Fatalf("unexpected circuit breaker report: %+v", breaker.Report())
+	}
+
+	// A forbidden probe opens the circuit again
+	time.Sleep(testBreakerCoolDown)
+
+	if breaker.Allow(guild.ID) != nil {
+		t.Fatal("expected probe after the cool-down")
+	}
+
+	checkCircuitOpen(t, breaker, guild.ID)
+	breaker.Record(guild, forbidden)
+	checkCircuitOpen(t, breaker, guild.ID)
+
+	// A successful probe closes the circuit
+	time.Sleep(testBreakerCoolDown)
+
+	if breaker.Allow(guild.ID) != nil {
+		t.Fatal("expected probe after the cool-down")
+	}
+
+	breaker.Record(guild, nil)
+
+	if breaker.Allow(guild.ID) != nil {
+		t.Error("unexpected request rejected after a successful probe")
+	}
+
+	if testutil.ToFloat64(openBreakers) != 0 {
+		t.Errorf("unexpected open circuit breakers: %f", testutil.ToFloat64(openBreakers))
+	}
+
+	reports, ok = breaker.Report().([]*operations.CircuitBreakerReport)
+	if !ok || len(reports) != 0 {
+		t.Errorf("unexpected circuit breaker report: %+v", breaker.Report())
+	}
+}
+
+func TestGateway_circuitBreaker(t *testing.T) {
+	testCases := []struct {
+		name        string
+		manageRoles bool
+		circuitOpen bool
+	}{
+		{name: "missing manage roles", manageRoles: false, circuitOpen: true},
+		// Forbidden responses for a single role, such as one placed above the
+		// bot's roles, leave other requests in the guild unaffected
+		{name: "role forbidden", manageRoles: true, circuitOpen: false},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			session, err := mock.NewSession()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !testCase.manageRoles {
+				botRole, err := session.State.Role(mockconstants.TestGuild, mock.BotRole)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				botRole.Permissions = discordgo.PermissionViewChannel
+			}
+
+			var requests int32
+
+			failures := mock.NewFailureRoundTripper(session.Client.Transport, &mock.Failure{
+				Method:     http.MethodPut,
+				Path:       regexp.MustCompile(`/members/.+/roles/.+$`),
+				StatusCode: http.StatusForbidden,
+			})
+
+			session.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				atomic.AddInt32(&requests, 1)
+
+				return failures.RoundTrip(req)
+			})
+
+			gateway := operations.NewGateway(
+				session,
+				operations.WithCircuitBreaker(operations.NewCircuitBreaker(2, time.Hour, nil)),
+			)
+
+			addRole := func() error {
+				return gateway.AddRole(&operations.AddRoleRequest{
+					Guild:  &discordgo.Guild{ID: mockconstants.TestGuild},
+					UserID: mockconstants.TestUser,
+					RoleID: mockconstants.TestRole,
+				}).Wait(context.Background())
+			}
+
+			for i := 0; i < 2; i++ {
+				err = addRole()
+				if !operations.IsForbiddenResponse(err) {
+					t.Fatalf("expected forbidden response, got: %v", err)
+				}
+			}
+
+			err = addRole()
+
+			var circuitOpenErr *operations.CircuitOpen
+
+			if errors.As(err, &circuitOpenErr) != testCase.circuitOpen || !operations.IsForbiddenResponse(err) {
+				t.Errorf("unexpected result with circuit open %t: %v", testCase.circuitOpen, err)
+			}
+
+			expectedRequests := int32(3)
+			if testCase.circuitOpen {
+				expectedRequests = 2
+			}
+
+			if atomic.LoadInt32(&requests) != expectedRequests {
+				t.Errorf("unexpected requests sent: %d", atomic.LoadInt32(&requests))
+			}
+		})
+	}
+}
+
+func checkCircuitOpen(t *testing.T, breaker *operations.CircuitBreaker, guildID string) {
+	t.Helper()
+
+	var circuitOpenErr *operations.CircuitOpen
+
+	if !errors.As(breaker.Allow(guildID), &circuitOpenErr) {
+		t.Fatal("expected request rejected by open circuit")
+	}
+}
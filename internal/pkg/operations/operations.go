@@ -6,36 +6,40 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"hash/fnv"
 	"net/http"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
-// CreateRole is a RequestType enumeration.
-const CreateRole RequestType = iota
+// RequestType enumerations.
+const (
+	CreateRole RequestType = iota
+	EditRole
+	DeleteRole
+	AddRole
+	RemoveRole
+	SetRoles
+)
 
 // RequestType string representations.
 const (
 	CreateRoleString = "CreateRole"
+	EditRoleString   = "EditRole"
+	DeleteRoleString = "DeleteRole"
+	AddRoleString    = "AddRole"
+	RemoveRoleString = "RemoveRole"
+	SetRolesString   = "SetRoles"
 	UnknownString    = "unknown"
 )
 
 // APIErrorCodeMaxRoles is the Discord API error code for max roles.
 const APIErrorCodeMaxRoles = 30005
 
-const (
-	roleHoist             = true
-	roleMention           = true
-	guildMembersPageLimit = 1000
-)
-
-// Request is an operations request to be processed.
-type Request struct {
-	Type       RequestType
-	CreateRole *CreateRoleRequest
-}
+const guildMembersPageLimit = 1000
 
 // RequestType represents a type of operations request.
 type RequestType int
@@ -45,25 +49,77 @@ func (rt RequestType) String() string {
 	switch rt {
 	case CreateRole:
 		return CreateRoleString
+	case EditRole:
+		return EditRoleString
+	case DeleteRole:
+		return DeleteRoleString
+	case AddRole:
+		return AddRoleString
+	case RemoveRole:
+		return RemoveRoleString
+	case SetRoles:
+		return SetRolesString
 	default:
 		return UnknownString
 	}
 }
 
-// CreateRoleRequest is a request to create a new role.
+// CreateRoleRequest is a request to create a new role for the channel
+// associated with ChannelID.
 type CreateRoleRequest struct {
-	Guild     *discordgo.Guild
-	RoleName  string
-	RoleColor int
+	Guild           *discordgo.Guild
+	ChannelID       string
+	RoleName        string
+	RoleColor       int
+	RoleHoist       bool
+	RoleMentionable bool
+	Priority        Priority
+}
+
+// EditRoleRequest is a request to change the name, color and display options
+// of an existing role in place, leaving its members unchanged.
+type EditRoleRequest struct {
+	Guild           *discordgo.Guild
+	Role            *discordgo.Role
+	RoleName        string
+	RoleColor       int
+	RoleHoist       bool
+	RoleMentionable bool
+	Priority        Priority
 }
 
-// ResultChannel is a channel the result from an operation is sent to.
-type ResultChannel chan interface{}
+// DeleteRoleRequest is a request to delete an existing role.
+type DeleteRoleRequest struct {
+	Guild    *discordgo.Guild
+	RoleID   string
+	Priority Priority
+}
 
-// NewResultChannel returns a new, buffered channel to send an operation result
-// to.
-func NewResultChannel() ResultChannel {
-	return make(ResultChannel, 1)
+// AddRoleRequest is a request to add the role associated with RoleID to the
+// member associated with UserID.
+type AddRoleRequest struct {
+	Guild    *discordgo.Guild
+	UserID   string
+	RoleID   string
+	Priority Priority
+}
+
+// RemoveRoleRequest is a request to remove the role associated with RoleID
+// from the member associated with UserID.
+type RemoveRoleRequest struct {
+	Guild    *discordgo.Guild
+	UserID   string
+	RoleID   string
+	Priority Priority
+}
+
+// SetRolesRequest is a request to replace all of the roles of the member
+// associated with UserID with the roles associated with RoleIDs.
+type SetRolesRequest struct {
+	Guild    *discordgo.Guild
+	UserID   string
+	RoleIDs  []string
+	Priority Priority
 }
 
 // Gateway is a centralized construct to process operation requests by
@@ -72,84 +128,349 @@ func NewResultChannel() ResultChannel {
 type Gateway struct {
 	Session *discordgo.Session
 
-	mutex          *sync.Mutex
-	resultChannels map[keyHash][]ResultChannel
+	mutex       *sync.Mutex
+	inFlight    map[requestKey]*promise
+	queue       *workQueue
+	retryPolicy RetryPolicy
+	attempts    *prometheus.CounterVec
+	breaker     *CircuitBreaker
 }
 
-type keyHash uint32
+// OptionFunc is a function for configuring a *Gateway.
+type OptionFunc func(gateway *Gateway)
 
-// NewGateway returns a new *Gateway ready to process requests.
-func NewGateway(session *discordgo.Session) *Gateway {
-	return &Gateway{
-		Session:        session,
-		mutex:          &sync.Mutex{},
-		resultChannels: make(map[keyHash][]ResultChannel),
+// WithWorkers sets the maximum number of operations the *Gateway runs at the
+// same time.
+func WithWorkers(workers int) OptionFunc {
+	return func(gateway *Gateway) {
+		if workers > 0 {
+			gateway.queue.maxWorkers = workers
+		}
 	}
 }
 
-// Process will process the provided request and send back the result to the
-// provided ResultChannel. The caller should type check the result it receives
-// to determine if an error was sent or the result is of the type it expects.
-func (gateway *Gateway) Process(resultChannel ResultChannel, request *Request) {
-	switch request.Type {
-	case CreateRole:
-		gateway.processCreateRole(resultChannel, request)
-	default:
-		resultChannel <- fmt.Errorf("%s request type not supported", request.Type)
-		close(resultChannel)
+// WithMaxGuildQueue sets the maximum number of operations the *Gateway queues
+// for a single guild. Once a guild's queue is full, further background
+// requests fail with a *QueueFull error, and interactive requests take the
+// place of the guild's most recently queued background request.
+func WithMaxGuildQueue(maxGuildQueue int) OptionFunc {
+	return func(gateway *Gateway) {
+		if maxGuildQueue > 0 {
+			gateway.queue.maxGuildQueue = maxGuildQueue
+		}
 	}
 }
 
-func (gateway *Gateway) processCreateRole(resultChannel ResultChannel, request *Request) {
-	hashFunc := fnv.New32()
+// WithQueueMetrics sets the Prometheus metrics the *Gateway reports the depth
+// of its queue and the time requests wait in it to, labeled by priority.
+func WithQueueMetrics(queueDepth *prometheus.GaugeVec, waitTime *prometheus.HistogramVec) OptionFunc {
+	return func(gateway *Gateway) {
+		gateway.queue.queueDepth = queueDepth
+		gateway.queue.waitTime = waitTime
+	}
+}
 
-	// According to documentation, this Write will never return an error
-	_, _ = hashFunc.Write([]byte(fmt.Sprintf(
-		"%s/%s/%s",
-		request.Type,
-		request.CreateRole.Guild.ID,
-		request.CreateRole.RoleName,
-	)))
+// WithRetryPolicy sets the policy the *Gateway retries operations failing with
+// retryable errors by.
+func WithRetryPolicy(retryPolicy RetryPolicy) OptionFunc {
+	return func(gateway *Gateway) {
+		gateway.retryPolicy = retryPolicy
+	}
+}
 
-	key := keyHash(hashFunc.Sum32())
+// WithAttemptMetrics sets the Prometheus counter the *Gateway reports every
+// attempt of an operation to, labeled by operation, attempt and result.
+func WithAttemptMetrics(attempts *prometheus.CounterVec) OptionFunc {
+	return func(gateway *Gateway) {
+		gateway.attempts = attempts
+	}
+}
 
-	gateway.mutex.Lock()
+// WithCircuitBreaker sets the circuit breaker the *Gateway stops processing
+// requests in guilds with repeated forbidden responses with.
+func WithCircuitBreaker(breaker *CircuitBreaker) OptionFunc {
+	return func(gateway *Gateway) {
+		gateway.breaker = breaker
+	}
+}
 
-	_, found := gateway.resultChannels[key]
-	if found {
-		gateway.resultChannels[key] = append(gateway.resultChannels[key], resultChannel)
-		gateway.mutex.Unlock()
+// requestKey is the full identity of a request. Identical requests in flight
+// at the same time share one result.
+type requestKey struct {
+	requestType RequestType
+	guildID     string
+	channelID   string
+	userID      string
+	roleID      string
+	roleIDs     string
+	roleName    string
+	roleColor   int
+	roleHoist   bool
+	roleMention bool
+}
+
+// promise is the result of an operation, shared by every caller of the
+// request. done is closed once the result is set. The deadlines of the
+// callers waiting on the promise bound how long the operation is retried.
+type promise struct {
+	done chan struct{}
+	role *discordgo.Role
+	err  error
+
+	mutex     *sync.Mutex
+	waited    bool
+	unbounded bool
+	deadline  time.Time
+}
 
-		return
+func newPromise() *promise {
+	return &promise{
+		done:  make(chan struct{}),
+		mutex: &sync.Mutex{},
 	}
+}
 
-	gateway.resultChannels[key] = []ResultChannel{resultChannel}
-	gateway.mutex.Unlock()
+// wait registers the deadline of the provided context with the promise and
+// waits for the result.
+func (inFlight *promise) wait(ctx context.Context, requestType RequestType) (*discordgo.Role, error) {
+	deadline, hasDeadline := ctx.Deadline()
 
-	role, err := createRole(
-		gateway.Session,
-		request.CreateRole.Guild,
-		request.CreateRole.RoleName,
-		request.CreateRole.RoleColor,
-	)
-	if err != nil {
-		gateway.sendResult(key, err)
-		return
+	inFlight.mutex.Lock()
+
+	inFlight.waited = true
+
+	switch {
+	case !hasDeadline:
+		inFlight.unbounded = true
+	case deadline.After(inFlight.deadline):
+		inFlight.deadline = deadline
+	}
+
+	inFlight.mutex.Unlock()
+
+	select {
+	case <-inFlight.done:
+		return inFlight.role, inFlight.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%s operation cancelled: %w", requestType, ctx.Err())
+	}
+}
+
+// allowsRetry checks if a retry at the provided time is within the deadline
+// of any caller waiting on the promise. Promises nobody has waited on yet
+// may always be retried.
+func (inFlight *promise) allowsRetry(retryAt time.Time) bool {
+	inFlight.mutex.Lock()
+	defer inFlight.mutex.Unlock()
+
+	return !inFlight.waited || inFlight.unbounded || retryAt.Before(inFlight.deadline)
+}
+
+// RoleFuture is the pending result of a request producing a role.
+type RoleFuture struct {
+	requestType RequestType
+	promise     *promise
+}
+
+// Wait waits for the result of the request. If the provided context is done
+// first, Wait returns an error wrapping the context's error without affecting
+// other callers waiting on the same request. Failed attempts of the request
+// are not retried past the latest deadline of its callers.
+func (future *RoleFuture) Wait(ctx context.Context) (*discordgo.Role, error) {
+	return future.promise.wait(ctx, future.requestType)
+}
+
+// Future is the pending result of a request without a result value.
+type Future struct {
+	requestType RequestType
+	promise     *promise
+}
+
+// Wait waits for the result of the request. If the provided context is done
+// first, Wait returns an error wrapping the context's error without affecting
+// other callers waiting on the same request. Failed attempts of the request
+// are not retried past the latest deadline of its callers.
+func (future *Future) Wait(ctx context.Context) error {
+	_, err := future.promise.wait(ctx, future.requestType)
+
+	return err
+}
+
+// NewGateway returns a new *Gateway ready to process requests. Requests are
+// queued by priority, at most DefaultMaxGuildQueue per guild, and run by at
+// most DefaultWorkers workers, and failed with retryable errors are retried up
+// to DefaultMaxAttempts times, unless configured otherwise with the provided
+// options.
+func NewGateway(session *discordgo.Session, options ...OptionFunc) *Gateway {
+	gateway := &Gateway{
+		Session:  session,
+		mutex:    &sync.Mutex{},
+		inFlight: make(map[requestKey]*promise),
+		queue:    newWorkQueue(DefaultWorkers, DefaultMaxGuildQueue),
+		retryPolicy: RetryPolicy{
+			MaxAttempts: DefaultMaxAttempts,
+			BaseDelay:   DefaultRetryBaseDelay,
+			MaxDelay:    DefaultRetryMaxDelay,
+		},
+	}
+
+	for _, option := range options {
+		option(gateway)
+	}
+
+	return gateway
+}
+
+// CreateRole processes the provided request to create a role. The underlying
+// operation runs to completion even if every caller stops waiting, so its
+// result still updates the state cache.
+func (gateway *Gateway) CreateRole(request *CreateRoleRequest) *RoleFuture {
+	key := requestKey{
+		requestType: CreateRole,
+		guildID:     request.Guild.ID,
+		channelID:   request.ChannelID,
+		roleName:    request.RoleName,
+		roleColor:   request.RoleColor,
+		roleHoist:   request.RoleHoist,
+		roleMention: request.RoleMentionable,
+	}
+
+	return &RoleFuture{
+		requestType: CreateRole,
+		promise: gateway.process(request.Guild, key, request.Priority, func() (*discordgo.Role, error) {
+			return createRole(gateway.Session, request)
+		}),
+	}
+}
+
+// EditRole processes the provided request to edit a role.
+func (gateway *Gateway) EditRole(request *EditRoleRequest) *RoleFuture {
+	key := requestKey{
+		requestType: EditRole,
+		guildID:     request.Guild.ID,
+		roleID:      request.Role.ID,
+		roleName:    request.RoleName,
+		roleColor:   request.RoleColor,
+		roleHoist:   request.RoleHoist,
+		roleMention: request.RoleMentionable,
+	}
+
+	return &RoleFuture{
+		requestType: EditRole,
+		promise: gateway.process(request.Guild, key, request.Priority, func() (*discordgo.Role, error) {
+			return editRole(gateway.Session, request)
+		}),
 	}
+}
+
+// DeleteRole processes the provided request to delete a role.
+func (gateway *Gateway) DeleteRole(request *DeleteRoleRequest) *Future {
+	key := requestKey{
+		requestType: DeleteRole,
+		guildID:     request.Guild.ID,
+		roleID:      request.RoleID,
+	}
+
+	return &Future{
+		requestType: DeleteRole,
+		promise: gateway.process(request.Guild, key, request.Priority, func() (*discordgo.Role, error) {
+			return nil, deleteRole(gateway.Session, request.Guild, request.RoleID)
+		}),
+	}
+}
 
-	gateway.sendResult(key, role)
+// AddRole processes the provided request to add a role to a member.
+func (gateway *Gateway) AddRole(request *AddRoleRequest) *Future {
+	key := requestKey{
+		requestType: AddRole,
+		guildID:     request.Guild.ID,
+		userID:      request.UserID,
+		roleID:      request.RoleID,
+	}
+
+	return &Future{
+		requestType: AddRole,
+		promise: gateway.process(request.Guild, key, request.Priority, func() (*discordgo.Role, error) {
+			return nil, AddRoleToMember(gateway.Session, request.Guild.ID, request.UserID, request.RoleID)
+		}),
+	}
+}
+
+// RemoveRole processes the provided request to remove a role from a member.
+func (gateway *Gateway) RemoveRole(request *RemoveRoleRequest) *Future {
+	key := requestKey{
+		requestType: RemoveRole,
+		guildID:     request.Guild.ID,
+		userID:      request.UserID,
+		roleID:      request.RoleID,
+	}
+
+	return &Future{
+		requestType: RemoveRole,
+		promise: gateway.process(request.Guild, key, request.Priority, func() (*discordgo.Role, error) {
+			return nil, RemoveRoleFromMember(gateway.Session, request.Guild.ID, request.UserID, request.RoleID)
+		}),
+	}
 }
 
-func (gateway *Gateway) sendResult(key keyHash, result interface{}) {
+// SetRoles processes the provided request to replace the roles of a member.
+func (gateway *Gateway) SetRoles(request *SetRolesRequest) *Future {
+	key := requestKey{
+		requestType: SetRoles,
+		guildID:     request.Guild.ID,
+		userID:      request.UserID,
+		roleIDs:     strings.Join(request.RoleIDs, ","),
+	}
+
+	return &Future{
+		requestType: SetRoles,
+		promise: gateway.process(request.Guild, key, request.Priority, func() (*discordgo.Role, error) {
+			return nil, SetMemberRoles(gateway.Session, request.Guild.ID, request.UserID, request.RoleIDs)
+		}),
+	}
+}
+
+// process queues the provided operation unless an identical request is
+// already in flight, in which case the in flight promise is returned. The
+// promise is shared until the operation succeeds or stops being retried.
+func (gateway *Gateway) process(
+	guild *discordgo.Guild,
+	key requestKey,
+	priority Priority,
+	operation func() (*discordgo.Role, error),
+) *promise {
 	gateway.mutex.Lock()
 	defer gateway.mutex.Unlock()
 
-	defer delete(gateway.resultChannels, key)
-
-	for _, resultChannel := range gateway.resultChannels[key] {
-		resultChannel <- result
-		close(resultChannel)
+	inFlight, found := gateway.inFlight[key]
+	if found {
+		return inFlight
 	}
+
+	inFlight = newPromise()
+	gateway.inFlight[key] = inFlight
+
+	gateway.attempt(&pendingRequest{
+		key:       key,
+		guild:     guild,
+		priority:  priority,
+		operation: operation,
+		promise:   inFlight,
+	}, 1)
+
+	return inFlight
+}
+
+// resolve sets the result of the provided promise, releasing every caller
+// waiting on it.
+func (gateway *Gateway) resolve(key requestKey, inFlight *promise, role *discordgo.Role, err error) {
+	inFlight.role, inFlight.err = role, err
+
+	gateway.mutex.Lock()
+	delete(gateway.inFlight, key)
+	gateway.mutex.Unlock()
+
+	close(inFlight.done)
 }
 
 // LookupGuild returns a *discordgo.Guild from the session's internal state
@@ -192,6 +513,18 @@ func RemoveRoleFromMember(session *discordgo.Session, guildID, userID, roleID st
 	return nil
 }
 
+// SetMemberRoles replaces the roles of the user associated with the provided
+// userID with the roles associated with the provided roleIDs in a single
+// request, in the guild associated with the provided guildID.
+func SetMemberRoles(session *discordgo.Session, guildID, userID string, roleIDs []string) error {
+	err := session.GuildMemberEdit(guildID, userID, roleIDs)
+	if err != nil {
+		return fmt.Errorf("unable to swap ephemeral roles: %w", err)
+	}
+
+	return nil
+}
+
 // IsDeadlineExceeded checks if the provided error wraps
 // context.DeadlineExceeded.
 func IsDeadlineExceeded(err error) bool {
@@ -289,27 +622,60 @@ func updateStateGuilds(session *discordgo.Session, guildID string) (*discordgo.G
 	return guild, nil
 }
 
-func createRole(
-	session *discordgo.Session,
-	guild *discordgo.Guild,
-	roleName string,
-	roleColor int,
-) (*discordgo.Role, error) {
+func createRole(session *discordgo.Session, request *CreateRoleRequest) (*discordgo.Role, error) {
+	guild := request.Guild
+
 	role, err := session.GuildRoleCreate(guild.ID)
 	if err != nil {
 		return nil, fmt.Errorf("unable to create ephemeral role: %w", err)
 	}
 
-	role, err = session.GuildRoleEdit(
+	editedRole, err := session.GuildRoleEdit(
 		guild.ID, role.ID,
-		roleName, roleColor,
-		roleHoist, role.Permissions, roleMention,
+		request.RoleName, request.RoleColor,
+		request.RoleHoist, role.Permissions, request.RoleMentionable,
+	)
+	if err != nil {
+		return nil, rollbackCreateRole(session, guild, role.ID, fmt.Errorf("unable to edit ephemeral role: %w", err))
+	}
+
+	err = session.State.RoleAdd(guild.ID, editedRole)
+	if err != nil {
+		return nil, rollbackCreateRole(
+			session, guild, role.ID,
+			fmt.Errorf("unable to add ephemeral role to state cache: %w", err),
+		)
+	}
+
+	return editedRole, nil
+}
+
+// rollbackCreateRole deletes a role which was created but could not be set up
+// so it does not count against the guild's role limit. The returned error
+// wraps the provided error and reports the outcome of the rollback. Failed
+// rollbacks are never retried, so retries cannot leak further roles.
+func rollbackCreateRole(session *discordgo.Session, guild *discordgo.Guild, roleID string, err error) error {
+	rollbackErr := deleteRole(session, guild, roleID)
+	if rollbackErr != nil {
+		return &permanentError{
+			err: fmt.Errorf("%w: unable to roll back created role %s: %s", err, roleID, rollbackErr),
+		}
+	}
+
+	return fmt.Errorf("%w: rolled back created role %s", err, roleID)
+}
+
+func editRole(session *discordgo.Session, request *EditRoleRequest) (*discordgo.Role, error) {
+	role, err := session.GuildRoleEdit(
+		request.Guild.ID, request.Role.ID,
+		request.RoleName, request.RoleColor,
+		request.RoleHoist, request.Role.Permissions, request.RoleMentionable,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("unable to edit ephemeral role: %w", err)
 	}
 
-	err = session.State.RoleAdd(guild.ID, role)
+	err = session.State.RoleAdd(request.Guild.ID, role)
 	if err != nil {
 		return nil, fmt.Errorf("unable to add ephemeral role to state cache: %w", err)
 	}
@@ -317,6 +683,20 @@ func createRole(
 	return role, nil
 }
 
+func deleteRole(session *discordgo.Session, guild *discordgo.Guild, roleID string) error {
+	err := session.GuildRoleDelete(guild.ID, roleID)
+	if err != nil {
+		return fmt.Errorf("unable to delete ephemeral role: %w", err)
+	}
+
+	err = session.State.RoleRemove(guild.ID, roleID)
+	if err != nil && !errors.Is(err, discordgo.ErrStateNotFound) {
+		return fmt.Errorf("unable to remove ephemeral role from state cache: %w", err)
+	}
+
+	return nil
+}
+
 func recursiveGuildMembers(
 	session *discordgo.Session,
 	guildID, after string,
@@ -5,8 +5,12 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/ewohltman/discordgo-mock/mockconstants"
@@ -20,6 +24,12 @@ const newRoleID = "newRole"
 
 type sessionFunc func() *discordgo.Session
 
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (rt roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return rt(req)
+}
+
 type roleForMemberTestCase struct {
 	name       string
 	guildID    string
@@ -41,7 +51,7 @@ func TestNewGateway(t *testing.T) {
 	}
 }
 
-func TestGateway_Process(t *testing.T) {
+func TestGateway(t *testing.T) {
 	roleNames := []string{mockconstants.TestRole, mockconstants.TestRole + "2"}
 
 	session, err := mock.NewSession()
@@ -52,8 +62,6 @@ func TestGateway_Process(t *testing.T) {
 	gateway := operations.NewGateway(session)
 	waitGroup := &sync.WaitGroup{}
 
-	runTestRequestUnknown(t, gateway)
-
 	for _, roleName := range roleNames {
 		roleName := roleName
 
@@ -68,6 +76,193 @@ func TestGateway_Process(t *testing.T) {
 	}
 
 	waitGroup.Wait()
+
+	role, err := session.State.Role(mockconstants.TestGuild, mockconstants.TestRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runTestRequestEditRole(t, gateway, role)
+	runTestRequestAddRole(t, gateway, role)
+	runTestRequestRemoveRole(t, gateway, role)
+	runTestRequestSetRoles(t, gateway, role)
+	runTestRequestDeleteRole(t, gateway, role)
+}
+
+func TestGateway_CreateRole_rollback(t *testing.T) {
+	createRole := &mock.Failure{
+		Method:     http.MethodPost,
+		Path:       regexp.MustCompile(`/guilds/` + mockconstants.TestGuild + `/roles$`),
+		StatusCode: http.StatusInternalServerError,
+	}
+
+	editRole := &mock.Failure{
+		Method:     http.MethodPatch,
+		Path:       regexp.MustCompile(`/guilds/` + mockconstants.TestGuild + `/roles/.+$`),
+		StatusCode: http.StatusForbidden,
+	}
+
+	deleteRole := &mock.Failure{
+		Method:     http.MethodDelete,
+		Path:       regexp.MustCompile(`/guilds/` + mockconstants.TestGuild + `/roles/.+$`),
+		StatusCode: http.StatusInternalServerError,
+	}
+
+	testCases := []struct {
+		name          string
+		failures      []*mock.Failure
+		expectedError string
+		leftoverRoles int
+		forbidden     bool
+	}{
+		{
+			name:          "create fails",
+			failures:      []*mock.Failure{createRole},
+			expectedError: "unable to create ephemeral role",
+		},
+		{
+			name:          "edit fails",
+			failures:      []*mock.Failure{editRole},
+			expectedError: "rolled back created role",
+			forbidden:     true,
+		},
+		{
+			name:          "edit and rollback fail",
+			failures:      []*mock.Failure{editRole, deleteRole},
+			expectedError: "unable to roll back created role",
+			leftoverRoles: 1,
+			forbidden:     true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			session, err := mock.NewSession()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			session.Client.Transport = mock.NewFailureRoundTripper(session.Client.Transport, testCase.failures...)
+
+			guild, err := session.State.Guild(mockconstants.TestGuild)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			initialRoles := len(guild.Roles)
+
+			gateway := operations.NewGateway(session, operations.WithRetryPolicy(testRetryPolicy()))
+
+			_, resultErr := gateway.CreateRole(&operations.CreateRoleRequest{
+				Guild:    guild,
+				RoleName: mockconstants.TestRole + "Rollback",
+			}).Wait(context.Background())
+			if resultErr == nil {
+				t.Fatal("expected error creating role")
+			}
+
+			if !strings.Contains(resultErr.Error(), testCase.expectedError) {
+				t.Errorf("unexpected error: %s", resultErr)
+			}
+
+			isForbiddenResponse(t, testCase.forbidden, resultErr)
+
+			if leftoverRoles := len(guild.Roles) - initialRoles; leftoverRoles != testCase.leftoverRoles {
+				t.Errorf("unexpected number of leftover roles: %d", leftoverRoles)
+			}
+		})
+	}
+}
+
+func TestGateway_EditRole(t *testing.T) {
+	session, err := mock.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gateway := operations.NewGateway(session)
+
+	role, err := session.State.Role(mockconstants.TestGuild, mockconstants.TestRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	editedRole, err := gateway.EditRole(&operations.EditRoleRequest{
+		Guild:           &discordgo.Guild{ID: mockconstants.TestGuild},
+		Role:            role,
+		RoleName:        "edited",
+		RoleColor:       0x123456,
+		RoleHoist:       true,
+		RoleMentionable: true,
+	}).Wait(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if editedRole.Name != "edited" || editedRole.Color != 0x123456 || !editedRole.Hoist || !editedRole.Mentionable {
+		t.Errorf("Unexpected edited role: %+v", editedRole)
+	}
+
+	stateRole, err := session.State.Role(mockconstants.TestGuild, mockconstants.TestRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(stateRole, editedRole) {
+		t.Errorf("State cache not updated with edited role: %+v", stateRole)
+	}
+}
+
+func TestRoleFuture_Wait_cancellation(t *testing.T) {
+	session, err := mock.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release := make(chan struct{})
+	next := session.Client.Transport
+
+	session.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if req.Method == http.MethodPost {
+			<-release
+		}
+
+		return next.RoundTrip(req)
+	})
+
+	gateway := operations.NewGateway(session)
+	request := &operations.CreateRoleRequest{
+		Guild:    &discordgo.Guild{ID: mockconstants.TestGuild},
+		RoleName: mockconstants.TestRole + "Cancellation",
+	}
+
+	waitingFuture := gateway.CreateRole(request)
+	sharedFuture := gateway.CreateRole(&operations.CreateRoleRequest{
+		Guild:    &discordgo.Guild{ID: mockconstants.TestGuild},
+		RoleName: request.RoleName,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err = gateway.CreateRole(request).Wait(ctx)
+	if !operations.IsDeadlineExceeded(err) {
+		t.Errorf("expected deadline exceeded, got: %v", err)
+	}
+
+	close(release)
+
+	role, err := waitingFuture.Wait(context.Background())
+	if err != nil || role == nil {
+		t.Fatalf("expected role for caller still waiting after another caller cancelled: %v", err)
+	}
+
+	sharedRole, err := sharedFuture.Wait(context.Background())
+	if err != nil || sharedRole != role {
+		t.Errorf("expected identical requests to share one result: %v", err)
+	}
 }
 
 func TestLookupGuild(t *testing.T) {
@@ -109,6 +304,34 @@ func TestRemoveRoleFromMember(t *testing.T) {
 	runRoleForMemberTestCases(t, removeRoleFromMemberTestCases(getSession))
 }
 
+func TestSetMemberRoles(t *testing.T) {
+	session, err := mock.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roleIDs := []string{mockconstants.TestRole, newRoleID}
+
+	err = operations.SetMemberRoles(session, mockconstants.TestGuild, mockconstants.TestUser, roleIDs)
+	if err != nil {
+		t.Fatalf("unexpected error setting member roles: %s", err)
+	}
+
+	member, err := session.State.Member(mockconstants.TestGuild, mockconstants.TestUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(member.Roles, roleIDs) {
+		t.Errorf("unexpected member roles: %v", member.Roles)
+	}
+
+	err = operations.SetMemberRoles(session, mockconstants.TestGuild, "unknownUser", roleIDs)
+	if err == nil {
+		t.Error("expected error setting roles for unknown member")
+	}
+}
+
 func TestIsDeadlineExceeded(t *testing.T) {
 	if operations.IsDeadlineExceeded(io.EOF) {
 		t.Errorf("Unexpected success")
@@ -215,37 +438,66 @@ func TestBotHasChannelPermission(t *testing.T) {
 	}
 }
 
-func runTestRequestUnknown(t *testing.T, gateway callbacks.OperationsGateway) {
-	runTest(t, gateway, true, &operations.Request{
-		Type: operations.RequestType(-1),
-	})
+func runTestRequestCreateRole(t *testing.T, gateway callbacks.OperationsGateway, roleName string) {
+	_, err := gateway.CreateRole(&operations.CreateRoleRequest{
+		Guild:    &discordgo.Guild{ID: mockconstants.TestGuild},
+		RoleName: roleName,
+	}).Wait(context.Background())
+	checkRequestError(t, operations.CreateRole, err)
 }
 
-func runTestRequestCreateRole(t *testing.T, gateway callbacks.OperationsGateway, roleName string) {
-	runTest(t, gateway, false, &operations.Request{
-		Type: operations.CreateRole,
-		CreateRole: &operations.CreateRoleRequest{
-			Guild:    &discordgo.Guild{ID: mockconstants.TestGuild},
-			RoleName: roleName,
-		},
-	})
+func runTestRequestEditRole(t *testing.T, gateway callbacks.OperationsGateway, role *discordgo.Role) {
+	_, err := gateway.EditRole(&operations.EditRoleRequest{
+		Guild:    &discordgo.Guild{ID: mockconstants.TestGuild},
+		Role:     role,
+		RoleName: role.Name,
+	}).Wait(context.Background())
+
+	checkRequestError(t, operations.EditRole, err)
 }
 
-func runTest(t *testing.T, gateway callbacks.OperationsGateway, expectError bool, request *operations.Request) {
-	resultChannel := operations.NewResultChannel()
+func runTestRequestDeleteRole(t *testing.T, gateway callbacks.OperationsGateway, role *discordgo.Role) {
+	err := gateway.DeleteRole(&operations.DeleteRoleRequest{
+		Guild:  &discordgo.Guild{ID: mockconstants.TestGuild},
+		RoleID: role.ID,
+	}).Wait(context.Background())
 
-	gateway.Process(resultChannel, request)
+	checkRequestError(t, operations.DeleteRole, err)
+}
 
-	result := <-resultChannel
+func runTestRequestAddRole(t *testing.T, gateway callbacks.OperationsGateway, role *discordgo.Role) {
+	err := gateway.AddRole(&operations.AddRoleRequest{
+		Guild:  &discordgo.Guild{ID: mockconstants.TestGuild},
+		UserID: mockconstants.TestUser,
+		RoleID: role.ID,
+	}).Wait(context.Background())
 
-	_, resultError := result.(error)
-	if resultError != expectError {
-		if resultError {
-			t.Errorf("%s", result)
-			return
-		}
+	checkRequestError(t, operations.AddRole, err)
+}
 
-		t.Errorf("unexpected success for request type %q", request.Type)
+func runTestRequestRemoveRole(t *testing.T, gateway callbacks.OperationsGateway, role *discordgo.Role) {
+	err := gateway.RemoveRole(&operations.RemoveRoleRequest{
+		Guild:  &discordgo.Guild{ID: mockconstants.TestGuild},
+		UserID: mockconstants.TestUser,
+		RoleID: role.ID,
+	}).Wait(context.Background())
+
+	checkRequestError(t, operations.RemoveRole, err)
+}
+
+func runTestRequestSetRoles(t *testing.T, gateway callbacks.OperationsGateway, role *discordgo.Role) {
+	err := gateway.SetRoles(&operations.SetRolesRequest{
+		Guild:   &discordgo.Guild{ID: mockconstants.TestGuild},
+		UserID:  mockconstants.TestUser,
+		RoleIDs: []string{role.ID},
+	}).Wait(context.Background())
+
+	checkRequestError(t, operations.SetRoles, err)
+}
+
+func checkRequestError(t *testing.T, requestType operations.RequestType, err error) {
+	if err != nil {
+		t.Errorf("unexpected error for request type %q: %s", requestType, err)
 	}
 }
 
@@ -0,0 +1,149 @@
+package operations
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Permission problem descriptions.
+const (
+	MissingManageRolesProblem = "missing the Manage Roles permission"
+	RoleAboveBotProblem       = "role %s is not below the bot's highest role"
+)
+
+// PermissionDiagnosis is the result of analyzing whether the bot is able to
+// manage the ephemeral roles of a guild. Discord only allows the bot to
+// manage roles if it has the Manage Roles permission, and then only the roles
+// positioned below its highest role, unless it owns the guild.
+type PermissionDiagnosis struct {
+	GuildID           string
+	Owner             bool
+	ManageRoles       bool
+	TopRole           *discordgo.Role
+	UnmanageableRoles []*discordgo.Role
+}
+
+// InsufficientRolePermissions is an error for when a PermissionDiagnosis
+// found the bot unable to manage ephemeral roles.
+type InsufficientRolePermissions struct {
+	Diagnosis *PermissionDiagnosis
+}
+
+// Error satisfies the error interface.
+func (insufficientErr *InsufficientRolePermissions) Error() string {
+	return fmt.Sprintf(
+		"insufficient role permissions: %s",
+		strings.Join(insufficientErr.Diagnosis.Problems(), "; "),
+	)
+}
+
+// AnalyzePermissions returns a *PermissionDiagnosis of the bot's ability to
+// manage the roles of the provided guild whose names begin with the provided
+// rolePrefix.
+func AnalyzePermissions(session *discordgo.Session, guild *discordgo.Guild, rolePrefix string) (*PermissionDiagnosis, error) {
+	botMember, err := session.State.Member(guild.ID, session.State.User.ID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find bot member: %w", err)
+	}
+
+	session.State.RLock()
+	defer session.State.RUnlock()
+
+	diagnosis := &PermissionDiagnosis{
+		GuildID: guild.ID,
+		Owner:   guild.OwnerID != "" && guild.OwnerID == botMember.User.ID,
+	}
+
+	botRoles := make(map[string]bool, len(botMember.Roles))
+
+	for _, roleID := range botMember.Roles {
+		botRoles[roleID] = true
+	}
+
+	var permissions int64
+
+	for _, role := range guild.Roles {
+		if role.ID != guild.ID && !botRoles[role.ID] {
+			continue
+		}
+
+		permissions |= role.Permissions
+
+		if role.ID != guild.ID && (diagnosis.TopRole == nil || role.Position > diagnosis.TopRole.Position) {
+			diagnosis.TopRole = role
+		}
+	}
+
+	diagnosis.ManageRoles = diagnosis.Owner ||
+		permissions&discordgo.PermissionAdministrator == discordgo.PermissionAdministrator ||
+		permissions&discordgo.PermissionManageRoles == discordgo.PermissionManageRoles
+
+	for _, role := range guild.Roles {
+		if strings.HasPrefix(role.Name, rolePrefix) && !diagnosis.canManage(role) {
+			diagnosis.UnmanageableRoles = append(diagnosis.UnmanageableRoles, role)
+		}
+	}
+
+	sort.Slice(diagnosis.UnmanageableRoles, func(i, j int) bool {
+		return diagnosis.UnmanageableRoles[i].Position > diagnosis.UnmanageableRoles[j].Position
+	})
+
+	return diagnosis, nil
+}
+
+// OK returns whether the bot is able to manage every ephemeral role.
+func (diagnosis *PermissionDiagnosis) OK() bool {
+	return diagnosis.ManageRoles && len(diagnosis.UnmanageableRoles) == 0
+}
+
+// CanManage returns whether the bot is able to manage the role associated
+// with the provided roleID.
+func (diagnosis *PermissionDiagnosis) CanManage(roleID string) bool {
+	if !diagnosis.ManageRoles {
+		return false
+	}
+
+	for _, role := range diagnosis.UnmanageableRoles {
+		if role.ID == roleID {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Problems returns a description of each problem found by the diagnosis.
+func (diagnosis *PermissionDiagnosis) Problems() []string {
+	problems := make([]string, 0, len(diagnosis.UnmanageableRoles)+1)
+
+	if !diagnosis.ManageRoles {
+		problems = append(problems, MissingManageRolesProblem)
+	}
+
+	for _, role := range diagnosis.UnmanageableRoles {
+		problems = append(problems, fmt.Sprintf(RoleAboveBotProblem, role.Name))
+	}
+
+	return problems
+}
+
+// Err returns an *InsufficientRolePermissions error if the diagnosis found
+// any problems, or nil otherwise.
+func (diagnosis *PermissionDiagnosis) Err() error {
+	if diagnosis.OK() {
+		return nil
+	}
+
+	return &InsufficientRolePermissions{Diagnosis: diagnosis}
+}
+
+func (diagnosis *PermissionDiagnosis) canManage(role *discordgo.Role) bool {
+	if diagnosis.Owner {
+		return true
+	}
+
+	return diagnosis.TopRole != nil && role.Position < diagnosis.TopRole.Position
+}
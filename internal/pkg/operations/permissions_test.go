@@ -0,0 +1,126 @@
+package operations_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ewohltman/discordgo-mock/mockconstants"
+
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/mock"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/operations"
+)
+
+const testRolePrefix = "{eph}"
+
+func TestAnalyzePermissions(t *testing.T) {
+	ephemeralRoleID := fmt.Sprintf("%s %s", testRolePrefix, mockconstants.TestChannel)
+
+	testCases := []struct {
+		name        string
+		setup       func(guild *discordgo.Guild, botRole, ephemeralRole *discordgo.Role)
+		manageRoles bool
+		problems    int
+	}{
+		{
+			name:        "ok",
+			setup:       func(guild *discordgo.Guild, botRole, ephemeralRole *discordgo.Role) {},
+			manageRoles: true,
+		},
+		{
+			name: "missing Manage Roles",
+			setup: func(guild *discordgo.Guild, botRole, ephemeralRole *discordgo.Role) {
+				botRole.Permissions = discordgo.PermissionViewChannel
+			},
+			problems: 1,
+		},
+		{
+			name: "administrator",
+			setup: func(guild *discordgo.Guild, botRole, ephemeralRole *discordgo.Role) {
+				botRole.Permissions = discordgo.PermissionAdministrator
+			},
+			manageRoles: true,
+		},
+		{
+			name: "role above the bot",
+			setup: func(guild *discordgo.Guild, botRole, ephemeralRole *discordgo.Role) {
+				ephemeralRole.Position = botRole.Position
+			},
+			manageRoles: true,
+			problems:    1,
+		},
+		{
+			name: "owner",
+			setup: func(guild *discordgo.Guild, botRole, ephemeralRole *discordgo.Role) {
+				guild.OwnerID = mockconstants.TestUser + "Bot"
+				botRole.Permissions = discordgo.PermissionViewChannel
+				ephemeralRole.Position = botRole.Position + 1
+			},
+			manageRoles: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			session, err := mock.NewSession()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			guild, err := session.State.Guild(mockconstants.TestGuild)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			botRole, err := session.State.Role(guild.ID, mock.BotRole)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ephemeralRole, err := session.State.Role(guild.ID, ephemeralRoleID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			testCase.setup(guild, botRole, ephemeralRole)
+
+			diagnosis, err := operations.AnalyzePermissions(session, guild, testRolePrefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diagnosis.ManageRoles != testCase.manageRoles {
+				t.Errorf("unexpected Manage Roles result: %t", diagnosis.ManageRoles)
+			}
+
+			if len(diagnosis.Problems()) != testCase.problems {
+				t.Errorf("unexpected problems: %v", diagnosis.Problems())
+			}
+
+			if diagnosis.OK() != (testCase.problems == 0) || diagnosis.CanManage(ephemeralRoleID) != diagnosis.OK() {
+				t.Errorf("unexpected diagnosis: %+v", diagnosis)
+			}
+
+			var insufficientErr *operations.InsufficientRolePermissions
+
+			if errors.As(diagnosis.Err(), &insufficientErr) != (testCase.problems != 0) {
+				t.Errorf("unexpected diagnosis error: %v", diagnosis.Err())
+			}
+		})
+	}
+}
+
+func TestAnalyzePermissions_botMemberNotFound(t *testing.T) {
+	session, err := mock.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = operations.AnalyzePermissions(session, &discordgo.Guild{ID: "unknownGuild"}, testRolePrefix)
+	if err == nil {
+		t.Error("expected error analyzing permissions without a bot member")
+	}
+}
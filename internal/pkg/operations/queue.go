@@ -0,0 +1,257 @@
+package operations
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Priority enumerations. Requests default to PriorityInteractive.
+const (
+	PriorityInteractive Priority = iota
+	PriorityBackground
+
+	numPriorities = iota
+)
+
+// Priority string representations.
+const (
+	PriorityInteractiveString = "interactive"
+	PriorityBackgroundString  = "background"
+)
+
+// DefaultWorkers is the default number of operations run at the same time.
+const DefaultWorkers = 10
+
+// DefaultMaxGuildQueue is the default maximum number of operations queued for
+// a single guild.
+const DefaultMaxGuildQueue = 1000
+
+// Priority is the priority of an operations request. Queued requests with a
+// higher priority, such as those made in response to members joining and
+// leaving voice channels, run before any queued requests with a lower
+// priority, such as garbage collection and reconciliation.
+type Priority int
+
+// String returns the string representation for the given Priority.
+func (priority Priority) String() string {
+	switch priority {
+	case PriorityInteractive:
+		return PriorityInteractiveString
+	case PriorityBackground:
+		return PriorityBackgroundString
+	default:
+		return UnknownString
+	}
+}
+
+// QueueFull is an error for requests shed because too many operations are
+// already queued for their guild.
+type QueueFull struct {
+	GuildID string
+}
+
+// Error satisfies the error interface.
+func (queueFull *QueueFull) Error() string {
+	return fmt.Sprintf("operations queue full for guild %s", queueFull.GuildID)
+}
+
+// job is a queued operation. shed is called instead of run if the job is
+// dropped from a full queue.
+type job struct {
+	guildID  string
+	priority Priority
+	queuedAt time.Time
+	run      func()
+	shed     func(error)
+}
+
+// guildQueues holds the queued jobs of one priority, partitioned by guild.
+// Guilds take turns in round-robin order so one guild with many queued jobs
+// cannot starve the others.
+type guildQueues struct {
+	jobs  map[string][]*job
+	order []string
+}
+
+// workQueue runs queued jobs on a bounded number of workers. Workers are
+// started as jobs are queued and exit once the queue is empty. Each guild may
+// have at most maxGuildQueue jobs queued across all priorities.
+type workQueue struct {
+	mutex         *sync.Mutex
+	maxWorkers    int
+	workers       int
+	maxGuildQueue int
+	guildQueued   map[string]int
+	queues        [numPriorities]*guildQueues
+	queueDepth    *prometheus.GaugeVec
+	waitTime      *prometheus.HistogramVec
+}
+
+func newWorkQueue(maxWorkers, maxGuildQueue int) *workQueue {
+	queue := &workQueue{
+		mutex:         &sync.Mutex{},
+		maxWorkers:    maxWorkers,
+		maxGuildQueue: maxGuildQueue,
+		guildQueued:   make(map[string]int),
+	}
+
+	for i := range queue.queues {
+		queue.queues[i] = &guildQueues{jobs: make(map[string][]*job)}
+	}
+
+	return queue
+}
+
+// enqueue queues run for the provided guild. If the guild's queue is full,
+// background jobs are shed, and interactive jobs take the place of the
+// guild's most recently queued background job. Shed jobs have shed called
+// with a *QueueFull error instead of run, asynchronously as the caller may
+// hold locks shed needs.
+func (queue *workQueue) enqueue(guildID string, priority Priority, run func(), shed func(error)) {
+	if priority < 0 || priority >= numPriorities {
+		priority = PriorityBackground
+	}
+
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	if queue.maxGuildQueue > 0 && queue.guildQueued[guildID] >= queue.maxGuildQueue {
+		var shedJob *job
+
+		if priority != PriorityBackground {
+			shedJob = queue.dequeueLast(guildID, PriorityBackground)
+		}
+
+		if shedJob == nil {
+			go shed(&QueueFull{GuildID: guildID})
+			return
+		}
+
+		go shedJob.shed(&QueueFull{GuildID: guildID})
+	}
+
+	guildQueues := queue.queues[priority]
+
+	if len(guildQueues.jobs[guildID]) == 0 {
+		guildQueues.order = append(guildQueues.order, guildID)
+	}
+
+	guildQueues.jobs[guildID] = append(guildQueues.jobs[guildID], &job{
+		guildID:  guildID,
+		priority: priority,
+		queuedAt: time.Now(),
+		run:      run,
+		shed:     shed,
+	})
+
+	queue.guildQueued[guildID]++
+
+	if queue.queueDepth != nil {
+		queue.queueDepth.WithLabelValues(priority.String()).Inc()
+	}
+
+	if queue.workers < queue.maxWorkers {
+		queue.workers++
+
+		go queue.work()
+	}
+}
+
+func (queue *workQueue) work() {
+	for {
+		queue.mutex.Lock()
+
+		next := queue.next()
+		if next == nil {
+			queue.workers--
+			queue.mutex.Unlock()
+
+			return
+		}
+
+		queue.mutex.Unlock()
+
+		if queue.waitTime != nil {
+			queue.waitTime.WithLabelValues(next.priority.String()).Observe(time.Since(next.queuedAt).Seconds())
+		}
+
+		next.run()
+	}
+}
+
+// next dequeues the next job from the highest priority with queued jobs,
+// rotating through guilds. The caller must hold the queue's mutex.
+func (queue *workQueue) next() *job {
+	for priority, guildQueues := range queue.queues {
+		if len(guildQueues.order) == 0 {
+			continue
+		}
+
+		guildID := guildQueues.order[0]
+		guildJobs := guildQueues.jobs[guildID]
+		next := guildJobs[0]
+
+		guildQueues.order = guildQueues.order[1:]
+
+		if len(guildJobs) == 1 {
+			delete(guildQueues.jobs, guildID)
+		} else {
+			guildQueues.jobs[guildID] = guildJobs[1:]
+			guildQueues.order = append(guildQueues.order, guildID)
+		}
+
+		queue.dequeued(guildID, Priority(priority))
+
+		return next
+	}
+
+	return nil
+}
+
+// dequeueLast removes and returns the most recently queued job of the
+// provided guild and priority, or nil if there is none. The caller must hold
+// the queue's mutex.
+func (queue *workQueue) dequeueLast(guildID string, priority Priority) *job {
+	guildQueues := queue.queues[priority]
+	guildJobs := guildQueues.jobs[guildID]
+
+	if len(guildJobs) == 0 {
+		return nil
+	}
+
+	last := guildJobs[len(guildJobs)-1]
+
+	if len(guildJobs) == 1 {
+		delete(guildQueues.jobs, guildID)
+
+		for i, queuedGuildID := range guildQueues.order {
+			if queuedGuildID == guildID {
+				guildQueues.order = append(guildQueues.order[:i], guildQueues.order[i+1:]...)
+				break
+			}
+		}
+	} else {
+		guildQueues.jobs[guildID] = guildJobs[:len(guildJobs)-1]
+	}
+
+	queue.dequeued(guildID, priority)
+
+	return last
+}
+
+// dequeued accounts for a job of the provided guild and priority leaving the
+// queue. The caller must hold the queue's mutex.
+func (queue *workQueue) dequeued(guildID string, priority Priority) {
+	queue.guildQueued[guildID]--
+
+	if queue.guildQueued[guildID] <= 0 {
+		delete(queue.guildQueued, guildID)
+	}
+
+	if queue.queueDepth != nil {
+		queue.queueDepth.WithLabelValues(priority.String()).Dec()
+	}
+}
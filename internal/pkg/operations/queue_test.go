@@ -0,0 +1,196 @@
+package operations_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ewohltman/discordgo-mock/mockconstants"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/mock"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/operations"
+)
+
+func TestPriority_String(t *testing.T) {
+	priorities := map[operations.Priority]string{
+		operations.PriorityInteractive: operations.PriorityInteractiveString,
+		operations.PriorityBackground:  operations.PriorityBackgroundString,
+		operations.Priority(-1):        operations.UnknownString,
+	}
+
+	for priority, expected := range priorities {
+		if priority.String() != expected {
+			t.Errorf("unexpected string for priority %d: %s", priority, priority)
+		}
+	}
+}
+
+func TestGateway_queue(t *testing.T) {
+	session, err := mock.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mutex    sync.Mutex
+		requests []string
+		once     sync.Once
+	)
+
+	blocked := make(chan struct{})
+	release := make(chan struct{})
+	next := session.Client.Transport
+
+	session.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		once.Do(func() {
+			close(blocked)
+			<-release
+		})
+
+		mutex.Lock()
+		requests = append(requests, path.Base(req.URL.Path))
+		mutex.Unlock()
+
+		return next.RoundTrip(req)
+	})
+
+	queueDepth := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_queue_depth"}, []string{"priority"})
+	waitTime := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "test_queue_wait"}, []string{"priority"})
+
+	gateway := operations.NewGateway(
+		session,
+		operations.WithWorkers(1),
+		operations.WithQueueMetrics(queueDepth, waitTime),
+	)
+
+	addRole := func(guildID, roleID string, priority operations.Priority) *operations.Future {
+		return gateway.AddRole(&operations.AddRoleRequest{
+			Guild:    &discordgo.Guild{ID: guildID},
+			UserID:   mockconstants.TestUser,
+			RoleID:   roleID,
+			Priority: priority,
+		})
+	}
+
+	futures := []*operations.Future{addRole(mockconstants.TestGuild, "blocking", operations.PriorityInteractive)}
+
+	<-blocked
+
+	futures = append(
+		futures,
+		addRole(mockconstants.TestGuild, "background", operations.PriorityBackground),
+		addRole(mockconstants.TestGuild, "large1", operations.PriorityInteractive),
+		addRole(mockconstants.TestGuild, "large2", operations.PriorityInteractive),
+		addRole(mockconstants.TestGuild, "large3", operations.PriorityInteractive),
+		addRole(mockconstants.TestGuildLarge, "small1", operations.PriorityInteractive),
+	)
+
+	interactiveDepth := testutil.ToFloat64(queueDepth.WithLabelValues(operations.PriorityInteractiveString))
+	if interactiveDepth != 4 {
+		t.Errorf("unexpected interactive queue depth: %v", interactiveDepth)
+	}
+
+	close(release)
+
+	for _, future := range futures {
+		_ = future.Wait(context.Background())
+	}
+
+	expected := []string{"blocking", "large1", "small1", "large2", "large3", "background"}
+
+	if !reflect.DeepEqual(requests, expected) {
+		t.Errorf("unexpected request order: %v", requests)
+	}
+
+	if testutil.ToFloat64(queueDepth.WithLabelValues(operations.PriorityBackgroundString)) != 0 {
+		t.Error("unexpected background requests remaining in queue")
+	}
+
+	if testutil.CollectAndCount(waitTime) != 2 {
+		t.Error("expected wait times observed for both priorities")
+	}
+}
+
+func TestGateway_maxGuildQueue(t *testing.T) {
+	session, err := mock.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mutex    sync.Mutex
+		requests []string
+		once     sync.Once
+	)
+
+	blocked := make(chan struct{})
+	release := make(chan struct{})
+	next := session.Client.Transport
+
+	session.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		once.Do(func() {
+			close(blocked)
+			<-release
+		})
+
+		mutex.Lock()
+		requests = append(requests, path.Base(req.URL.Path))
+		mutex.Unlock()
+
+		return next.RoundTrip(req)
+	})
+
+	gateway := operations.NewGateway(session, operations.WithWorkers(1), operations.WithMaxGuildQueue(2))
+
+	addRole := func(guildID, roleID string, priority operations.Priority) *operations.Future {
+		return gateway.AddRole(&operations.AddRoleRequest{
+			Guild:    &discordgo.Guild{ID: guildID},
+			UserID:   mockconstants.TestUser,
+			RoleID:   roleID,
+			Priority: priority,
+		})
+	}
+
+	blocking := addRole(mockconstants.TestGuild, "blocking", operations.PriorityInteractive)
+
+	<-blocked
+
+	background1 := addRole(mockconstants.TestGuild, "background1", operations.PriorityBackground)
+	background2 := addRole(mockconstants.TestGuild, "background2", operations.PriorityBackground)
+	background3 := addRole(mockconstants.TestGuild, "background3", operations.PriorityBackground)
+	interactive := addRole(mockconstants.TestGuild, "interactive", operations.PriorityInteractive)
+	otherGuild := addRole(mockconstants.TestGuildLarge, "otherGuild", operations.PriorityBackground)
+
+	// The full queue sheds further background requests, and interactive
+	// requests take the place of the most recently queued one
+	for roleID, future := range map[string]*operations.Future{"background2": background2, "background3": background3} {
+		var queueFull *operations.QueueFull
+
+		err = future.Wait(context.Background())
+		if !errors.As(err, &queueFull) || queueFull.GuildID != mockconstants.TestGuild {
+			t.Errorf("unexpected error for shed request %s: %v", roleID, err)
+		}
+	}
+
+	close(release)
+
+	for _, future := range []*operations.Future{blocking, background1, interactive, otherGuild} {
+		err = future.Wait(context.Background())
+		if err != nil {
+			t.Errorf("unexpected error for queued request: %s", err)
+		}
+	}
+
+	expected := []string{"blocking", "interactive", "background1", "otherGuild"}
+
+	if !reflect.DeepEqual(requests, expected) {
+		t.Errorf("unexpected request order: %v", requests)
+	}
+}
@@ -0,0 +1,190 @@
+package operations
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Attempt result label values.
+const (
+	AttemptSuccess        = "success"
+	AttemptRetryableError = "retryable_error"
+	AttemptPermanentError = "permanent_error"
+	AttemptCircuitOpen    = "circuit_open"
+)
+
+// Default retry policy for operations failing with transient errors.
+const (
+	DefaultMaxAttempts    = 4
+	DefaultRetryBaseDelay = 250 * time.Millisecond
+	DefaultRetryMaxDelay  = 5 * time.Second
+)
+
+// RetryPolicy configures how operations failing with retryable errors are
+// retried. The delay before each retry grows exponentially from BaseDelay,
+// capped at MaxDelay, and is jittered so requests failing together do not
+// retry together.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// Backoff returns the jittered delay before the retry following the provided
+// attempt, counting from 1. The delay is between half of and the full
+// exponential delay for the attempt.
+func (policy RetryPolicy) Backoff(attempt int) time.Duration {
+	delay := policy.BaseDelay
+
+	for i := 1; i < attempt && (policy.MaxDelay <= 0 || delay < policy.MaxDelay); i++ {
+		delay *= 2
+	}
+
+	if policy.MaxDelay > 0 && delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+
+	if delay < 2 {
+		return delay
+	}
+
+	half := delay / 2
+
+	return half + time.Duration(rand.Int63n(int64(delay-half))) //nolint:gosec // Jitter does not need a secure source
+}
+
+// permanentError marks an error which must not be retried regardless of the
+// error it wraps.
+type permanentError struct {
+	err error
+}
+
+func (permanentErr *permanentError) Error() string {
+	return permanentErr.err.Error()
+}
+
+func (permanentErr *permanentError) Unwrap() error {
+	return permanentErr.err
+}
+
+// IsRetryable checks if the provided error is transient and the operation
+// failing with it may succeed if retried. Server errors, timeouts and reset
+// connections are retryable. Permanent errors, such as those checked by
+// IsForbiddenResponse and IsMaxGuildsResponse, are not.
+func IsRetryable(err error) bool {
+	var (
+		permanentErr *permanentError
+		restErr      *discordgo.RESTError
+		netErr       net.Error
+	)
+
+	switch {
+	case err == nil:
+		return false
+	case errors.As(err, &permanentErr):
+		return false
+	case IsForbiddenResponse(err), IsMaxGuildsResponse(err):
+		return false
+	case errors.As(err, &restErr):
+		return restErr.Response != nil && restErr.Response.StatusCode >= http.StatusInternalServerError
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return true
+	case errors.Is(err, syscall.ECONNRESET):
+		return true
+	default:
+		return false
+	}
+}
+
+// pendingRequest is an operation queued on behalf of every caller of a
+// request.
+type pendingRequest struct {
+	key       requestKey
+	guild     *discordgo.Guild
+	priority  Priority
+	operation func() (*discordgo.Role, error)
+	promise   *promise
+}
+
+// attempt queues an attempt of the provided request. If the attempt fails
+// with a retryable error, another attempt is queued after a backoff unless
+// the retry policy is exhausted or the backoff would outlast the deadline of
+// every caller waiting on the request. Requests in guilds with an open
+// circuit, or shed from a full queue, fail without being attempted.
+func (gateway *Gateway) attempt(pending *pendingRequest, attempt int) {
+	gateway.queue.enqueue(pending.guild.ID, pending.priority, func() {
+		err := gateway.breaker.Allow(pending.guild.ID)
+		if err != nil {
+			gateway.observeAttempt(pending.key.requestType, attempt, AttemptCircuitOpen)
+			gateway.resolve(pending.key, pending.promise, nil, retriesExhausted(pending.key.requestType, attempt, err))
+
+			return
+		}
+
+		role, err := pending.operation()
+
+		gateway.breaker.Record(pending.guild, gateway.breakerResult(pending.guild, err))
+
+		retryable := IsRetryable(err)
+
+		gateway.observeAttempt(pending.key.requestType, attempt, attemptResult(err, retryable))
+
+		if retryable && attempt < gateway.retryPolicy.MaxAttempts {
+			delay := gateway.retryPolicy.Backoff(attempt)
+
+			if pending.promise.allowsRetry(time.Now().Add(delay)) {
+				time.AfterFunc(delay, func() {
+					if !pending.promise.allowsRetry(time.Now()) {
+						gateway.resolve(pending.key, pending.promise, role, retriesExhausted(pending.key.requestType, attempt, err))
+						return
+					}
+
+					gateway.attempt(pending, attempt+1)
+				})
+
+				return
+			}
+		}
+
+		gateway.resolve(pending.key, pending.promise, role, retriesExhausted(pending.key.requestType, attempt, err))
+	}, func(err error) {
+		gateway.resolve(pending.key, pending.promise, nil, retriesExhausted(pending.key.requestType, attempt, err))
+	})
+}
+
+func attemptResult(err error, retryable bool) string {
+	switch {
+	case retryable:
+		return AttemptRetryableError
+	case err != nil:
+		return AttemptPermanentError
+	default:
+		return AttemptSuccess
+	}
+}
+
+func (gateway *Gateway) observeAttempt(requestType RequestType, attempt int, result string) {
+	if gateway.attempts == nil {
+		return
+	}
+
+	gateway.attempts.WithLabelValues(requestType.String(), strconv.Itoa(attempt), result).Inc()
+}
+
+// retriesExhausted wraps the error of the last attempt of a request with the
+// number of attempts made, if more than one.
+func retriesExhausted(requestType RequestType, attempts int, err error) error {
+	if err == nil || attempts == 1 {
+		return err
+	}
+
+	return fmt.Errorf("%s failed after %d attempts: %w", requestType, attempts, err)
+}
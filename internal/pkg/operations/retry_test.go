@@ -0,0 +1,287 @@
+package operations_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ewohltman/discordgo-mock/mockconstants"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/mock"
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/operations"
+)
+
+const testRetryDelay = time.Millisecond
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func testRetryPolicy() operations.RetryPolicy {
+	return operations.RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   testRetryDelay,
+		MaxDelay:    4 * testRetryDelay,
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := operations.RetryPolicy{
+		BaseDelay: 100 * time.Millisecond,
+		MaxDelay:  time.Second,
+	}
+
+	expectedDelays := map[int]time.Duration{
+		1: 100 * time.Millisecond,
+		2: 200 * time.Millisecond,
+		3: 400 * time.Millisecond,
+		4: 800 * time.Millisecond,
+		5: time.Second,
+		9: time.Second,
+	}
+
+	for attempt, expectedDelay := range expectedDelays {
+		for i := 0; i < 10; i++ {
+			delay := policy.Backoff(attempt)
+
+			if delay < expectedDelay/2 || delay > expectedDelay {
+				t.Errorf("unexpected backoff for attempt %d: %s", attempt, delay)
+			}
+		}
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	testCases := []struct {
+		name     string
+		expected bool
+		err      error
+	}{
+		{
+			name:     "nil error",
+			expected: false,
+			err:      nil,
+		},
+		{
+			name:     "non-nil error",
+			expected: false,
+			err:      io.EOF,
+		},
+		{
+			name:     "*discordgo.RESTError http.StatusBadGateway",
+			expected: true,
+			err:      fmt.Errorf("%w", &discordgo.RESTError{Response: &http.Response{StatusCode: http.StatusBadGateway}}),
+		},
+		{
+			name:     "*discordgo.RESTError http.StatusNotFound",
+			expected: false,
+			err:      &discordgo.RESTError{Response: &http.Response{StatusCode: http.StatusNotFound}},
+		},
+		{
+			name:     "*discordgo.RESTError http.StatusForbidden",
+			expected: false,
+			err:      &discordgo.RESTError{Response: &http.Response{StatusCode: http.StatusForbidden}},
+		},
+		{
+			name:     "max roles",
+			expected: false,
+			err: &discordgo.RESTError{
+				Response: &http.Response{StatusCode: http.StatusBadRequest},
+				Message:  &discordgo.APIErrorMessage{Code: operations.APIErrorCodeMaxRoles},
+			},
+		},
+		{
+			name:     "timeout",
+			expected: true,
+			err:      &url.Error{Op: http.MethodPost, URL: "/", Err: timeoutError{}},
+		},
+		{
+			name:     "connection reset",
+			expected: true,
+			err: &url.Error{
+				Op:  http.MethodPost,
+				URL: "/",
+				Err: &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)},
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			if operations.IsRetryable(testCase.err) != testCase.expected {
+				t.Errorf("unexpected retryable result for error: %v", testCase.err)
+			}
+		})
+	}
+}
+
+func TestGateway_retry(t *testing.T) {
+	createRolePath := regexp.MustCompile(`/guilds/` + mockconstants.TestGuild + `/roles$`)
+
+	testCases := []struct {
+		name     string
+		failure  *mock.Failure
+		attempts map[string]string
+		failed   bool
+	}{
+		{
+			name: "transient failure",
+			failure: &mock.Failure{
+				Method:     http.MethodPost,
+				Path:       createRolePath,
+				StatusCode: http.StatusServiceUnavailable,
+				Times:      2,
+			},
+			attempts: map[string]string{
+				"1": operations.AttemptRetryableError,
+				"2": operations.AttemptRetryableError,
+				"3": operations.AttemptSuccess,
+			},
+		},
+		{
+			name: "persistent failure",
+			failure: &mock.Failure{
+				Method:     http.MethodPost,
+				Path:       createRolePath,
+				StatusCode: http.StatusInternalServerError,
+			},
+			attempts: map[string]string{
+				"1": operations.AttemptRetryableError,
+				"2": operations.AttemptRetryableError,
+				"3": operations.AttemptRetryableError,
+			},
+			failed: true,
+		},
+		{
+			name: "permanent failure",
+			failure: &mock.Failure{
+				Method:     http.MethodPost,
+				Path:       createRolePath,
+				StatusCode: http.StatusForbidden,
+			},
+			attempts: map[string]string{
+				"1": operations.AttemptPermanentError,
+			},
+			failed: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			session, err := mock.NewSession()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			session.Client.Transport = mock.NewFailureRoundTripper(session.Client.Transport, testCase.failure)
+
+			attempts := prometheus.NewCounterVec(
+				prometheus.CounterOpts{Name: "test_attempts"},
+				[]string{"operation", "attempt", "result"},
+			)
+
+			gateway := operations.NewGateway(
+				session,
+				operations.WithRetryPolicy(testRetryPolicy()),
+				operations.WithAttemptMetrics(attempts),
+			)
+
+			_, err = gateway.CreateRole(&operations.CreateRoleRequest{
+				Guild:    &discordgo.Guild{ID: mockconstants.TestGuild},
+				RoleName: mockconstants.TestRole + "Retry",
+			}).Wait(context.Background())
+			if (err != nil) != testCase.failed {
+				t.Errorf("unexpected error: %v", err)
+			}
+
+			if count := testutil.CollectAndCount(attempts); count != len(testCase.attempts) {
+				t.Errorf("unexpected number of attempts: %d", count)
+			}
+
+			for attempt, result := range testCase.attempts {
+				counter := attempts.WithLabelValues(operations.CreateRoleString, attempt, result)
+
+				if testutil.ToFloat64(counter) != 1 {
+					t.Errorf("expected attempt %s with result %s", attempt, result)
+				}
+			}
+		})
+	}
+}
+
+func TestGateway_retry_deadline(t *testing.T) {
+	session, err := mock.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failures := mock.NewFailureRoundTripper(session.Client.Transport, &mock.Failure{
+		Method:     http.MethodPost,
+		Path:       regexp.MustCompile(`/guilds/` + mockconstants.TestGuild + `/roles$`),
+		StatusCode: http.StatusInternalServerError,
+	})
+
+	// Respond slowly enough for the caller to be waiting on the request
+	session.Client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		time.Sleep(10 * time.Millisecond)
+
+		return failures.RoundTrip(req)
+	})
+
+	attempts := prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "test_attempts"},
+		[]string{"operation", "attempt", "result"},
+	)
+
+	gateway := operations.NewGateway(
+		session,
+		operations.WithRetryPolicy(operations.RetryPolicy{
+			MaxAttempts: 3,
+			BaseDelay:   time.Second,
+			MaxDelay:    time.Second,
+		}),
+		operations.WithAttemptMetrics(attempts),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+
+	_, err = gateway.CreateRole(&operations.CreateRoleRequest{
+		Guild:    &discordgo.Guild{ID: mockconstants.TestGuild},
+		RoleName: mockconstants.TestRole + "Deadline",
+	}).Wait(ctx)
+	if err == nil {
+		t.Fatal("expected error creating role")
+	}
+
+	if operations.IsDeadlineExceeded(err) {
+		t.Errorf("expected the request to fail before the deadline instead of retrying past it: %s", err)
+	}
+
+	if elapsed := time.Since(start); elapsed >= 100*time.Millisecond {
+		t.Errorf("unexpected wait for a retry past the deadline: %s", elapsed)
+	}
+
+	if count := testutil.CollectAndCount(attempts); count != 1 {
+		t.Errorf("unexpected number of attempts: %d", count)
+	}
+}
@@ -0,0 +1,183 @@
+package rolemap
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/atomicfile"
+)
+
+const (
+	fileExtension   = ".json"
+	dirPermissions  = 0o700
+	filePermissions = 0o600
+)
+
+//nolint:gochecknoglobals // override stdlib json package
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+// mapping is the persisted form of a channel to ephemeral role mapping.
+type mapping struct {
+	ChannelID string `json:"channelID"`
+	RoleID    string `json:"roleID"`
+}
+
+// Load returns a new *Store persisting the mappings of each guild to its own
+// JSON file in the directory at the provided dir, loading the mappings
+// already stored there. The directory is created if it does not exist.
+// Mappings stay usable in memory if persisting them fails, so such errors are
+// passed to the provided onSaveError instead of being returned.
+func Load(dir string, onSaveError func(error)) (*Store, error) {
+	store := New()
+	store.dir = dir
+	store.onSaveError = onSaveError
+
+	err := os.MkdirAll(dir, dirPermissions)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create role map directory: %w", err)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read role map directory: %w", err)
+	}
+
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != fileExtension {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read role map file: %w", err)
+		}
+
+		var mappings []mapping
+
+		err = json.Unmarshal(data, &mappings)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse role map file %s: %w", file.Name(), err)
+		}
+
+		channels := make(map[string]string, len(mappings))
+
+		for _, mapping := range mappings {
+			channels[mapping.ChannelID] = mapping.RoleID
+		}
+
+		store.guilds[strings.TrimSuffix(file.Name(), fileExtension)] = channels
+	}
+
+	return store, nil
+}
+
+// guildWriter serializes persisting the mappings of a guild.
+type guildWriter struct {
+	mutex   sync.Mutex
+	written uint64
+}
+
+// save persists the latest mappings of the guild associated with the provided
+// guildID if the store is persisted. The caller must not hold the lock. Each
+// guild's writes are serialized and always take a fresh snapshot of its
+// mappings, so older mappings never replace newer ones on disk, and writes
+// queued behind one which already persisted the latest mappings are skipped.
+func (store *Store) save(guildID string) {
+	if store.dir == "" {
+		return
+	}
+
+	writer := store.guildWriter(guildID)
+
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	store.mutex.RLock()
+	version := store.versions[guildID]
+	mappings := guildMappings(store.guilds[guildID])
+	store.mutex.RUnlock()
+
+	if version == writer.written {
+		return
+	}
+
+	err := store.writeGuild(guildID, mappings)
+	if err != nil {
+		if store.onSaveError != nil {
+			store.onSaveError(err)
+		}
+
+		return
+	}
+
+	writer.written = version
+}
+
+// guildWriter returns the *guildWriter of the guild associated with the
+// provided guildID, adding it if the guild has none.
+func (store *Store) guildWriter(guildID string) *guildWriter {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	writer, found := store.writers[guildID]
+	if !found {
+		writer = &guildWriter{}
+		store.writers[guildID] = writer
+	}
+
+	return writer
+}
+
+// guildMappings returns the provided channel ID to role ID mappings in their
+// persisted form, sorted by channel ID.
+func guildMappings(channels map[string]string) []mapping {
+	mappings := make([]mapping, 0, len(channels))
+
+	for channelID, roleID := range channels {
+		mappings = append(mappings, mapping{ChannelID: channelID, RoleID: roleID})
+	}
+
+	sort.Slice(mappings, func(i, j int) bool {
+		return mappings[i].ChannelID < mappings[j].ChannelID
+	})
+
+	return mappings
+}
+
+// writeGuild writes the provided mappings to the file of the provided guild.
+// Guilds without mappings have their file removed.
+func (store *Store) writeGuild(guildID string, mappings []mapping) error {
+	if guildID == "" || filepath.Base(guildID) != guildID || guildID == "." || guildID == ".." {
+		return fmt.Errorf("unable to write role map file: invalid guild ID %q", guildID)
+	}
+
+	path := filepath.Join(store.dir, guildID+fileExtension)
+
+	if len(mappings) == 0 {
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to remove role map file: %w", err)
+		}
+
+		return nil
+	}
+
+	data, err := json.Marshal(mappings)
+	if err != nil {
+		return fmt.Errorf("unable to encode role map: %w", err)
+	}
+
+	err = atomicfile.WriteFile(path, data, filePermissions)
+	if err != nil {
+		return fmt.Errorf("unable to write role map file: %w", err)
+	}
+
+	return nil
+}
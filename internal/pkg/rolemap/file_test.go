@@ -0,0 +1,128 @@
+package rolemap_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/ewohltman/discordgo-mock/mockconstants"
+
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/rolemap"
+)
+
+func TestLoad(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "rolemap")
+
+	store, err := rolemap.Load(dir, func(err error) { t.Errorf("unexpected save error: %s", err) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.Set(mockconstants.TestGuild, mockconstants.TestChannel, mockconstants.TestRole)
+	store.Claim(mockconstants.TestGuild, mockconstants.TestChannel2, "testRole2")
+
+	reloaded, err := rolemap.Load(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	channels := reloaded.Channels(mockconstants.TestGuild)
+	if len(channels) != 2 || channels[mockconstants.TestChannel] != mockconstants.TestRole || channels[mockconstants.TestChannel2] != "testRole2" {
+		t.Errorf("unexpected reloaded channels: %+v", channels)
+	}
+
+	store.DeleteChannel(mockconstants.TestGuild, mockconstants.TestChannel)
+	store.DeleteRole(mockconstants.TestGuild, "testRole2")
+
+	_, err = os.Stat(filepath.Join(dir, mockconstants.TestGuild+".json"))
+	if !os.IsNotExist(err) {
+		t.Errorf("expected role map file of guild without mappings to be removed: %v", err)
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(matches) != 0 {
+		t.Errorf("unexpected temporary files left behind: %v", matches)
+	}
+}
+
+func TestLoad_saveError(t *testing.T) {
+	var (
+		store   *rolemap.Store
+		saveErr error
+	)
+
+	// Mappings are persisted without holding the lock, so lookups are never
+	// blocked by the disk
+	store, err := rolemap.Load(t.TempDir(), func(err error) {
+		saveErr = err
+		store.RoleID("../escape", mockconstants.TestChannel)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.Set("../escape", mockconstants.TestChannel, mockconstants.TestRole)
+
+	if saveErr == nil {
+		t.Error("expected error persisting invalid guild ID")
+	}
+
+	if roleID, _ := store.RoleID("../escape", mockconstants.TestChannel); roleID != mockconstants.TestRole {
+		t.Error("expected mapping to remain usable in memory")
+	}
+}
+
+func TestLoad_concurrentSaves(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := rolemap.Load(dir, func(err error) { t.Errorf("unexpected save error: %s", err) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const channels = 50
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < channels; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			store.Set(mockconstants.TestGuild, fmt.Sprintf("%s%d", mockconstants.TestChannel, i), mockconstants.TestRole)
+		}(i)
+	}
+
+	wg.Wait()
+
+	reloaded, err := rolemap.Load(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reloadedChannels := reloaded.Channels(mockconstants.TestGuild); len(reloadedChannels) != channels {
+		t.Errorf("unexpected number of reloaded channels: %d", len(reloadedChannels))
+	}
+}
+
+func TestLoad_invalidFile(t *testing.T) {
+	dir := t.TempDir()
+
+	err := ioutil.WriteFile(filepath.Join(dir, mockconstants.TestGuild+".json"), []byte("not json"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = rolemap.Load(dir, nil)
+	if err == nil {
+		t.Error("expected error loading invalid role map file")
+	}
+}
@@ -0,0 +1,208 @@
+// Package rolemap provides a store mapping voice channel IDs to the IDs of
+// the ephemeral roles created for them.
+package rolemap
+
+import (
+	"sync"
+)
+
+// Store is a mapping of voice channel IDs to ephemeral role IDs, partitioned
+// by guild ID. Mappings are kept in memory, and persisted to disk if the
+// *Store was returned by Load.
+type Store struct {
+	mutex    *sync.RWMutex
+	guilds   map[string]map[string]string
+	versions map[string]uint64
+
+	dir         string
+	onSaveError func(error)
+	writers     map[string]*guildWriter
+}
+
+// New returns a new, empty *Store keeping mappings in memory only.
+func New() *Store {
+	return &Store{
+		mutex:    &sync.RWMutex{},
+		guilds:   make(map[string]map[string]string),
+		versions: make(map[string]uint64),
+		writers:  make(map[string]*guildWriter),
+	}
+}
+
+// RoleID returns the ID of the ephemeral role mapped to the provided
+// channelID in the guild associated with the provided guildID.
+func (store *Store) RoleID(guildID, channelID string) (roleID string, found bool) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	roleID, found = store.guilds[guildID][channelID]
+
+	return roleID, found
+}
+
+// Channels returns a copy of the channel ID to role ID mappings for the guild
+// associated with the provided guildID.
+func (store *Store) Channels(guildID string) map[string]string {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	channels := make(map[string]string, len(store.guilds[guildID]))
+
+	for channelID, roleID := range store.guilds[guildID] {
+		channels[channelID] = roleID
+	}
+
+	return channels
+}
+
+// Set maps the provided channelID to the provided roleID in the guild
+// associated with the provided guildID.
+func (store *Store) Set(guildID, channelID, roleID string) {
+	store.update(guildID, func() bool {
+		channels := store.guildChannels(guildID)
+
+		if channels[channelID] == roleID {
+			return false
+		}
+
+		channels[channelID] = roleID
+
+		return true
+	})
+}
+
+// Claim maps the provided channelID to the provided roleID in the guild
+// associated with the provided guildID, unless roleID is already mapped to a
+// different channel. Claim reports whether the mapping was made.
+func (store *Store) Claim(guildID, channelID, roleID string) bool {
+	claimed := true
+
+	store.update(guildID, func() bool {
+		channels := store.guildChannels(guildID)
+
+		for mappedChannelID, mappedRoleID := range channels {
+			if mappedRoleID == roleID && mappedChannelID != channelID {
+				claimed = false
+				return false
+			}
+		}
+
+		if channels[channelID] == roleID {
+			return false
+		}
+
+		channels[channelID] = roleID
+
+		return true
+	})
+
+	return claimed
+}
+
+// Replace replaces all mappings for the guild associated with the provided
+// guildID with the provided channel ID to role ID mappings.
+func (store *Store) Replace(guildID string, channels map[string]string) {
+	replacement := make(map[string]string, len(channels))
+
+	for channelID, roleID := range channels {
+		replacement[channelID] = roleID
+	}
+
+	store.update(guildID, func() bool {
+		if equalMappings(store.guilds[guildID], replacement) {
+			return false
+		}
+
+		store.guilds[guildID] = replacement
+
+		return true
+	})
+}
+
+// DeleteChannel removes the mapping for the provided channelID in the guild
+// associated with the provided guildID.
+func (store *Store) DeleteChannel(guildID, channelID string) {
+	store.update(guildID, func() bool {
+		if _, found := store.guilds[guildID][channelID]; !found {
+			return false
+		}
+
+		delete(store.guilds[guildID], channelID)
+
+		return true
+	})
+}
+
+// DeleteRole removes any mapping to the provided roleID in the guild
+// associated with the provided guildID.
+func (store *Store) DeleteRole(guildID, roleID string) {
+	store.update(guildID, func() bool {
+		deleted := false
+
+		for channelID, mappedRoleID := range store.guilds[guildID] {
+			if mappedRoleID == roleID {
+				delete(store.guilds[guildID], channelID)
+
+				deleted = true
+			}
+		}
+
+		return deleted
+	})
+}
+
+// DeleteGuild removes all mappings for the guild associated with the provided
+// guildID.
+func (store *Store) DeleteGuild(guildID string) {
+	store.update(guildID, func() bool {
+		delete(store.guilds, guildID)
+
+		return true
+	})
+}
+
+// update calls the provided mutate function holding the write lock. If it
+// reports a change, the guild's mappings are persisted after the lock is
+// released, so a slow disk never blocks lookups or the mappings of other
+// guilds.
+func (store *Store) update(guildID string, mutate func() bool) {
+	store.mutex.Lock()
+
+	changed := mutate()
+	if changed {
+		store.versions[guildID]++
+	}
+
+	store.mutex.Unlock()
+
+	if changed {
+		store.save(guildID)
+	}
+}
+
+// guildChannels returns the mappings of the guild associated with the
+// provided guildID, adding the guild if it has none. The caller must hold
+// the write lock.
+func (store *Store) guildChannels(guildID string) map[string]string {
+	channels, found := store.guilds[guildID]
+	if !found {
+		channels = make(map[string]string)
+		store.guilds[guildID] = channels
+	}
+
+	return channels
+}
+
+func equalMappings(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for channelID, roleID := range a {
+		if mappedRoleID, found := b[channelID]; !found || mappedRoleID != roleID {
+			return false
+		}
+	}
+
+	return true
+}
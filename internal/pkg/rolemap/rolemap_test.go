@@ -0,0 +1,93 @@
+package rolemap_test
+
+import (
+	"testing"
+
+	"github.com/ewohltman/discordgo-mock/mockconstants"
+
+	"github.com/ewohltman/ephemeral-roles/internal/pkg/rolemap"
+)
+
+func TestNew(t *testing.T) {
+	if rolemap.New() == nil {
+		t.Fatal("unexpected nil store")
+	}
+}
+
+func TestStore_Set(t *testing.T) {
+	store := rolemap.New()
+
+	store.Set(mockconstants.TestGuild, mockconstants.TestChannel, mockconstants.TestRole)
+
+	roleID, found := store.RoleID(mockconstants.TestGuild, mockconstants.TestChannel)
+	if !found || roleID != mockconstants.TestRole {
+		t.Errorf("unexpected role ID: %q", roleID)
+	}
+
+	_, found = store.RoleID(mockconstants.TestGuildLarge, mockconstants.TestChannel)
+	if found {
+		t.Error("unexpected role ID found for other guild")
+	}
+}
+
+func TestStore_Claim(t *testing.T) {
+	store := rolemap.New()
+
+	if !store.Claim(mockconstants.TestGuild, mockconstants.TestChannel, mockconstants.TestRole) {
+		t.Error("unexpected failure claiming unmapped role")
+	}
+
+	if !store.Claim(mockconstants.TestGuild, mockconstants.TestChannel, mockconstants.TestRole) {
+		t.Error("unexpected failure claiming role mapped to the same channel")
+	}
+
+	if store.Claim(mockconstants.TestGuild, mockconstants.TestChannel2, mockconstants.TestRole) {
+		t.Error("unexpected success claiming role mapped to another channel")
+	}
+}
+
+func TestStore_Replace(t *testing.T) {
+	store := rolemap.New()
+
+	store.Set(mockconstants.TestGuild, mockconstants.TestChannel, mockconstants.TestRole)
+	store.Replace(mockconstants.TestGuild, map[string]string{
+		mockconstants.TestChannel2: mockconstants.TestRole,
+	})
+
+	_, found := store.RoleID(mockconstants.TestGuild, mockconstants.TestChannel)
+	if found {
+		t.Error("unexpected role ID found for replaced channel")
+	}
+
+	channels := store.Channels(mockconstants.TestGuild)
+	if len(channels) != 1 || channels[mockconstants.TestChannel2] != mockconstants.TestRole {
+		t.Errorf("unexpected channels: %+v", channels)
+	}
+}
+
+func TestStore_Delete(t *testing.T) {
+	store := rolemap.New()
+
+	store.Set(mockconstants.TestGuild, mockconstants.TestChannel, mockconstants.TestRole)
+	store.DeleteChannel(mockconstants.TestGuild, mockconstants.TestChannel)
+
+	_, found := store.RoleID(mockconstants.TestGuild, mockconstants.TestChannel)
+	if found {
+		t.Error("unexpected role ID found after DeleteChannel")
+	}
+
+	store.Set(mockconstants.TestGuild, mockconstants.TestChannel, mockconstants.TestRole)
+	store.DeleteRole(mockconstants.TestGuild, mockconstants.TestRole)
+
+	_, found = store.RoleID(mockconstants.TestGuild, mockconstants.TestChannel)
+	if found {
+		t.Error("unexpected role ID found after DeleteRole")
+	}
+
+	store.Set(mockconstants.TestGuild, mockconstants.TestChannel, mockconstants.TestRole)
+	store.DeleteGuild(mockconstants.TestGuild)
+
+	if len(store.Channels(mockconstants.TestGuild)) != 0 {
+		t.Error("unexpected channels found after DeleteGuild")
+	}
+}